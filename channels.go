@@ -0,0 +1,127 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// MessageChannels connects a connection to channels of messages. Messages
+// read from the connection are sent to the Incoming channel. Messages
+// received from the Outgoing channel are written to the connection. The Run
+// method runs the reader and writer until the connection ends.
+type MessageChannels struct {
+	c        *Conn
+	incoming chan Message
+	outgoing chan Message
+	done     chan struct{}
+
+	// closeWait is the time to wait for the peer's close message after
+	// the Outgoing channel is closed.
+	closeWait time.Duration
+}
+
+// NewMessageChannels returns message channels for the connection with the
+// specified channel buffer sizes.
+func NewMessageChannels(c *Conn, incomingSize, outgoingSize int) *MessageChannels {
+	return &MessageChannels{
+		c:        c,
+		incoming: make(chan Message, incomingSize),
+		outgoing:  make(chan Message, outgoingSize),
+		done:      make(chan struct{}),
+		closeWait: shutdownWriteWait,
+	}
+}
+
+// Incoming returns the channel of messages read from the connection. The
+// channel is closed when Run returns.
+func (mc *MessageChannels) Incoming() <-chan Message { return mc.incoming }
+
+// Outgoing returns the channel of messages to write to the connection. Close
+// the channel to send a close message with the normal closure code. Run then
+// waits up to one second for the peer's close message. Messages sent after
+// Run returns are not written; select on Done to avoid blocking.
+func (mc *MessageChannels) Outgoing() chan<- Message { return mc.outgoing }
+
+// Done returns a channel that is closed when Run returns.
+func (mc *MessageChannels) Done() <-chan struct{} { return mc.done }
+
+// Run reads and writes messages until the peer closes the connection, a
+// read or write fails, or ctx is done. Run closes the connection and the
+// Incoming channel before returning. The application must not call the
+// connection's read and write methods while Run is running. The
+// WriteControl method is an exception.
+//
+// Run returns nil when the peer closes the connection with the normal
+// closure or going away code or does not answer the close message sent
+// after Outgoing is closed, the context error when ctx is done, and the
+// read or write error otherwise.
+func (mc *MessageChannels) Run(ctx context.Context) error {
+	defer close(mc.done)
+
+	stop := make(chan struct{})
+	readErr := make(chan error, 1)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		defer close(mc.incoming)
+		for {
+			mt, p, err := mc.c.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case mc.incoming <- Message{Type: mt, Data: p}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	err := mc.write(ctx, readErr)
+	close(stop)
+	mc.c.Close()
+	<-readDone
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if isEndOfMessages(err) {
+		return nil
+	}
+	return err
+}
+
+// write writes outgoing messages until the reader stops, ctx is done or a
+// write fails. The result is the read or write error, or nil if ctx is done.
+func (mc *MessageChannels) write(ctx context.Context, readErr chan error) error {
+	outgoing := mc.outgoing
+	var closeTimeout <-chan time.Time
+	for {
+		select {
+		case m, ok := <-outgoing:
+			if !ok {
+				// Send a close message and wait for the peer to close.
+				mc.c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(shutdownWriteWait))
+				outgoing = nil
+				t := time.NewTimer(mc.closeWait)
+				defer t.Stop()
+				closeTimeout = t.C
+				continue
+			}
+			if err := mc.c.WriteMessage(m.Type, m.Data); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-closeTimeout:
+			return nil
+		case <-ctx.Done():
+			mc.c.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(shutdownWriteWait))
+			return nil
+		}
+	}
+}
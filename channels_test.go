@@ -0,0 +1,123 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMessageChannels(t *testing.T) {
+	runErr := make(chan error, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		mc := NewMessageChannels(ws, 1, 1)
+		go func() {
+			// Echo messages until the incoming channel is closed.
+			for m := range mc.Incoming() {
+				select {
+				case mc.Outgoing() <- m:
+				case <-mc.Done():
+				}
+			}
+		}()
+		runErr <- mc.Run(context.Background())
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for _, s := range []string{"one", "two", "three"} {
+		ws.WriteMessage(TextMessage, []byte(s))
+		if _, p, err := ws.ReadMessage(); err != nil || string(p) != s {
+			t.Fatalf("ReadMessage returned %q, %v, want %q", p, err, s)
+		}
+	}
+	ws.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run returned %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after close")
+	}
+}
+
+func TestMessageChannelsContext(t *testing.T) {
+	runErr := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// Nobody receives from Incoming, so the reader blocks on the send.
+		runErr <- NewMessageChannels(ws, 0, 0).Run(ctx)
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	ws.WriteMessage(TextMessage, []byte("hello"))
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); !IsCloseError(err, CloseGoingAway) {
+		t.Errorf("ReadMessage returned %v, want going away close error", err)
+	}
+	select {
+	case err := <-runErr:
+		if err != context.Canceled {
+			t.Errorf("Run returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestMessageChannelsCloseTimeout(t *testing.T) {
+	runErr := make(chan error, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		mc := NewMessageChannels(ws, 0, 0)
+		mc.closeWait = 20 * time.Millisecond
+		close(mc.outgoing)
+		runErr <- mc.Run(context.Background())
+	}))
+	defer s.Close()
+
+	// The client does not read, so it never answers the close message.
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run returned %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the close timeout")
+	}
+}
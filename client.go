@@ -6,6 +6,7 @@ package websocket
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
@@ -14,13 +15,34 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"syscall"
 	"time"
 )
 
-// ErrBadHandshake is returned when the server response to opening handshake is
-// invalid.
+// ErrBadHandshake matches the HandshakeError returned from Dial when the
+// server response to the opening handshake is invalid.
+//
+// Dial does not return ErrBadHandshake itself. Comparisons of the form
+// err == ErrBadHandshake do not match a rejected handshake. Use
+// IsBadHandshake to check for the error, or errors.Is in Go 1.13 and later.
+// Use a type assertion to HandshakeError, or errors.As in Go 1.13 and later,
+// to get the response status and the reason for the failure.
 var ErrBadHandshake = errors.New("websocket: bad handshake")
 
+// ErrResponseHeaderTooLarge is returned from Dial when the header of the
+// handshake response exceeds the Dialer's MaxResponseHeaderBytes.
+var ErrResponseHeaderTooLarge = errors.New("websocket: handshake response header too large")
+
+// IsBadHandshake returns true if err is ErrBadHandshake or a HandshakeError
+// for a handshake response rejected by Dial.
+func IsBadHandshake(err error) bool {
+	if err == ErrBadHandshake {
+		return true
+	}
+	e, ok := err.(HandshakeError)
+	return ok && e.Is(ErrBadHandshake)
+}
+
 var errInvalidCompression = errors.New("websocket: invalid compression negotiation")
 
 // NewClient creates a new client connection using the given net connection.
@@ -29,9 +51,9 @@ var errInvalidCompression = errors.New("websocket: invalid compression negotiati
 // (Cookie). Use the response.Header to get the selected subprotocol
 // (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
 //
-// If the WebSocket handshake fails, ErrBadHandshake is returned along with a
-// non-nil *http.Response so that callers can handle redirects, authentication,
-// etc.
+// If the WebSocket handshake fails, a HandshakeError matching ErrBadHandshake
+// is returned along with a non-nil *http.Response so that callers can handle
+// redirects, authentication, etc. See ErrBadHandshake.
 //
 // Deprecated: Use Dialer instead.
 func NewClient(netConn net.Conn, u *url.URL, requestHeader http.Header, readBufSize, writeBufSize int) (c *Conn, response *http.Response, err error) {
@@ -51,41 +73,311 @@ type Dialer struct {
 	// NetDial is nil, net.Dial is used.
 	NetDial func(network, addr string) (net.Conn, error)
 
+	// LocalAddr specifies the local address for TCP connections, typically
+	// a *net.TCPAddr with the port set to zero. Use LocalAddr to select the
+	// source IP address on a multi-homed host. LocalAddr is ignored when
+	// NetDial is set.
+	LocalAddr net.Addr
+
+	// LocalInterface specifies the name of the network interface for TCP
+	// connections. The source IP address is set to the first address of the
+	// interface in the address family of the server or proxy. If the host is
+	// a name, then the connection is tried from the first IPv4 address and
+	// then from the first IPv6 address of the interface. LocalInterface is
+	// ignored when NetDial or LocalAddr is set.
+	LocalInterface string
+
+	// Control specifies an optional function to set socket options on the
+	// network connection to the server or proxy. The function is called
+	// after the socket is created and before the socket is connected. See
+	// net.Dialer Control for a description of the arguments. Control is
+	// ignored when NetDial is set.
+	//
+	// Control requires Go 1.11 or later.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// UnixSocket specifies the path of a Unix domain socket to connect to
+	// instead of the host in the URL. The URL host is used for the Host
+	// header and TLS server name. The Proxy, LocalAddr and LocalInterface
+	// fields are ignored when UnixSocket is set. If NetDial is set, NetDial
+	// is called with the "unix" network and the socket path.
+	UnixSocket string
+
+	// DialAddr specifies an optional function that returns the network
+	// address to dial for the host and port in the URL. Use DialAddr to pin
+	// DNS answers, to resolve the host with a custom resolver or to connect
+	// to a specific IP address. The URL host is used for the Host header and
+	// TLS server name. When a proxy is used, the returned address is the
+	// target of the proxy connection.
+	DialAddr func(hostPort string) (addr string, err error)
+
 	// Proxy specifies a function to return a proxy for a given
 	// Request. If the function returns a non-nil error, the
 	// request is aborted with the provided error.
 	// If Proxy is nil or returns a nil *URL, no proxy is used.
 	Proxy func(*http.Request) (*url.URL, error)
 
+	// ProxyTLSClientConfig specifies the TLS configuration to use for the
+	// connection to an https proxy. If nil, the default configuration is
+	// used. The connection to the proxy is encrypted with TLS before the
+	// CONNECT request is sent.
+	ProxyTLSClientConfig *tls.Config
+
+	// ProxyAuth specifies an optional function to authenticate with an http
+	// or https proxy. When the proxy responds to the CONNECT request with
+	// status 407 Proxy Authentication Required, ProxyAuth is called with the
+	// proxy URL and the response. The response includes the
+	// Proxy-Authenticate challenge and the CONNECT request.
+	//
+	// ProxyAuth returns the value of the Proxy-Authorization header for a
+	// new CONNECT request. If ProxyAuth returns the empty string, then the
+	// dial fails. If ProxyAuth returns a non-nil error, the dial is aborted
+	// with the error.
+	ProxyAuth func(proxyURL *url.URL, resp *http.Response) (string, error)
+
 	// TLSClientConfig specifies the TLS configuration to use with tls.Client.
-	// If nil, the default configuration is used.
+	// If nil, the default configuration is used. Set NextProtos in the
+	// configuration to negotiate an application protocol with ALPN.
 	TLSClientConfig *tls.Config
 
+	// TLSHandshakeContext specifies an optional function to run the TLS
+	// handshake for wss URLs. The function is called with the dial context,
+	// the network connection and a copy of TLSClientConfig with ServerName
+	// set. The function returns the connection for the WebSocket handshake.
+	// The function is responsible for verifying the server certificate.
+	//
+	// If the function returns a *tls.Conn, then the connection state is
+	// available from Conn.TLSConnectionState and the response TLS field.
+	TLSHandshakeContext func(ctx context.Context, netConn net.Conn, config *tls.Config) (net.Conn, error)
+
+	// GetClientCertificate specifies an optional function to select the
+	// client certificate for wss URLs. The function is called with the URL
+	// of the dial attempt when the server requests a certificate. If set, the
+	// function replaces TLSClientConfig.GetClientCertificate and
+	// TLSClientConfig.Certificates for the connection. Use this function to
+	// present different identities to different servers with one Dialer.
+	//
+	// GetClientCertificate requires Go 1.8 or later.
+	GetClientCertificate func(u *url.URL, info *tls.CertificateRequestInfo) (*tls.Certificate, error)
+
 	// HandshakeTimeout specifies the duration for the handshake to complete.
 	HandshakeTimeout time.Duration
 
+	// ConnectTimeout, TLSHandshakeTimeout and UpgradeTimeout specify the
+	// duration for each phase of the handshake to complete: connecting to
+	// the server or proxy, the TLS handshake for wss URLs and the HTTP
+	// upgrade exchange. A phase timeout does not extend the HandshakeTimeout
+	// or context deadline. If a phase timeout is zero, then the phase is
+	// limited by the HandshakeTimeout and context deadline only.
+	ConnectTimeout, TLSHandshakeTimeout, UpgradeTimeout time.Duration
+
+	// Trace specifies optional hooks to run at stages of the opening
+	// handshake. A trace set on the dial context with WithDialTrace is used
+	// instead of this field.
+	Trace *DialTrace
+
 	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes. If a buffer
 	// size is zero, then a useful default size is used. The I/O buffer sizes
 	// do not limit the size of the messages that can be sent or received.
 	ReadBufferSize, WriteBufferSize int
 
+	// ReadBufferPool specifies an optional pool of []byte message buffers
+	// used by the connection's ReadMessageInto method to grow a buffer that
+	// is too small for a message.
+	ReadBufferPool BufferPool
+
+	// WriteBufferPool is a pool of buffers for write operations. If the value
+	// is not set, then write buffers are allocated to the connection for the
+	// lifetime of the connection.
+	//
+	// A pool is most useful when the application has a modest volume of writes
+	// across a large number of connections.
+	//
+	// Applications should use a single pool for each unique value of
+	// WriteBufferSize.
+	WriteBufferPool BufferPool
+
 	// Subprotocols specifies the client's requested subprotocols.
 	Subprotocols []string
 
+	// Codecs specifies codecs for subprotocols. The codec subprotocols are
+	// requested after the subprotocols in the Subprotocols field. If the
+	// server selects a codec subprotocol, then the codec is set on the
+	// returned connection.
+	Codecs []SubprotocolCodec
+
 	// EnableCompression specifies if the client should attempt to negotiate
 	// per message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
 
+	// CompressionParams specifies the permessage-deflate parameters offered
+	// when EnableCompression is set. The parameters accepted by the server
+	// are validated against the offer. If nil, the client offers the
+	// server_no_context_takeover and client_no_context_takeover parameters.
+	CompressionParams *CompressionParams
+
+	// Extensions specifies extensions to offer in addition to per message
+	// compression. The offers are sent in order of preference after the
+	// permessage-deflate offer. Use Conn.Extensions to get the extensions
+	// accepted by the server. The application is responsible for
+	// implementing the accepted extensions.
+	Extensions []Extension
+
 	// Jar specifies the cookie jar.
 	// If Jar is nil, cookies are not sent in requests and ignored
 	// in responses.
 	Jar http.CookieJar
+
+	// SignURL specifies an optional function to sign the URL immediately
+	// before the handshake. The function is called with the parsed ws or wss
+	// URL and the current time. The function can modify the URL, typically
+	// to add a signature, expiry time or token to the query. If the function
+	// returns a non-nil error, the dial is aborted with the error.
+	//
+	// Because the function is called for every dial, the signature is fresh
+	// when the application redials with the same Dialer.
+	SignURL func(u *url.URL, at time.Time) error
+
+	// HeaderFunc specifies an optional function to return request header
+	// fields for the opening handshake. The function is called with the dial
+	// context and the URL on every dial attempt, including redirects and
+	// retries, so that the application can add credentials that expire, such
+	// as a fresh Authorization token. The returned fields replace fields with
+	// the same name in the request header passed to Dial.
+	HeaderFunc func(ctx context.Context, u *url.URL) (http.Header, error)
+
+	// Credentials specifies an optional provider of a token sent with the
+	// opening handshake. The provider is consulted on every dial attempt,
+	// including redirects and retries, and on every reconnect of a
+	// ReconnectingConn. The token is sent in the Authorization header as a
+	// bearer token unless CredentialSubprotocolPrefix is set. The
+	// Authorization header replaces the header set by HeaderFunc or passed to
+	// Dial.
+	Credentials CredentialProvider
+
+	// CredentialSubprotocolPrefix specifies that the token from Credentials
+	// is sent as the subprotocol formed by the prefix followed by the token.
+	// Use this for servers that accept credentials from browsers, which
+	// cannot set the Authorization header. The subprotocol is offered after
+	// the subprotocols in the Subprotocols and Codecs fields and must be a
+	// valid HTTP token.
+	CredentialSubprotocolPrefix string
+
+	// FollowRedirects specifies whether Dial follows redirect responses to
+	// the opening handshake. When a redirect is followed, the handshake is
+	// sent to the new location with the same request header, except that
+	// the Authorization, Cookie and Host headers are removed when the
+	// redirect is to a different host. Cookies from the Jar are added for the
+	// new location.
+	FollowRedirects bool
+
+	// MaxRedirects specifies the maximum number of redirects followed when
+	// FollowRedirects is set. If zero, then a default of 10 is used.
+	MaxRedirects int
+
+	// Authenticate specifies an optional function to answer authentication
+	// challenges. When the server responds to the opening handshake with
+	// status 401 Unauthorized or 407 Proxy Authentication Required,
+	// Authenticate is called with the response. The response includes the
+	// WWW-Authenticate or Proxy-Authenticate challenge and the handshake
+	// request.
+	//
+	// Authenticate returns header fields, typically Authorization or
+	// Proxy-Authorization, to set on a new handshake request. If Authenticate
+	// returns a nil header, then the dial fails with the handshake error. If
+	// Authenticate returns a non-nil error, the dial is aborted with the
+	// error. Use ProxyAuth for challenges to the CONNECT request sent to a
+	// proxy.
+	Authenticate func(resp *http.Response) (http.Header, error)
+
+	// PingInterval specifies the interval for sending pings on the returned
+	// connection. If PingInterval is greater than zero, then a goroutine
+	// sends a ping every PingInterval and closes the connection if a pong is
+	// not received within PongTimeout of the ping. After the keepalive closes
+	// the connection, the read methods return ErrKeepaliveTimeout.
+	//
+	// Pongs are processed by the read methods. The application must read the
+	// connection for the keepalive to receive pongs. The pong handler is
+	// called as usual. The keepalive sets the read deadline of the network
+	// connection and extends it when a pong is received, so a read blocked
+	// on an unresponsive peer returns ErrKeepaliveTimeout. An earlier
+	// deadline set with SetReadDeadline takes precedence.
+	PingInterval time.Duration
+
+	// PongTimeout specifies the time to wait for a pong after a keepalive
+	// ping. If zero, then PingInterval is used.
+	PongTimeout time.Duration
+
+	// ErrorBodyLimit specifies the maximum number of bytes of the response
+	// body returned when the handshake fails. The body is read into memory
+	// before the network connection is closed. If zero, then a default of
+	// 1024 bytes is used. If negative, then the body is not read.
+	ErrorBodyLimit int
+
+	// MaxResponseHeaderBytes specifies the maximum number of bytes read from
+	// the connection for the status line and header of the handshake
+	// response. The limit includes data buffered with the header. The limit
+	// also applies to the response to the CONNECT request sent to an HTTP
+	// proxy. If zero, then a default of 1 MB is used. If negative, then there
+	// is no limit.
+	MaxResponseHeaderBytes int64
+
+	// RetryPolicy specifies how Dial retries transient failures. If nil, Dial
+	// does not retry.
+	RetryPolicy *RetryPolicy
 }
 
 var errMalformedURL = errors.New("malformed ws or wss URL")
 
+func (d *Dialer) compressionParams() *CompressionParams {
+	if d.CompressionParams == nil {
+		return &defaultCompressionParams
+	}
+	return d.CompressionParams
+}
+
+// offeredExtension returns true if the Dialer offers the extension name.
+func (d *Dialer) offeredExtension(name string) bool {
+	for _, e := range d.Extensions {
+		if strings.EqualFold(e.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsHandshake runs the TLS handshake for a wss URL over netConn and returns
+// the secured connection.
+func (d *Dialer) tlsHandshake(ctx context.Context, netConn net.Conn, cfg *tls.Config) (net.Conn, error) {
+	if d.TLSHandshakeContext != nil {
+		return d.TLSHandshakeContext(ctx, netConn, cfg)
+	}
+	tlsConn := tls.Client(netConn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	if !cfg.InsecureSkipVerify {
+		if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
+			return nil, err
+		}
+	}
+	return tlsConn, nil
+}
+
+// phaseDeadline returns the deadline for a handshake phase with the given
+// timeout. The phase deadline does not extend the handshake deadline.
+func phaseDeadline(deadline time.Time, timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return deadline
+	}
+	t := time.Now().Add(timeout)
+	if deadline.Equal(time.Time{}) || t.Before(deadline) {
+		return t
+	}
+	return deadline
+}
+
 func hostPortNoPort(u *url.URL) (hostPort, hostNoPort string) {
 	hostPort = u.Host
 	hostNoPort = u.Host
@@ -104,6 +396,115 @@ func hostPortNoPort(u *url.URL) (hostPort, hostNoPort string) {
 	return hostPort, hostNoPort
 }
 
+// interfaceAddrs returns the first IPv4 address and the first IPv6 address
+// of the Dialer's LocalInterface. One of the addresses may be nil.
+func (d *Dialer) interfaceAddrs() (ip4, ip6 net.IP, err error) {
+	ifi, err := net.InterfaceByName(d.LocalInterface)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			if ip4 == nil {
+				ip4 = ipNet.IP
+			}
+		} else if ip6 == nil {
+			ip6 = ipNet.IP
+		}
+	}
+	if ip4 == nil && ip6 == nil {
+		return nil, nil, errors.New("websocket: no address for interface " + d.LocalInterface)
+	}
+	return ip4, ip6, nil
+}
+
+// dialInterface dials the TCP address addr from the interface address ip4 or
+// ip6 that matches the address family of the host in addr. If the host is a
+// name, then the dial is tried from ip4 and then from ip6.
+func (d *Dialer) dialInterface(ctx context.Context, netDialer *net.Dialer, ip4, ip6 net.IP, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	families := []struct {
+		network string
+		ip      net.IP
+	}{{"tcp4", ip4}, {"tcp6", ip6}}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			families = families[:1]
+		} else {
+			families = families[1:]
+		}
+	}
+	err = errors.New("websocket: interface " + d.LocalInterface + " does not have an address for " + host)
+	for _, f := range families {
+		if f.ip == nil {
+			continue
+		}
+		nd := *netDialer
+		nd.LocalAddr = &net.TCPAddr{IP: f.ip}
+		var c net.Conn
+		c, err = nd.DialContext(ctx, f.network, addr)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// NewClientConn runs the client opening handshake over rwc and returns the
+// connection. Use NewClientConn to connect over a transport that the Dialer
+// cannot dial, such as an in-memory pipe or a stream multiplexed over another
+// connection. If rwc is a net.Conn, then the connection addresses and
+// deadlines are taken from rwc. Otherwise, the Dialer's timeouts are not
+// applied and the connection does not support deadlines.
+//
+// If the URL scheme is wss, then a TLS handshake is run over rwc before the
+// opening handshake. Use the ws scheme if rwc is already secured. The Dialer's
+// dial, proxy, redirect and retry fields are not used.
+//
+// On failure, rwc is closed. See Dial for a description of the other arguments
+// and results.
+func (d *Dialer) NewClientConn(rwc io.ReadWriteCloser, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &nilDialer
+	}
+	netConn, ok := rwc.(net.Conn)
+	dd := *d
+	if !ok {
+		netConn = &rwcConn{rwc: rwc}
+		dd.HandshakeTimeout = 0
+		dd.ConnectTimeout = 0
+		dd.TLSHandshakeTimeout = 0
+		dd.UpgradeTimeout = 0
+	}
+	dialed := false
+	dd.NetDial = func(network, addr string) (net.Conn, error) {
+		dialed = true
+		return netConn, nil
+	}
+	dd.DialAddr = nil
+	dd.Proxy = nil
+	dd.FollowRedirects = false
+	dd.RetryPolicy = nil
+	conn, resp, err := dd.dial(context.Background(), urlStr, requestHeader)
+	if err != nil && !dialed {
+		// The dial method closes the connection on failures after the
+		// connection is dialed. Close rwc on failures before that point.
+		rwc.Close()
+	}
+	return conn, resp, err
+}
+
 // DefaultDialer is a dialer with all fields set to the default values.
 var DefaultDialer = &Dialer{
 	Proxy:            http.ProxyFromEnvironment,
@@ -113,29 +514,44 @@ var DefaultDialer = &Dialer{
 // nilDialer is dialer to use when receiver is nil.
 var nilDialer Dialer = *DefaultDialer
 
-// Dial creates a new client connection. Use requestHeader to specify the
-// origin (Origin), subprotocols (Sec-WebSocket-Protocol) and cookies (Cookie).
-// Use the response.Header to get the selected subprotocol
-// (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
-//
-// If the WebSocket handshake fails, ErrBadHandshake is returned along with a
-// non-nil *http.Response so that callers can handle redirects, authentication,
-// etcetera. The response body may not contain the entire response and does not
-// need to be closed by the application.
-func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
-
-	if d == nil {
-		d = &nilDialer
-	}
-
+// newHandshakeRequest returns the opening handshake request for urlStr and
+// the challenge key sent in the request.
+func (d *Dialer) newHandshakeRequest(ctx context.Context, urlStr string, requestHeader http.Header) (*http.Request, string, error) {
 	challengeKey, err := generateChallengeKey()
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
 
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
+	}
+
+	if d.SignURL != nil {
+		if err := d.SignURL(u, time.Now()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if d.HeaderFunc != nil {
+		target := *u
+		h, err := d.HeaderFunc(ctx, &target)
+		if err != nil {
+			return nil, "", err
+		}
+		requestHeader = mergeHeader(requestHeader, h)
+	}
+
+	var credentialProtocol string
+	if d.Credentials != nil {
+		var h http.Header
+		h, credentialProtocol, err = d.credential(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		if h != nil {
+			requestHeader = mergeHeader(requestHeader, h)
+		}
 	}
 
 	switch u.Scheme {
@@ -144,12 +560,12 @@ func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Re
 	case "wss":
 		u.Scheme = "https"
 	default:
-		return nil, nil, errMalformedURL
+		return nil, "", errMalformedURL
 	}
 
 	if u.User != nil {
 		// User name and password are not allowed in websocket URIs.
-		return nil, nil, errMalformedURL
+		return nil, "", errMalformedURL
 	}
 
 	req := &http.Request{
@@ -177,8 +593,12 @@ func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Re
 	req.Header["Connection"] = []string{"Upgrade"}
 	req.Header["Sec-WebSocket-Key"] = []string{challengeKey}
 	req.Header["Sec-WebSocket-Version"] = []string{"13"}
-	if len(d.Subprotocols) > 0 {
-		req.Header["Sec-WebSocket-Protocol"] = []string{strings.Join(d.Subprotocols, ", ")}
+	subprotocols := appendCodecSubprotocols(d.Subprotocols, d.Codecs)
+	if credentialProtocol != "" {
+		subprotocols = append(subprotocols[:len(subprotocols):len(subprotocols)], credentialProtocol)
+	}
+	if len(subprotocols) > 0 {
+		req.Header["Sec-WebSocket-Protocol"] = []string{strings.Join(subprotocols, ", ")}
 	}
 	for k, vs := range requestHeader {
 		switch {
@@ -191,8 +611,8 @@ func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Re
 			k == "Sec-Websocket-Key" ||
 			k == "Sec-Websocket-Version" ||
 			k == "Sec-Websocket-Extensions" ||
-			(k == "Sec-Websocket-Protocol" && len(d.Subprotocols) > 0):
-			return nil, nil, errors.New("websocket: duplicate header not allowed: " + k)
+			(k == "Sec-Websocket-Protocol" && len(subprotocols) > 0):
+			return nil, "", errors.New("websocket: duplicate header not allowed: " + k)
 		case k == "Sec-Websocket-Protocol":
 			req.Header["Sec-WebSocket-Protocol"] = vs
 		default:
@@ -200,31 +620,231 @@ func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Re
 		}
 	}
 
+	var offers []string
 	if d.EnableCompression {
-		req.Header["Sec-WebSocket-Extensions"] = []string{"permessage-deflate; server_no_context_takeover; client_no_context_takeover"}
+		p := d.compressionParams()
+		if err := p.validate(); err != nil {
+			return nil, "", err
+		}
+		offers = append(offers, p.offer())
+	}
+	if len(d.Extensions) > 0 {
+		s, err := formatExtensionOffers(d.Extensions)
+		if err != nil {
+			return nil, "", err
+		}
+		offers = append(offers, s)
+	}
+	if len(offers) > 0 {
+		req.Header["Sec-WebSocket-Extensions"] = []string{strings.Join(offers, ", ")}
+	}
+	return req, challengeKey, nil
+}
+
+// Dial creates a new client connection by calling DialContext with a
+// background context.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, requestHeader)
+}
+
+// DialContext creates a new client connection. Use requestHeader to specify
+// the origin (Origin), subprotocols (Sec-WebSocket-Protocol) and cookies
+// (Cookie). Use the response.Header to get the selected subprotocol
+// (Sec-WebSocket-Protocol) and cookies (Set-Cookie). For wss URLs, the
+// response.TLS field is set to the state of the TLS connection. The
+// negotiated ALPN protocol is in response.TLS.NegotiatedProtocol.
+//
+// The context is used while dialing the network connection and its deadline,
+// if any, applies to the opening handshake. Canceling the context interrupts
+// the opening handshake. Once the connection is established, the context does
+// not affect the connection.
+//
+// If the WebSocket handshake fails, a HandshakeError matching ErrBadHandshake
+// is returned along with a non-nil *http.Response so that callers can handle
+// redirects, authentication, etcetera. See ErrBadHandshake for how to check
+// for the error. The response body holds up to ErrorBodyLimit bytes of the
+// body in memory and does not need to be closed by the application. If
+// FollowRedirects is set, then redirect responses are followed. If
+// Authenticate is set, then authentication challenges are answered. If
+// RetryPolicy is set, then failed dials are retried.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &nilDialer
+	}
+
+	if d.RetryPolicy != nil {
+		return d.RetryPolicy.dial(ctx, d, urlStr, requestHeader)
+	}
+	return d.dialFollow(ctx, urlStr, requestHeader)
+}
+
+// dialFollow dials the URL, following redirects and answering authentication
+// challenges as configured by the Dialer.
+func (d *Dialer) dialFollow(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	maxRedirects := d.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	redirects, authAttempts := 0, 0
+	conn, resp, err := d.dial(ctx, urlStr, requestHeader)
+	for IsBadHandshake(err) {
+		switch {
+		case d.Authenticate != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusProxyAuthRequired):
+			if authAttempts >= maxAuthAttempts {
+				return conn, resp, err
+			}
+			authAttempts++
+			h, authErr := d.Authenticate(resp)
+			if authErr != nil {
+				return nil, resp, authErr
+			}
+			if h == nil {
+				return conn, resp, err
+			}
+			requestHeader = mergeHeader(requestHeader, h)
+		case d.FollowRedirects:
+			if redirects >= maxRedirects {
+				return conn, resp, err
+			}
+			redirects++
+			var ok bool
+			urlStr, requestHeader, ok = redirectLocation(resp, requestHeader)
+			if !ok {
+				return conn, resp, err
+			}
+		default:
+			return conn, resp, err
+		}
+		conn, resp, err = d.dial(ctx, urlStr, requestHeader)
+	}
+	return conn, resp, err
+}
+
+const defaultMaxRedirects = 10
+
+// maxAuthAttempts is the maximum number of handshake requests sent with
+// header fields from the Dialer.Authenticate function.
+const maxAuthAttempts = 4
+
+// mergeHeader returns a copy of header with the fields in h set.
+func mergeHeader(header, h http.Header) http.Header {
+	merged := make(http.Header, len(header)+len(h))
+	for k, vs := range header {
+		merged[k] = vs
+	}
+	for k, vs := range h {
+		merged[http.CanonicalHeaderKey(k)] = vs
+	}
+	return merged
+}
+
+// redirectLocation returns the ws or wss URL and request header for following
+// a redirect response. The ok result is false if resp is not a redirect.
+func redirectLocation(resp *http.Response, requestHeader http.Header) (urlStr string, header http.Header, ok bool) {
+	switch resp.StatusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return "", nil, false
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return "", nil, false
+	}
+	u, err := resp.Request.URL.Parse(loc)
+	if err != nil {
+		return "", nil, false
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", nil, false
+	}
+
+	header = requestHeader
+	if !strings.EqualFold(u.Host, resp.Request.URL.Host) {
+		// Do not send credentials to a different host.
+		header = make(http.Header, len(requestHeader))
+		for k, vs := range requestHeader {
+			switch k {
+			case "Authorization", "Www-Authenticate", "Cookie", "Cookie2", "Host":
+			default:
+				header[k] = vs
+			}
+		}
+	}
+	return u.String(), header, true
+}
+
+func (d *Dialer) dial(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	req, challengeKey, err := d.newHandshakeRequest(ctx, urlStr, requestHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := req.URL
+
+	trace := d.Trace
+	if t := ContextDialTrace(ctx); t != nil {
+		trace = t
 	}
 
-	var deadline time.Time
 	if d.HandshakeTimeout != 0 {
-		deadline = time.Now().Add(d.HandshakeTimeout)
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	var deadline time.Time
+	if t, ok := ctx.Deadline(); ok {
+		deadline = t
+	}
+
+	connectCtx := ctx
+	connectDeadline := phaseDeadline(deadline, d.ConnectTimeout)
+	if d.ConnectTimeout != 0 {
+		var cancel func()
+		connectCtx, cancel = context.WithDeadline(ctx, connectDeadline)
+		defer cancel()
 	}
 
 	// Get network dial function.
 	netDial := d.NetDial
 	if netDial == nil {
-		netDialer := &net.Dialer{Deadline: deadline}
-		netDial = netDialer.Dial
+		netDialer := &net.Dialer{}
+		if d.UnixSocket == "" {
+			netDialer.LocalAddr = d.LocalAddr
+		}
+		if d.Control != nil {
+			if err := setDialerControl(netDialer, d.Control); err != nil {
+				return nil, nil, err
+			}
+		}
+		netCtx := trace.netContext(connectCtx)
+		netDial = func(network, addr string) (net.Conn, error) {
+			return netDialer.DialContext(netCtx, network, addr)
+		}
+		if d.UnixSocket == "" && d.LocalAddr == nil && d.LocalInterface != "" {
+			ip4, ip6, err := d.interfaceAddrs()
+			if err != nil {
+				return nil, nil, err
+			}
+			netDial = func(network, addr string) (net.Conn, error) {
+				return d.dialInterface(netCtx, netDialer, ip4, ip6, addr)
+			}
+		}
 	}
 
 	// If needed, wrap the dial function to set the connection deadline.
-	if !deadline.Equal(time.Time{}) {
+	if !connectDeadline.Equal(time.Time{}) {
 		forwardDial := netDial
 		netDial = func(network, addr string) (net.Conn, error) {
 			c, err := forwardDial(network, addr)
 			if err != nil {
 				return nil, err
 			}
-			err = c.SetDeadline(deadline)
+			err = c.SetDeadline(connectDeadline)
 			if err != nil {
 				c.Close()
 				return nil, err
@@ -234,12 +854,26 @@ func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Re
 	}
 
 	// If needed, wrap the dial function to connect through a proxy.
-	if d.Proxy != nil {
+	if d.Proxy != nil && d.UnixSocket == "" {
 		proxyURL, err := d.Proxy(req)
 		if err != nil {
 			return nil, nil, err
 		}
-		if proxyURL != nil {
+		if proxyURL != nil && (proxyURL.Scheme == "http" || proxyURL.Scheme == "https") {
+			dialer := &httpProxyDialer{
+				proxyURL:       proxyURL,
+				fowardDial:     netDial,
+				auth:           d.ProxyAuth,
+				maxHeaderBytes: d.maxResponseHeaderBytes(),
+			}
+			if proxyURL.Scheme == "https" {
+				dialer.tlsConfig = d.ProxyTLSClientConfig
+				if dialer.tlsConfig == nil {
+					dialer.tlsConfig = &tls.Config{}
+				}
+			}
+			netDial = dialer.Dial
+		} else if proxyURL != nil {
 			dialer, err := proxy_FromURL(proxyURL, netDialerFunc(netDial))
 			if err != nil {
 				return nil, nil, err
@@ -249,82 +883,254 @@ func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Re
 	}
 
 	hostPort, hostNoPort := hostPortNoPort(u)
-	netConn, err := netDial("tcp", hostPort)
+	network, addr := "tcp", hostPort
+	if d.UnixSocket != "" {
+		network, addr = "unix", d.UnixSocket
+	} else if d.DialAddr != nil {
+		addr, err = d.DialAddr(hostPort)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	trace.connectStart(network, addr)
+	netConn, err := netDial(network, addr)
+	trace.connectDone(network, addr, err)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	var conn *Conn
 	defer func() {
-		if netConn != nil {
+		if conn != nil && netConn != nil {
+			conn.Close()
+		} else if netConn != nil {
 			netConn.Close()
 		}
 	}()
 
+	// setPhaseDeadline sets the connection deadline for the next phase of the
+	// handshake.
+	currentDeadline := connectDeadline
+	setPhaseDeadline := func(timeout time.Duration) error {
+		t := phaseDeadline(deadline, timeout)
+		if t.Equal(currentDeadline) {
+			return nil
+		}
+		currentDeadline = t
+		return netConn.SetDeadline(t)
+	}
+
 	if u.Scheme == "https" {
+		if err := setPhaseDeadline(d.TLSHandshakeTimeout); err != nil {
+			return nil, nil, err
+		}
 		cfg := cloneTLSConfig(d.TLSClientConfig)
 		if cfg.ServerName == "" {
 			cfg.ServerName = hostNoPort
 		}
-		tlsConn := tls.Client(netConn, cfg)
-		netConn = tlsConn
-		if err := tlsConn.Handshake(); err != nil {
-			return nil, nil, err
-		}
-		if !cfg.InsecureSkipVerify {
-			if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
+		if d.GetClientCertificate != nil {
+			target := *u
+			target.Scheme = "wss"
+			if err := setClientCertificate(cfg, d.GetClientCertificate, &target); err != nil {
 				return nil, nil, err
 			}
 		}
+		tlsCtx := ctx
+		if d.TLSHandshakeTimeout != 0 {
+			var cancel func()
+			tlsCtx, cancel = context.WithDeadline(ctx, currentDeadline)
+			defer cancel()
+		}
+		trace.tlsHandshakeStart()
+		tlsConn, err := d.tlsHandshake(tlsCtx, netConn, cfg)
+		trace.tlsHandshakeDone(tlsConn, err)
+		if err != nil {
+			return nil, nil, err
+		}
+		netConn = tlsConn
 	}
 
-	conn := newConn(netConn, false, d.ReadBufferSize, d.WriteBufferSize)
-
-	if err := req.Write(netConn); err != nil {
+	if err := setPhaseDeadline(d.UpgradeTimeout); err != nil {
 		return nil, nil, err
 	}
 
-	resp, err := http.ReadResponse(conn.br, req)
+	conn = newConnBRW(netConn, false, d.ReadBufferSize, d.WriteBufferSize, d.WriteBufferPool, nil)
+
+	var hlr *headerLimitReader
+	if limit := d.maxResponseHeaderBytes(); limit > 0 {
+		hlr = &headerLimitReader{r: netConn, n: limit}
+		conn.br.Reset(hlr)
+	}
+
+	stopWatch := watchContext(ctx, netConn)
+	err = req.Write(netConn)
+	trace.wroteRequest(err)
+	var resp *http.Response
+	if err == nil {
+		resp, err = http.ReadResponse(conn.br, req)
+	}
+	if stopWatch() {
+		return nil, nil, ctx.Err()
+	}
+	if hlr != nil {
+		if hlr.exceeded {
+			return nil, nil, ErrResponseHeaderTooLarge
+		}
+		hlr.n = -1
+	}
 	if err != nil {
 		return nil, nil, err
 	}
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		resp.TLS = &state
+	}
+	trace.gotResponse(resp)
+
+	if err := d.checkHandshakeResponse(conn, resp, challengeKey); err != nil {
+		return nil, resp, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader([]byte{}))
+
+	netConn.SetDeadline(time.Time{})
+	netConn = nil // to avoid close in defer.
+	if d.PingInterval > 0 {
+		conn.startKeepalive(d.PingInterval, d.PongTimeout)
+	}
+	return conn, resp, nil
+}
+
+// aLongTimeAgo is a non-zero time in the past used to interrupt blocked
+// reads and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchContext sets a deadline in the past on netConn when ctx is done to
+// interrupt blocked reads and writes. The returned function stops the watch
+// and reports whether ctx interrupted the connection.
+func watchContext(ctx context.Context, netConn net.Conn) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	stop := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			netConn.SetDeadline(aLongTimeAgo)
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(stop)
+		return <-interrupted
+	}
+}
+
+const defaultErrorBodyLimit = 1024
+
+const defaultMaxResponseHeaderBytes = 1 << 20
+
+func (d *Dialer) maxResponseHeaderBytes() int64 {
+	if d.MaxResponseHeaderBytes == 0 {
+		return defaultMaxResponseHeaderBytes
+	}
+	return d.MaxResponseHeaderBytes
+}
+
+// headerLimitReader limits the bytes read from the network connection for
+// the handshake response header. The limit is removed by setting n to -1.
+type headerLimitReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (r *headerLimitReader) Read(p []byte) (int, error) {
+	if r.n < 0 {
+		return r.r.Read(p)
+	}
+	if r.n == 0 {
+		r.exceeded = true
+		return 0, ErrResponseHeaderTooLarge
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	return n, err
+}
+
+// drainErrorBody replaces the body of a failed handshake response with the
+// first bytes of the body. The network connection is closed on return from
+// Dial, so the body is read into memory to aid application debugging.
+func (d *Dialer) drainErrorBody(resp *http.Response) {
+	limit := d.ErrorBodyLimit
+	if limit == 0 {
+		limit = defaultErrorBodyLimit
+	}
+	var p []byte
+	if resp.StatusCode != http.StatusSwitchingProtocols && limit > 0 {
+		p, _ = ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)))
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(p))
+}
 
+// checkHandshakeResponse checks the opening handshake response and configures
+// the connection for the negotiated subprotocol and extensions.
+func (d *Dialer) checkHandshakeResponse(conn *Conn, resp *http.Response, challengeKey string) error {
 	if d.Jar != nil {
 		if rc := resp.Cookies(); len(rc) > 0 {
-			d.Jar.SetCookies(u, rc)
+			d.Jar.SetCookies(resp.Request.URL, rc)
 		}
 	}
 
-	if resp.StatusCode != 101 ||
-		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
-		!strings.EqualFold(resp.Header.Get("Connection"), "upgrade") ||
-		resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey(challengeKey) {
-		// Before closing the network connection on return from this
-		// function, slurp up some of the response to aid application
-		// debugging.
-		buf := make([]byte, 1024)
-		n, _ := io.ReadFull(resp.Body, buf)
-		resp.Body = ioutil.NopCloser(bytes.NewReader(buf[:n]))
-		return nil, resp, ErrBadHandshake
+	reason := HandshakeReasonNone
+	switch {
+	case resp.StatusCode != 101:
+		reason = HandshakeBadStatus
+	case !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		!strings.EqualFold(resp.Header.Get("Connection"), "upgrade"):
+		reason = HandshakeBadProtocol
+	case resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey(challengeKey):
+		reason = HandshakeBadAccept
+	}
+	if reason != HandshakeReasonNone {
+		d.drainErrorBody(resp)
+		statsHandshakeError()
+		return HandshakeError{
+			message:    ErrBadHandshake.Error(),
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Reason:     reason,
+		}
 	}
 
 	for _, ext := range parseExtensions(resp.Header) {
+		conn.extensions = append(conn.extensions, extensionFromParsed(ext))
 		if ext[""] != "permessage-deflate" {
+			if !d.offeredExtension(ext[""]) {
+				d.drainErrorBody(resp)
+				return errUnexpectedExtension
+			}
 			continue
 		}
-		_, snct := ext["server_no_context_takeover"]
-		_, cnct := ext["client_no_context_takeover"]
-		if !snct || !cnct {
-			return nil, resp, errInvalidCompression
+		if conn.newCompressionWriter != nil {
+			continue
+		}
+		st, err := negotiateClientCompression(d.compressionParams(), ext)
+		if err != nil {
+			d.drainErrorBody(resp)
+			return err
 		}
-		conn.newCompressionWriter = compressNoContextTakeover
-		conn.newDecompressionReader = decompressNoContextTakeover
-		break
+		st.configure(conn)
 	}
 
-	resp.Body = ioutil.NopCloser(bytes.NewReader([]byte{}))
 	conn.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
-
-	netConn.SetDeadline(time.Time{})
-	netConn = nil // to avoid close in defer.
-	return conn, resp, nil
+	conn.codec = codecForSubprotocol(d.Codecs, conn.subprotocol)
+	conn.readPool = d.ReadBufferPool
+	return nil
 }
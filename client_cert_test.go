@@ -0,0 +1,93 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.8
+
+package websocket
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestDialGetClientCertificate(t *testing.T) {
+	var s cstServer
+	var peerCerts int
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCerts = len(r.TLS.PeerCertificates)
+		cstHandler{t}.ServeHTTP(w, r)
+	}))
+	s.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.Server.StartTLS()
+	defer s.Server.Close()
+	s.Server.URL += cstRequestURI
+	s.URL = makeWsProto(s.Server.URL)
+
+	var target *url.URL
+	d := cstDialer
+	d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	d.GetClientCertificate = func(u *url.URL, info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		target = u
+		return &s.Server.TLS.Certificates[0], nil
+	}
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	if target == nil || target.String() != s.URL {
+		t.Errorf("GetClientCertificate called with URL %v, want %s", target, s.URL)
+	}
+	if peerCerts != 1 {
+		t.Errorf("server received %d client certificates, want 1", peerCerts)
+	}
+}
+
+func TestServerTLSConnectionState(t *testing.T) {
+	states := make(chan *Conn, 1)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		states <- ws
+		ws.ReadMessage()
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	d := cstDialer
+	d.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       s.TLS.Certificates,
+	}
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	c := <-states
+	state, ok := c.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) != 1 {
+		t.Fatalf("TLSConnectionState returned %d peer certificates, %v, want 1, true", len(state.PeerCertificates), ok)
+	}
+	// The client certificate was requested but not verified.
+	if cert := c.VerifiedPeerCertificate(); cert != nil {
+		t.Errorf("VerifiedPeerCertificate returned %v, want nil", cert.Subject)
+	}
+
+	c.tlsState.VerifiedChains = [][]*x509.Certificate{state.PeerCertificates}
+	if cert := c.VerifiedPeerCertificate(); cert != state.PeerCertificates[0] {
+		t.Errorf("VerifiedPeerCertificate did not return the leaf of the verified chain")
+	}
+}
@@ -6,7 +6,10 @@
 
 package websocket
 
-import "crypto/tls"
+import (
+	"crypto/tls"
+	"net/url"
+)
 
 func cloneTLSConfig(cfg *tls.Config) *tls.Config {
 	if cfg == nil {
@@ -14,3 +17,10 @@ func cloneTLSConfig(cfg *tls.Config) *tls.Config {
 	}
 	return cfg.Clone()
 }
+
+func setClientCertificate(cfg *tls.Config, f func(*url.URL, *tls.CertificateRequestInfo) (*tls.Certificate, error), u *url.URL) error {
+	cfg.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return f(u, info)
+	}
+	return nil
+}
@@ -6,7 +6,11 @@
 
 package websocket
 
-import "crypto/tls"
+import (
+	"crypto/tls"
+	"errors"
+	"net/url"
+)
 
 // cloneTLSConfig clones all public fields except the fields
 // SessionTicketsDisabled and SessionTicketKey. This avoids copying the
@@ -36,3 +40,9 @@ func cloneTLSConfig(cfg *tls.Config) *tls.Config {
 		CurvePreferences:         cfg.CurvePreferences,
 	}
 }
+
+var errClientCertificateUnsupported = errors.New("websocket: Dialer.GetClientCertificate requires Go 1.8")
+
+func setClientCertificate(cfg *tls.Config, f func(*url.URL, *tls.CertificateRequestInfo) (*tls.Certificate, error), u *url.URL) error {
+	return errClientCertificateUnsupported
+}
@@ -0,0 +1,17 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.11
+
+package websocket
+
+import (
+	"net"
+	"syscall"
+)
+
+func setDialerControl(d *net.Dialer, control func(network, address string, c syscall.RawConn) error) error {
+	d.Control = control
+	return nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.11
+
+package websocket
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestDialControl(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	var gotNetwork, gotAddress string
+	var gotFD bool
+	d := cstDialer
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		gotNetwork, gotAddress = network, address
+		return c.Control(func(fd uintptr) { gotFD = true })
+	}
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	if want := s.Listener.Addr().String(); gotAddress != want {
+		t.Errorf("Control called with address %q, want %q", gotAddress, want)
+	}
+	if _, _, err := net.SplitHostPort(gotAddress); err != nil || gotNetwork == "" || !gotFD {
+		t.Errorf("Control called with network %q, fd %v", gotNetwork, gotFD)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.12
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+)
+
+var (
+	errNoProtocolSwitch = errors.New("websocket: HTTP transport does not support protocol switching")
+	errClientTimeout    = errors.New("websocket: DialWithClient requires a client with zero Timeout")
+)
+
+// DialWithClient creates a new client connection by sending the opening
+// handshake through client. The handshake uses the client's transport,
+// including its connection pool, proxy configuration and instrumentation,
+// and the connection is taken over from the transport after a successful
+// handshake. Use an http.Client with a custom Transport to send the handshake
+// through an http.RoundTripper.
+//
+// The transport must support protocol switching by returning the connection
+// as a writable response body as http.Transport does. The client Timeout must
+// be zero because the timeout also applies to the connection after the
+// handshake. DialWithClient returns an error if the Timeout is not zero. The
+// Dialer's NetDial, LocalAddr, LocalInterface, Proxy, TLSClientConfig and
+// HandshakeTimeout fields are not used.
+//
+// See Dial for a description of the arguments and results.
+func (d *Dialer) DialWithClient(client *http.Client, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &nilDialer
+	}
+	if client.Timeout != 0 {
+		return nil, nil, errClientTimeout
+	}
+
+	req, challengeKey, err := d.newHandshakeRequest(context.Background(), urlStr, requestHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Record the network connection for the connection addresses and
+	// deadlines.
+	var netConn net.Conn
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { netConn = info.Conn },
+	}))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		if _, ok := resp.Body.(io.ReadWriteCloser); !ok {
+			resp.Body.Close()
+			return nil, resp, errNoProtocolSwitch
+		}
+	}
+
+	body := resp.Body
+	rwc, _ := body.(io.ReadWriteCloser)
+	conn := newConnBRW(&rwcConn{rwc: rwc, netConn: netConn}, false, d.ReadBufferSize, d.WriteBufferSize, d.WriteBufferPool, nil)
+	if err := d.checkHandshakeResponse(conn, resp, challengeKey); err != nil {
+		conn.Close()
+		if rwc == nil {
+			body.Close()
+		}
+		return nil, resp, err
+	}
+	resp.Body = http.NoBody
+	if d.PingInterval > 0 {
+		conn.startKeepalive(d.PingInterval, d.PongTimeout)
+	}
+	return conn, resp, nil
+}
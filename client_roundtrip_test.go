@@ -0,0 +1,70 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.12
+
+package websocket
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type countingTransport struct {
+	n int
+	http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n++
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestDialWithClient(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	transport := &countingTransport{RoundTripper: &http.Transport{}}
+	client := &http.Client{Transport: transport}
+
+	ws, resp, err := cstDialer.DialWithClient(client, s.URL, nil)
+	if err != nil {
+		t.Fatalf("DialWithClient: %v", err)
+	}
+	defer ws.Close()
+	if transport.n != 1 {
+		t.Errorf("round trips=%d, want 1", transport.n)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status=%d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if ws.Subprotocol() != "p1" {
+		t.Errorf("Subprotocol()=%q, want p1", ws.Subprotocol())
+	}
+	if ws.RemoteAddr().String() != s.Listener.Addr().String() {
+		t.Errorf("RemoteAddr()=%v, want %v", ws.RemoteAddr(), s.Listener.Addr())
+	}
+	sendRecv(t, ws)
+}
+
+func TestDialWithClientBadHandshake(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	_, resp, err := cstDialer.DialWithClient(http.DefaultClient, s.URL+"&x=z", nil)
+	if !IsBadHandshake(err) {
+		t.Fatalf("DialWithClient returned %v, want %v", err, ErrBadHandshake)
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("resp=%v, want status 400", resp)
+	}
+}
+
+func TestDialWithClientTimeout(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	if _, _, err := cstDialer.DialWithClient(client, "ws://example.com/", nil); err != errClientTimeout {
+		t.Errorf("DialWithClient returned %v, want %v", err, errClientTimeout)
+	}
+}
@@ -6,10 +6,13 @@ package websocket
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
@@ -17,6 +20,8 @@ import (
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -220,6 +225,124 @@ func TestProxyAuthorizationDial(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestProxyAuthCallbackDial(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	surl, _ := url.Parse(s.Server.URL)
+
+	cstDialer := cstDialer // make local copy for modification on next line.
+	cstDialer.Proxy = http.ProxyURL(surl)
+
+	connect := false
+	origHandler := s.Server.Config.Handler
+
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "CONNECT" {
+				if r.Header.Get("Proxy-Authorization") != "Token nonce-1" {
+					w.Header().Set("Proxy-Authenticate", `Token nonce="nonce-1"`)
+					w.Header().Set("Connection", "close")
+					w.WriteHeader(http.StatusProxyAuthRequired)
+					return
+				}
+				connect = true
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if !connect {
+				t.Log("connect with proxy authorization not received")
+				http.Error(w, "connect with proxy authorization not received", http.StatusMethodNotAllowed)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	calls := 0
+	cstDialer.ProxyAuth = func(proxyURL *url.URL, resp *http.Response) (string, error) {
+		calls++
+		if resp.StatusCode != http.StatusProxyAuthRequired {
+			t.Errorf("status=%d, want %d", resp.StatusCode, http.StatusProxyAuthRequired)
+		}
+		challenge := resp.Header.Get("Proxy-Authenticate")
+		return "Token " + strings.TrimSuffix(strings.TrimPrefix(challenge, `Token nonce="`), `"`), nil
+	}
+
+	ws, _, err := cstDialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+	if calls != 1 {
+		t.Errorf("ProxyAuth called %d times, want 1", calls)
+	}
+
+	connect = false
+	cstDialer.ProxyAuth = func(proxyURL *url.URL, resp *http.Response) (string, error) { return "", nil }
+	if _, _, err := cstDialer.Dial(s.URL, nil); err == nil {
+		t.Errorf("Dial without credentials did not return an error")
+	}
+}
+
+func TestHTTPSProxyDial(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	certs := x509.NewCertPool()
+	for _, c := range s.TLS.Certificates {
+		roots, err := x509.ParseCertificates(c.Certificate[len(c.Certificate)-1])
+		if err != nil {
+			t.Fatalf("error parsing server's root cert: %v", err)
+		}
+		for _, root := range roots {
+			certs.AddCert(root)
+		}
+	}
+
+	surl, _ := url.Parse(s.Server.URL)
+
+	cstDialer := cstDialer // make local copy for modification on next line.
+	cstDialer.Proxy = http.ProxyURL(surl)
+
+	connect := false
+	origHandler := s.Server.Config.Handler
+
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "CONNECT" {
+				connect = r.TLS != nil
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if !connect {
+				t.Log("connect over TLS not received")
+				http.Error(w, "connect over TLS not received", http.StatusMethodNotAllowed)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	// The proxy and the WebSocket server are the same TLS server. After
+	// CONNECT, the handshake is sent over the TLS connection to the proxy
+	// without a second TLS layer.
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "wss")
+
+	if _, _, err := cstDialer.Dial(wsURL, nil); err == nil {
+		t.Fatalf("Dial with untrusted proxy certificate did not return an error")
+	}
+
+	cstDialer.ProxyTLSClientConfig = &tls.Config{RootCAs: certs}
+	ws, _, err := cstDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
+
 func TestDial(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -280,6 +403,47 @@ func TestDialCookieJar(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestDialCookieJarRedirect(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/balance" {
+				http.SetCookie(w, &http.Cookie{Name: "backend", Value: "b1", Path: "/"})
+				http.Redirect(w, r, cstRequestURI, http.StatusFound)
+				return
+			}
+			if c, err := r.Cookie("backend"); err != nil || c.Value != "b1" {
+				t.Logf("sticky session cookie not sent")
+				http.Error(w, "sticky session cookie not sent", http.StatusBadRequest)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	jar, _ := cookiejar.New(nil)
+	d := cstDialer
+	d.Jar = jar
+	d.FollowRedirects = true
+
+	ws, _, err := d.Dial(strings.TrimSuffix(s.URL, cstRequestURI)+"/balance", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	// The cookie is sent on subsequent dials.
+	ws2, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws2.Close()
+	sendRecv(t, ws2)
+}
+
 func TestDialTLS(t *testing.T) {
 	s := newTLSServer(t)
 	defer s.Close()
@@ -297,11 +461,19 @@ func TestDialTLS(t *testing.T) {
 
 	d := cstDialer
 	d.TLSClientConfig = &tls.Config{RootCAs: certs}
-	ws, _, err := d.Dial(s.URL, nil)
+	ws, resp, err := d.Dial(s.URL, nil)
 	if err != nil {
 		t.Fatalf("Dial: %v", err)
 	}
 	defer ws.Close()
+
+	state, ok := ws.TLSConnectionState()
+	if !ok || !state.HandshakeComplete {
+		t.Errorf("TLSConnectionState() = %v, %v, want completed handshake", state.HandshakeComplete, ok)
+	}
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		t.Errorf("resp.TLS does not contain peer certificates")
+	}
 	sendRecv(t, ws)
 }
 
@@ -317,6 +489,79 @@ func xTestDialTLSBadCert(t *testing.T) {
 	}
 }
 
+func TestDialTLSHandshakeContext(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	type ctxKey struct{}
+	called := false
+	d := cstDialer
+	d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+	d.TLSHandshakeContext = func(ctx context.Context, netConn net.Conn, config *tls.Config) (net.Conn, error) {
+		called = true
+		if ctx.Value(ctxKey{}) != "value" {
+			t.Errorf("context value not passed to TLSHandshakeContext")
+		}
+		if config.ServerName == "" {
+			t.Errorf("config.ServerName not set")
+		}
+		tlsConn := tls.Client(netConn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	ws, resp, err := d.DialContext(ctx, s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if !called {
+		t.Errorf("TLSHandshakeContext not called")
+	}
+	if resp.TLS == nil || resp.TLS.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("resp.TLS.NegotiatedProtocol is not http/1.1")
+	}
+	if state, ok := ws.TLSConnectionState(); !ok || state.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("TLSConnectionState().NegotiatedProtocol = %q, %v, want http/1.1", state.NegotiatedProtocol, ok)
+	}
+	sendRecv(t, ws)
+
+	d.TLSHandshakeContext = func(ctx context.Context, netConn net.Conn, config *tls.Config) (net.Conn, error) {
+		return nil, errors.New("handshake refused")
+	}
+	ws, _, err = d.Dial(s.URL, nil)
+	if err == nil || err.Error() != "handshake refused" {
+		if ws != nil {
+			ws.Close()
+		}
+		t.Fatalf("Dial returned error %v, want handshake refused", err)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ws, _, err := cstDialer.DialContext(ctx, s.URL, nil)
+	if err == nil {
+		ws.Close()
+		t.Fatalf("Dial: nil")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws, _, err = cstDialer.DialContext(ctx, s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
+
 func TestDialTLSNoVerify(t *testing.T) {
 	s := newTLSServer(t)
 	defer s.Close()
@@ -403,6 +648,75 @@ func TestHandshakeTimeout(t *testing.T) {
 	ws.Close()
 }
 
+func TestDialPhaseTimeouts(t *testing.T) {
+	// The listener accepts connections and never responds.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	for _, tt := range []struct {
+		name   string
+		scheme string
+		set    func(d *Dialer)
+	}{
+		{"upgrade", "ws", func(d *Dialer) { d.UpgradeTimeout = 50 * time.Millisecond }},
+		{"tls", "wss", func(d *Dialer) { d.TLSHandshakeTimeout = 50 * time.Millisecond }},
+		{"tls upgrade", "wss", func(d *Dialer) {
+			d.TLSHandshakeTimeout = 50 * time.Millisecond
+			d.UpgradeTimeout = time.Minute
+		}},
+	} {
+		d := cstDialer
+		tt.set(&d)
+		start := time.Now()
+		ws, _, err := d.Dial(tt.scheme+"://"+l.Addr().String(), nil)
+		if err == nil {
+			ws.Close()
+			t.Errorf("%s: Dial returned nil error", tt.name)
+			continue
+		}
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			t.Errorf("%s: Dial returned error %v, want timeout", tt.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("%s: Dial took %v", tt.name, elapsed)
+		}
+	}
+}
+
+func TestPhaseDeadline(t *testing.T) {
+	if d := phaseDeadline(time.Time{}, 0); !d.Equal(time.Time{}) {
+		t.Errorf("phaseDeadline(zero, 0) = %v, want zero", d)
+	}
+	deadline := time.Now().Add(time.Second)
+	if d := phaseDeadline(deadline, 0); !d.Equal(deadline) {
+		t.Errorf("phaseDeadline(deadline, 0) = %v, want %v", d, deadline)
+	}
+	if d := phaseDeadline(deadline, time.Hour); !d.Equal(deadline) {
+		t.Errorf("phaseDeadline(deadline, hour) = %v, want %v", d, deadline)
+	}
+	if d := phaseDeadline(deadline, time.Millisecond); !d.Before(deadline) {
+		t.Errorf("phaseDeadline(deadline, millisecond) = %v, want before %v", d, deadline)
+	}
+}
+
 func TestDialBadScheme(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -540,6 +854,89 @@ func TestRespOnBadHandshake(t *testing.T) {
 
 // TestHostHeader confirms that the host header provided in the call to Dial is
 // sent to the server.
+func TestRespOnBadHandshakeBodyLimit(t *testing.T) {
+	body := strings.Repeat("x", 5000)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, body)
+	}))
+	defer s.Close()
+
+	for _, tt := range []struct {
+		limit int
+		want  int
+	}{
+		{0, 1024},
+		{4000, 4000},
+		{10000, 5000},
+		{-1, 0},
+	} {
+		d := cstDialer
+		d.ErrorBodyLimit = tt.limit
+		_, resp, err := d.Dial(makeWsProto(s.URL), nil)
+		if !IsBadHandshake(err) || resp == nil {
+			t.Fatalf("limit=%d: Dial returned %v, %v", tt.limit, resp, err)
+		}
+		if resp.Header.Get("Retry-After") != "10" {
+			t.Errorf("limit=%d: Retry-After header not returned", tt.limit)
+		}
+		p, err := ioutil.ReadAll(resp.Body)
+		if err != nil || len(p) != tt.want {
+			t.Errorf("limit=%d: read %d bytes, %v, want %d bytes", tt.limit, len(p), err, tt.want)
+		}
+	}
+}
+
+func TestHandshakeErrorReason(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		header http.Header
+		reason HandshakeReason
+	}{
+		{http.StatusUnauthorized, http.Header{"Www-Authenticate": {"Bearer"}}, HandshakeBadStatus},
+		{http.StatusSwitchingProtocols, http.Header{"Upgrade": {"h2c"}, "Connection": {"Upgrade"}}, HandshakeBadProtocol},
+		{http.StatusSwitchingProtocols, http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}, "Sec-Websocket-Accept": {"bad"}}, HandshakeBadAccept},
+	} {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, vs := range tt.header {
+				w.Header()[k] = vs
+			}
+			if tt.status == http.StatusSwitchingProtocols {
+				// The HTTP server does not write 101 responses with
+				// WriteHeader, so write the response directly.
+				conn, brw, _ := w.(http.Hijacker).Hijack()
+				defer conn.Close()
+				brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+				w.Header().Write(brw)
+				brw.WriteString("\r\n")
+				brw.Flush()
+				return
+			}
+			w.WriteHeader(tt.status)
+		}))
+
+		_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+		s.Close()
+		e, ok := err.(HandshakeError)
+		if !ok {
+			t.Errorf("status %d: Dial returned %v, want HandshakeError", tt.status, err)
+			continue
+		}
+		if !IsBadHandshake(err) || !e.Is(ErrBadHandshake) || err.Error() != ErrBadHandshake.Error() {
+			t.Errorf("status %d: error does not match ErrBadHandshake", tt.status)
+		}
+		if e.StatusCode != tt.status || e.Reason != tt.reason || resp == nil || resp.StatusCode != tt.status {
+			t.Errorf("status %d: StatusCode=%d, Reason=%d, want %d, %d", tt.status, e.StatusCode, e.Reason, tt.status, tt.reason)
+		}
+		for k := range tt.header {
+			if e.Header.Get(k) != tt.header.Get(k) {
+				t.Errorf("status %d: Header[%s]=%q, want %q", tt.status, k, e.Header.Get(k), tt.header.Get(k))
+			}
+		}
+	}
+}
+
 func TestHostHeader(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -581,6 +978,479 @@ func TestDialCompression(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestDialSignURL(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("sig") != "signed-1" {
+				t.Logf("sig=%q, want %q", q.Get("sig"), "signed-1")
+				http.Error(w, "bad signature", http.StatusForbidden)
+				return
+			}
+			q.Del("sig")
+			r.URL.RawQuery = q.Encode()
+			origHandler.ServeHTTP(w, r)
+		})
+
+	n := 0
+	dialer := cstDialer
+	dialer.SignURL = func(u *url.URL, at time.Time) error {
+		if u.Scheme != "ws" {
+			t.Errorf("scheme=%q, want ws", u.Scheme)
+		}
+		n++
+		q := u.Query()
+		q.Set("sig", fmt.Sprintf("signed-%d", n))
+		u.RawQuery = q.Encode()
+		return nil
+	}
+	ws, _, err := dialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	errSign := errors.New("sign error")
+	dialer.SignURL = func(u *url.URL, at time.Time) error { return errSign }
+	if _, _, err := dialer.Dial(s.URL, nil); err != errSign {
+		t.Errorf("Dial returned %v, want %v", err, errSign)
+	}
+}
+
+func TestDialLocalAddr(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	remoteAddr := make(chan string, 1)
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			remoteAddr <- r.RemoteAddr
+			origHandler.ServeHTTP(w, r)
+		})
+
+	ifaces, _ := net.Interfaces()
+	var loopback string
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			loopback = ifi.Name
+			break
+		}
+	}
+
+	for _, dialer := range []Dialer{
+		{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}},
+		{LocalInterface: loopback},
+	} {
+		if dialer.LocalInterface == "" && dialer.LocalAddr == nil {
+			continue
+		}
+		dialer.Subprotocols = cstDialer.Subprotocols
+		ws, _, err := dialer.Dial(s.URL, nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		host, _, _ := net.SplitHostPort(<-remoteAddr)
+		if host != "127.0.0.1" {
+			t.Errorf("remote host=%s, want 127.0.0.1", host)
+		}
+		sendRecv(t, ws)
+		ws.Close()
+	}
+
+	dialer := cstDialer
+	dialer.LocalInterface = "no-such-interface"
+	if _, _, err := dialer.Dial(s.URL, nil); err == nil {
+		t.Errorf("Dial with bad interface did not return an error")
+	}
+}
+
+func TestDialLocalInterfaceIPv6(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	remoteAddr := make(chan string, 1)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr <- r.RemoteAddr
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	s.Listener.Close()
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	ifaces, _ := net.Interfaces()
+	var loopback string
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			loopback = ifi.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("loopback interface not found")
+	}
+
+	dialer := cstDialer
+	dialer.LocalInterface = loopback
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	host, _, _ := net.SplitHostPort(<-remoteAddr)
+	if host != "::1" {
+		t.Errorf("remote host=%s, want ::1", host)
+	}
+}
+
+func TestDialFollowRedirects(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/loop":
+				http.Redirect(w, r, "/loop", http.StatusFound)
+			case "/redirect":
+				if r.Header.Get("Authorization") != "secret" {
+					t.Logf("authorization not sent to same host")
+					http.Error(w, "bad authorization", http.StatusUnauthorized)
+					return
+				}
+				http.Redirect(w, r, cstRequestURI, http.StatusTemporaryRedirect)
+			default:
+				origHandler.ServeHTTP(w, r)
+			}
+		})
+
+	base := strings.TrimSuffix(s.URL, cstRequestURI)
+	header := http.Header{"Authorization": {"secret"}}
+
+	if _, resp, err := cstDialer.Dial(base+"/redirect", header); !IsBadHandshake(err) || resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("Dial without FollowRedirects returned %v, want %v", err, ErrBadHandshake)
+	}
+
+	dialer := cstDialer
+	dialer.FollowRedirects = true
+	ws, _, err := dialer.Dial(base+"/redirect", header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	dialer.MaxRedirects = 3
+	if _, resp, err := dialer.Dial(base+"/loop", nil); !IsBadHandshake(err) || resp.StatusCode != http.StatusFound {
+		t.Errorf("Dial with redirect loop returned %v, want %v", err, ErrBadHandshake)
+	}
+}
+
+func TestDialAuthenticate(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.Header().Set("Www-Authenticate", `Bearer realm="test"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	calls := 0
+	d := cstDialer
+	d.Authenticate = func(resp *http.Response) (http.Header, error) {
+		calls++
+		if resp.Header.Get("Www-Authenticate") != `Bearer realm="test"` {
+			t.Errorf("challenge not passed to Authenticate")
+		}
+		if resp.Request == nil || resp.Request.URL.Path != cstPath {
+			t.Errorf("request not passed to Authenticate")
+		}
+		return http.Header{"authorization": {"Bearer token"}}, nil
+	}
+	header := http.Header{"Origin": {s.Server.URL}}
+	ws, _, err := d.Dial(s.URL, header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+	if calls != 1 {
+		t.Errorf("Authenticate called %d times, want 1", calls)
+	}
+	if _, ok := header["Authorization"]; ok {
+		t.Errorf("request header modified by Dial")
+	}
+
+	calls = 0
+	d.Authenticate = func(resp *http.Response) (http.Header, error) {
+		calls++
+		return http.Header{"Authorization": {"Bearer wrong"}}, nil
+	}
+	if _, resp, err := d.Dial(s.URL, nil); !IsBadHandshake(err) || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Dial with bad credentials returned %v, want %v", err, ErrBadHandshake)
+	}
+	if calls != maxAuthAttempts {
+		t.Errorf("Authenticate called %d times, want %d", calls, maxAuthAttempts)
+	}
+
+	d.Authenticate = func(resp *http.Response) (http.Header, error) {
+		return nil, nil
+	}
+	if _, _, err := d.Dial(s.URL, nil); !IsBadHandshake(err) {
+		t.Errorf("Dial with nil header returned %v, want %v", err, ErrBadHandshake)
+	}
+
+	errAuth := errors.New("no credentials")
+	d.Authenticate = func(resp *http.Response) (http.Header, error) {
+		return nil, errAuth
+	}
+	if _, _, err := d.Dial(s.URL, nil); err != errAuth {
+		t.Errorf("Dial with Authenticate error returned %v, want %v", err, errAuth)
+	}
+}
+
+func TestDialHeaderFunc(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	origHandler := s.Server.Config.Handler
+	tokens := make(chan string, 2)
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			tokens <- r.Header.Get("Authorization")
+			if r.Header.Get("X-Static") != "static" {
+				t.Errorf("static header not sent")
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	type ctxKey struct{}
+	n := 0
+	d := cstDialer
+	d.HeaderFunc = func(ctx context.Context, u *url.URL) (http.Header, error) {
+		if ctx.Value(ctxKey{}) != "value" {
+			t.Errorf("context value not passed to HeaderFunc")
+		}
+		if u.String() != s.URL {
+			t.Errorf("HeaderFunc called with URL %s, want %s", u, s.URL)
+		}
+		n++
+		return http.Header{"Authorization": {fmt.Sprintf("Bearer %d", n)}}, nil
+	}
+	header := http.Header{"X-Static": {"static"}, "Authorization": {"stale"}}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	for i := 1; i <= 2; i++ {
+		ws, _, err := d.DialContext(ctx, s.URL, header)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		sendRecv(t, ws)
+		ws.Close()
+		if got, want := <-tokens, fmt.Sprintf("Bearer %d", i); got != want {
+			t.Errorf("Authorization = %q, want %q", got, want)
+		}
+	}
+
+	errHeader := errors.New("no token")
+	d.HeaderFunc = func(ctx context.Context, u *url.URL) (http.Header, error) {
+		return nil, errHeader
+	}
+	if _, _, err := d.Dial(s.URL, nil); err != errHeader {
+		t.Errorf("Dial with HeaderFunc error returned %v, want %v", err, errHeader)
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com/a/b", nil)
+	header := http.Header{"Authorization": {"secret"}, "Origin": {"https://example.com"}}
+	for _, tt := range []struct {
+		location string
+		want     string
+		auth     bool
+	}{
+		{"c", "wss://example.com/a/c", true},
+		{"/c?x=y", "wss://example.com/c?x=y", true},
+		{"http://example.com/c", "ws://example.com/c", true},
+		{"wss://other.example.com/c", "wss://other.example.com/c", false},
+	} {
+		resp := &http.Response{StatusCode: http.StatusFound, Header: http.Header{"Location": {tt.location}}, Request: req}
+		urlStr, h, ok := redirectLocation(resp, header)
+		if !ok || urlStr != tt.want {
+			t.Errorf("redirectLocation(%q) = %q, %v, want %q", tt.location, urlStr, ok, tt.want)
+		}
+		if auth := h.Get("Authorization") != ""; auth != tt.auth {
+			t.Errorf("redirectLocation(%q) sent authorization=%v, want %v", tt.location, auth, tt.auth)
+		}
+		if h.Get("Origin") == "" {
+			t.Errorf("redirectLocation(%q) removed origin", tt.location)
+		}
+	}
+}
+
+func TestNewClientConn(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	for _, wrap := range []bool{false, true} {
+		netConn, err := net.Dial("tcp", s.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rwc io.ReadWriteCloser = netConn
+		if wrap {
+			// Hide the net.Conn methods.
+			rwc = struct{ io.ReadWriteCloser }{netConn}
+		}
+		ws, _, err := cstDialer.NewClientConn(rwc, s.URL, nil)
+		if err != nil {
+			t.Fatalf("wrap=%v: NewClientConn: %v", wrap, err)
+		}
+		if wrap {
+			if err := ws.SetReadDeadline(time.Now()); err == nil {
+				t.Errorf("SetReadDeadline did not return an error")
+			}
+			ws.WriteMessage(TextMessage, []byte("Hello World!"))
+			if _, p, err := ws.ReadMessage(); err != nil || string(p) != "Hello World!" {
+				t.Errorf("ReadMessage returned %q, %v", p, err)
+			}
+		} else {
+			if ws.RemoteAddr().String() != s.Listener.Addr().String() {
+				t.Errorf("RemoteAddr()=%v, want %v", ws.RemoteAddr(), s.Listener.Addr())
+			}
+			sendRecv(t, ws)
+		}
+		ws.Close()
+	}
+}
+
+type closeRecorder struct {
+	io.ReadWriter
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewClientConnCloseOnError(t *testing.T) {
+	errHeader := errors.New("header error")
+	d := cstDialer
+	d.HeaderFunc = func(ctx context.Context, u *url.URL) (http.Header, error) {
+		return nil, errHeader
+	}
+	d2 := cstDialer
+	d2.DialAddr = func(hostPort string) (string, error) {
+		t.Errorf("DialAddr called")
+		return "", errors.New("dial addr error")
+	}
+	for _, tt := range []struct {
+		d      *Dialer
+		urlStr string
+	}{
+		{&cstDialer, "://bad"},
+		{&d, "ws://example.com/"},
+		{&d2, "ws://example.com/"},
+	} {
+		rwc := &closeRecorder{ReadWriter: &bytes.Buffer{}}
+		if _, _, err := tt.d.NewClientConn(rwc, tt.urlStr, nil); err == nil {
+			t.Errorf("NewClientConn(%q) did not return an error", tt.urlStr)
+		}
+		if !rwc.closed {
+			t.Errorf("NewClientConn(%q) did not close rwc", tt.urlStr)
+		}
+	}
+}
+
+func TestDialAddr(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	host := make(chan string, 1)
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			host <- r.Host
+			origHandler.ServeHTTP(w, r)
+		})
+
+	d := cstDialer
+	d.DialAddr = func(hostPort string) (string, error) {
+		if hostPort != "pinned.example.com:80" {
+			t.Errorf("hostPort=%q, want pinned.example.com:80", hostPort)
+		}
+		return s.Listener.Addr().String(), nil
+	}
+	ws, _, err := d.Dial("ws://pinned.example.com"+cstRequestURI, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if h := <-host; h != "pinned.example.com" {
+		t.Errorf("Host=%q, want pinned.example.com", h)
+	}
+	sendRecv(t, ws)
+}
+
+func TestDialUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ws.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix domain sockets not supported: %v", err)
+	}
+
+	host := make(chan string, 1)
+	s := &httptest.Server{
+		Listener: l,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host <- r.Host
+			cstHandler{t}.ServeHTTP(w, r)
+		})},
+	}
+	s.Start()
+	defer s.Close()
+
+	d := cstDialer
+	d.UnixSocket = path
+	d.Proxy = func(*http.Request) (*url.URL, error) {
+		t.Error("Proxy called for Unix socket")
+		return nil, nil
+	}
+	ws, _, err := d.Dial("ws://daemon.local"+cstRequestURI, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if h := <-host; h != "daemon.local" {
+		t.Errorf("Host=%q, want daemon.local", h)
+	}
+	sendRecv(t, ws)
+}
+
 func TestSocksProxyDial(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -659,3 +1529,55 @@ func TestSocksProxyDial(t *testing.T) {
 	defer ws.Close()
 	sendRecv(t, ws)
 }
+
+func TestDialMaxResponseHeaderBytes(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		netConn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer netConn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(r.Header.Get("Sec-Websocket-Key")) + "\r\n")
+		brw.WriteString("X-Padding: " + strings.Repeat("x", 4096) + "\r\n\r\n")
+		brw.Flush()
+	}))
+	defer s.Close()
+	u := makeWsProto(s.URL)
+
+	d := Dialer{MaxResponseHeaderBytes: 1024}
+	if ws, _, err := d.Dial(u, nil); err != ErrResponseHeaderTooLarge {
+		if ws != nil {
+			ws.Close()
+		}
+		t.Errorf("Dial with limit returned %v, want %v", err, ErrResponseHeaderTooLarge)
+	}
+
+	for _, limit := range []int64{0, -1, 8192} {
+		d := Dialer{MaxResponseHeaderBytes: limit}
+		ws, _, err := d.Dial(u, nil)
+		if err != nil {
+			t.Errorf("Dial with limit %d returned %v", limit, err)
+			continue
+		}
+		ws.Close()
+	}
+}
+
+func TestProxyMaxResponseHeaderBytes(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Padding", strings.Repeat("x", 4096))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	d := Dialer{
+		Proxy:                  http.ProxyURL(proxyURL),
+		MaxResponseHeaderBytes: 1024,
+	}
+	if _, _, err := d.Dial("ws://example.com/", nil); err != ErrResponseHeaderTooLarge {
+		t.Errorf("Dial returned %v, want %v", err, ErrResponseHeaderTooLarge)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the IP address of the client that sent the request.
+//
+// If the peer is in one of the networks in u.TrustedProxies, then ClientIP
+// uses the addresses in the Forwarded, X-Forwarded-For or X-Real-IP request
+// header, checked in that order. The addresses are scanned from the nearest
+// hop to the farthest hop and the first address that is not a trusted proxy is
+// returned. Otherwise, the address of the peer is returned.
+//
+// ClientIP returns nil if the peer address cannot be parsed.
+func (u *Upgrader) ClientIP(r *http.Request) net.IP {
+	ip := parseIP(r.RemoteAddr)
+	if ip == nil || !u.isTrustedProxy(ip) {
+		return ip
+	}
+	for _, hop := range forwardedHops(r.Header) {
+		hopIP := parseIP(hop)
+		if hopIP == nil {
+			// Obfuscated or unknown address.
+			break
+		}
+		ip = hopIP
+		if !u.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// clientKey returns the key that identifies the client with address ip in
+// per-client limits. If the address is not known, then the remote address
+// of the request is used so that such clients do not share one key.
+func clientKey(r *http.Request, ip net.IP) string {
+	if ip == nil && r != nil {
+		return r.RemoteAddr
+	}
+	return ip.String()
+}
+
+func (u *Upgrader) isTrustedProxy(ip net.IP) bool {
+	for _, n := range u.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops returns the forwarded client addresses from the request
+// header, nearest hop first.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	if values := h["Forwarded"]; len(values) > 0 {
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					pair = strings.TrimSpace(pair)
+					if len(pair) > 4 && equalASCIIFold(pair[:4], "for=") {
+						hops = append(hops, strings.Trim(pair[4:], `"`))
+					}
+				}
+			}
+		}
+	} else if values := h["X-Forwarded-For"]; len(values) > 0 {
+		for _, v := range values {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	} else if v := h.Get("X-Real-Ip"); v != "" {
+		hops = append(hops, strings.TrimSpace(v))
+	}
+	for i, j := 0, len(hops)-1; i < j; i, j = i+1, j-1 {
+		hops[i], hops[j] = hops[j], hops[i]
+	}
+	return hops
+}
+
+// parseIP parses an IP address with an optional port. IPv6 addresses with a
+// port are enclosed in square brackets.
+func parseIP(s string) net.IP {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	u := Upgrader{TrustedProxies: []*net.IPNet{private}}
+
+	for _, tt := range []struct {
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"1.2.3.4:1000", nil, "1.2.3.4"},
+		{"[2001:db8::1]:1000", nil, "2001:db8::1"},
+		{"1.2.3.4:1000", http.Header{"X-Forwarded-For": {"5.6.7.8"}}, "1.2.3.4"},
+		{"10.0.0.1:1000", nil, "10.0.0.1"},
+		{"10.0.0.1:1000", http.Header{"X-Forwarded-For": {"5.6.7.8"}}, "5.6.7.8"},
+		{"10.0.0.1:1000", http.Header{"X-Forwarded-For": {"9.9.9.9, 5.6.7.8, 10.0.0.2"}}, "5.6.7.8"},
+		{"10.0.0.1:1000", http.Header{"X-Forwarded-For": {"9.9.9.9", "5.6.7.8"}}, "5.6.7.8"},
+		{"10.0.0.1:1000", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"10.0.0.1:1000", http.Header{"X-Real-Ip": {"5.6.7.8"}}, "5.6.7.8"},
+		{"10.0.0.1:1000", http.Header{"Forwarded": {`for=9.9.9.9, for="[2001:db8::2]:4711";proto=https`}}, "2001:db8::2"},
+		{"10.0.0.1:1000", http.Header{"Forwarded": {"For=5.6.7.8"}, "X-Forwarded-For": {"9.9.9.9"}}, "5.6.7.8"},
+		{"10.0.0.1:1000", http.Header{"Forwarded": {"for=5.6.7.8, for=_hidden"}}, "10.0.0.1"},
+	} {
+		r := &http.Request{RemoteAddr: tt.remoteAddr, Header: tt.header}
+		if got := u.ClientIP(r); got.String() != tt.want {
+			t.Errorf("ClientIP(%q, %v) = %v, want %s", tt.remoteAddr, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wsproxy tunnels TCP connections over WebSocket connections.
+//
+// The client side listens on a local TCP address and forwards each accepted
+// connection over a new WebSocket connection:
+//
+//	wsproxy -listen localhost:2222 -connect wss://gateway.example.com/tunnel
+//
+// The server side accepts WebSocket connections and forwards each connection
+// to a TCP target:
+//
+//	wsproxy -serve :8080 -path /tunnel -target localhost:22
+//
+// In the reverse direction, an agent on the restricted network dials out to
+// the server side and the server side forwards connections accepted on a
+// public TCP address back over the agent's WebSocket connections:
+//
+//	wsproxy -connect wss://gateway.example.com/tunnel -target localhost:22
+//	wsproxy -serve :8080 -path /tunnel -listen :2222
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	listenAddr = flag.String("listen", "", "local TCP address to accept connections on")
+	connectURL = flag.String("connect", "", "WebSocket URL to forward accepted connections to")
+	serveAddr  = flag.String("serve", "", "HTTP address to accept WebSocket connections on")
+	path       = flag.String("path", "/", "HTTP path for WebSocket connections")
+	target     = flag.String("target", "", "TCP address to forward WebSocket connections to")
+)
+
+const (
+	// Time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// Size of the buffer used to read from TCP connections.
+	bufferSize = 32 * 1024
+
+	// Time allowed for the peer to close the WebSocket connection after a
+	// close message is sent.
+	closeGracePeriod = 10 * time.Second
+
+	// Time to wait for an agent connection in the reverse direction.
+	agentWait = 10 * time.Second
+
+	// Time to wait before redialing the server after a failed dial.
+	redialWait = 5 * time.Second
+
+	// Message sent by the server side to start a reverse tunnel on an idle
+	// agent connection.
+	openMessage = "open"
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	switch {
+	case *listenAddr != "" && *connectURL != "":
+		runClient()
+	case *serveAddr != "" && *target != "":
+		runServer()
+	case *connectURL != "" && *target != "":
+		runAgent()
+	case *serveAddr != "" && *listenAddr != "":
+		runReverseServer()
+	default:
+		flag.Usage()
+	}
+}
+
+func runClient() {
+	l, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatal("listen:", err)
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			log.Fatal("accept:", err)
+		}
+		go func() {
+			ws, _, err := websocket.DefaultDialer.Dial(*connectURL, nil)
+			if err != nil {
+				log.Println("dial:", err)
+				c.Close()
+				return
+			}
+			tunnel(c, ws)
+		}()
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func runServer() {
+	http.HandleFunc(*path, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println("upgrade:", err)
+			return
+		}
+		c, err := net.Dial("tcp", *target)
+		if err != nil {
+			log.Println("dial:", err)
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""),
+				time.Now().Add(writeWait))
+			ws.Close()
+			return
+		}
+		tunnel(c, ws)
+	})
+	log.Fatal(http.ListenAndServe(*serveAddr, nil))
+}
+
+// runAgent runs the restricted side of a reverse tunnel. The agent keeps an
+// idle WebSocket connection to the server. When the server opens a tunnel on
+// the idle connection, the agent connects the tunnel to the target and dials
+// a new idle connection.
+func runAgent() {
+	for {
+		ws, _, err := websocket.DefaultDialer.Dial(*connectURL, nil)
+		if err != nil {
+			log.Println("dial:", err)
+			time.Sleep(redialWait)
+			continue
+		}
+		mt, p, err := ws.ReadMessage()
+		if err != nil || mt != websocket.TextMessage || string(p) != openMessage {
+			// The server or an intermediary closed the idle connection.
+			ws.Close()
+			continue
+		}
+		go func() {
+			c, err := net.Dial("tcp", *target)
+			if err != nil {
+				log.Println("dial:", err)
+				ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""),
+					time.Now().Add(writeWait))
+				ws.Close()
+				return
+			}
+			tunnel(c, ws)
+		}()
+	}
+}
+
+// runReverseServer runs the public side of a reverse tunnel. The server
+// accepts idle agent connections on the WebSocket path and forwards each TCP
+// connection accepted on the listen address over an idle agent connection.
+func runReverseServer() {
+	agents := make(chan *websocket.Conn, 16)
+	http.HandleFunc(*path, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println("upgrade:", err)
+			return
+		}
+		select {
+		case agents <- ws:
+		default:
+			log.Println("too many idle agent connections")
+			ws.Close()
+		}
+	})
+
+	l, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatal("listen:", err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				log.Fatal("accept:", err)
+			}
+			go func() {
+				ws := openAgent(agents)
+				if ws == nil {
+					log.Println("no agent connection available")
+					c.Close()
+					return
+				}
+				tunnel(c, ws)
+			}()
+		}
+	}()
+	log.Fatal(http.ListenAndServe(*serveAddr, nil))
+}
+
+// openAgent starts a tunnel on an idle agent connection. Idle connections
+// that fail are discarded. openAgent returns nil if no agent connection is
+// available within agentWait.
+func openAgent(agents chan *websocket.Conn) *websocket.Conn {
+	timer := time.NewTimer(agentWait)
+	defer timer.Stop()
+	for {
+		select {
+		case ws := <-agents:
+			ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(openMessage)); err != nil {
+				ws.Close()
+				continue
+			}
+			return ws
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// tunnel copies data between the TCP connection and the WebSocket connection
+// until either side fails. Data from the TCP connection is sent as binary
+// messages.
+func tunnel(c net.Conn, ws *websocket.Conn) {
+	defer c.Close()
+	defer ws.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Close the TCP connection to unblock the read loop below.
+		defer c.Close()
+		for {
+			_, r, err := ws.NextReader()
+			if err != nil {
+				return
+			}
+			if _, err := io.Copy(c, r); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(writeWait))
+			break
+		}
+	}
+	c.Close()
+	// Wait for the peer to close the WebSocket connection, but not forever.
+	ws.SetReadDeadline(time.Now().Add(closeGracePeriod))
+	<-done
+}
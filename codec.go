@@ -0,0 +1,107 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var errNoCodec = errors.New("websocket: no codec set for connection")
+
+// A Codec encodes and decodes application values as WebSocket messages.
+type Codec interface {
+	// Marshal returns the message type and payload for v.
+	Marshal(v interface{}) (messageType int, p []byte, err error)
+
+	// Unmarshal decodes the message payload p to the value pointed to by v.
+	Unmarshal(messageType int, p []byte, v interface{}) error
+}
+
+// JSONCodec encodes values as JSON text messages.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) (int, []byte, error) {
+	p, err := json.Marshal(v)
+	return TextMessage, p, err
+}
+
+func (jsonCodec) Unmarshal(messageType int, p []byte, v interface{}) error {
+	return json.Unmarshal(p, v)
+}
+
+// SubprotocolCodec associates a subprotocol with the codec used for
+// connections that negotiate the subprotocol.
+type SubprotocolCodec struct {
+	Subprotocol string
+	Codec       Codec
+}
+
+// appendCodecSubprotocols returns protocols with the subprotocols in codecs
+// appended.
+func appendCodecSubprotocols(protocols []string, codecs []SubprotocolCodec) []string {
+	if len(codecs) == 0 {
+		return protocols
+	}
+	result := make([]string, 0, len(protocols)+len(codecs))
+	result = append(result, protocols...)
+	for _, sc := range codecs {
+		result = append(result, sc.Subprotocol)
+	}
+	return result
+}
+
+// codecForSubprotocol returns the codec for the negotiated subprotocol or nil
+// if there is no matching codec.
+func codecForSubprotocol(codecs []SubprotocolCodec, subprotocol string) Codec {
+	if subprotocol == "" {
+		return nil
+	}
+	for _, sc := range codecs {
+		if sc.Subprotocol == subprotocol {
+			return sc.Codec
+		}
+	}
+	return nil
+}
+
+// Codec returns the codec used by the WriteValue and ReadValue methods. The
+// codec is set from the Codecs field of the Dialer or Upgrader when the
+// connection negotiates one of the codec subprotocols.
+func (c *Conn) Codec() Codec {
+	return c.codec
+}
+
+// SetCodec sets the codec used by the WriteValue and ReadValue methods.
+func (c *Conn) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+// WriteValue writes v as a message encoded with the connection's codec.
+func (c *Conn) WriteValue(v interface{}) error {
+	if c.codec == nil {
+		return errNoCodec
+	}
+	messageType, p, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(messageType, p)
+}
+
+// ReadValue reads the next message from the connection and decodes it with
+// the connection's codec to the value pointed to by v.
+func (c *Conn) ReadValue(v interface{}) error {
+	if c.codec == nil {
+		return errNoCodec
+	}
+	messageType, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(messageType, p, v)
+}
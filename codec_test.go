@@ -0,0 +1,82 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v interface{}) (int, []byte, error) {
+	return BinaryMessage, []byte(*v.(*string)), nil
+}
+
+func (stringCodec) Unmarshal(messageType int, p []byte, v interface{}) error {
+	*v.(*string) = string(p)
+	return nil
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	upgrader := Upgrader{
+		Codecs: []SubprotocolCodec{{"json.v1", JSONCodec}},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		if ws.Codec() != JSONCodec {
+			t.Errorf("server Codec() = %v, want JSONCodec", ws.Codec())
+		}
+		var v map[string]int
+		if err := ws.ReadValue(&v); err != nil {
+			t.Errorf("ReadValue: %v", err)
+			return
+		}
+		v["b"] = 2
+		if err := ws.WriteValue(v); err != nil {
+			t.Errorf("WriteValue: %v", err)
+		}
+	}))
+	defer s.Close()
+
+	d := Dialer{
+		Codecs: []SubprotocolCodec{
+			{"string.v1", stringCodec{}},
+			{"json.v1", JSONCodec},
+		},
+	}
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	if ws.Subprotocol() != "json.v1" {
+		t.Fatalf("Subprotocol() = %q, want json.v1", ws.Subprotocol())
+	}
+	if err := ws.WriteValue(map[string]int{"a": 1}); err != nil {
+		t.Fatalf("WriteValue: %v", err)
+	}
+	var v map[string]int
+	if err := ws.ReadValue(&v); err != nil {
+		t.Fatalf("ReadValue: %v", err)
+	}
+	if v["a"] != 1 || v["b"] != 2 {
+		t.Fatalf("ReadValue returned %v", v)
+	}
+}
+
+func TestNoCodec(t *testing.T) {
+	c := newConn(fakeNetConn{}, true, 1024, 1024)
+	if err := c.WriteValue(1); err != errNoCodec {
+		t.Errorf("WriteValue returned %v, want %v", err, errNoCodec)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"compress/flate"
 	"errors"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -25,15 +26,15 @@ var (
 	}}
 )
 
-func decompressNoContextTakeover(r io.Reader) io.ReadCloser {
-	const tail =
-	// Add four bytes as specified in RFC
-	"\x00\x00\xff\xff" +
-		// Add final block to squelch unexpected EOF error from flate reader.
-		"\x01\x00\x00\xff\xff"
+const flateReadTail =
+// Add four bytes as specified in RFC
+"\x00\x00\xff\xff" +
+	// Add final block to squelch unexpected EOF error from flate reader.
+	"\x01\x00\x00\xff\xff"
 
+func decompressNoContextTakeover(r io.Reader) io.ReadCloser {
 	fr, _ := flateReaderPool.Get().(io.ReadCloser)
-	fr.(flate.Resetter).Reset(io.MultiReader(r, strings.NewReader(tail)), nil)
+	fr.(flate.Resetter).Reset(io.MultiReader(r, strings.NewReader(flateReadTail)), nil)
 	return &flateReadWrapper{fr}
 }
 
@@ -146,3 +147,397 @@ func (r *flateReadWrapper) Close() error {
 	r.fr = nil
 	return err
 }
+
+var errInvalidWindowBits = errors.New("websocket: invalid compression window bits")
+
+// CompressionParams specifies the parameters of the permessage-deflate
+// extension as defined in RFC 7692. With context takeover, the compression
+// context is kept between messages. This improves compression of similar
+// messages at the cost of keeping the context in memory for the lifetime of
+// the connection.
+type CompressionParams struct {
+	// ServerNoContextTakeover specifies that the server resets the
+	// compression context for each message.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover specifies that the client resets the
+	// compression context for each message.
+	ClientNoContextTakeover bool
+
+	// ServerMaxWindowBits limits the LZ77 window used by the server to
+	// 2^ServerMaxWindowBits bytes. The value must be zero or in the range 8
+	// to 15. If zero, the window is not limited.
+	ServerMaxWindowBits int
+
+	// ClientMaxWindowBits limits the LZ77 window used by the client to
+	// 2^ClientMaxWindowBits bytes. The value must be zero or in the range 8
+	// to 15. If zero, the window is not limited.
+	//
+	// The compress/flate package always uses the largest window. When the
+	// negotiated window is smaller, this package compresses messages with
+	// Huffman coding only.
+	ClientMaxWindowBits int
+}
+
+var defaultCompressionParams = CompressionParams{
+	ServerNoContextTakeover: true,
+	ClientNoContextTakeover: true,
+}
+
+func validWindowBits(bits int) bool {
+	return bits == 0 || (8 <= bits && bits <= 15)
+}
+
+func (p *CompressionParams) validate() error {
+	if !validWindowBits(p.ServerMaxWindowBits) || !validWindowBits(p.ClientMaxWindowBits) {
+		return errInvalidWindowBits
+	}
+	return nil
+}
+
+// offer returns the permessage-deflate offer for the parameters.
+func (p *CompressionParams) offer() string {
+	s := "permessage-deflate"
+	if p.ServerNoContextTakeover {
+		s += "; server_no_context_takeover"
+	}
+	if p.ClientNoContextTakeover {
+		s += "; client_no_context_takeover"
+	}
+	if p.ServerMaxWindowBits != 0 {
+		s += "; server_max_window_bits=" + strconv.Itoa(p.ServerMaxWindowBits)
+	}
+	if p.ClientMaxWindowBits != 0 {
+		s += "; client_max_window_bits=" + strconv.Itoa(p.ClientMaxWindowBits)
+	}
+	return s
+}
+
+func parseWindowBits(v string) (int, bool) {
+	bits, err := strconv.Atoi(v)
+	if err != nil || bits < 8 || bits > 15 || strconv.Itoa(bits) != v {
+		return 0, false
+	}
+	return bits, true
+}
+
+// deflateState is the permessage-deflate configuration of one endpoint of a
+// connection.
+type deflateState struct {
+	readContextTakeover  bool
+	readMaxWindowBits    int
+	writeContextTakeover bool
+	writeMaxWindowBits   int
+}
+
+// negotiateClientCompression validates the permessage-deflate response ext
+// to the client offer p and returns the client configuration.
+func negotiateClientCompression(p *CompressionParams, ext map[string]string) (deflateState, error) {
+	st := deflateState{
+		readContextTakeover:  true,
+		readMaxWindowBits:    15,
+		writeContextTakeover: !p.ClientNoContextTakeover,
+		writeMaxWindowBits:   15,
+	}
+	if p.ClientMaxWindowBits != 0 {
+		st.writeMaxWindowBits = p.ClientMaxWindowBits
+	}
+	for k, v := range ext {
+		switch k {
+		case "":
+		case "server_no_context_takeover":
+			if v != "" {
+				return st, errInvalidCompression
+			}
+			st.readContextTakeover = false
+		case "client_no_context_takeover":
+			if v != "" {
+				return st, errInvalidCompression
+			}
+			st.writeContextTakeover = false
+		case "server_max_window_bits":
+			bits, ok := parseWindowBits(v)
+			if !ok || (p.ServerMaxWindowBits != 0 && bits > p.ServerMaxWindowBits) {
+				return st, errInvalidCompression
+			}
+			st.readMaxWindowBits = bits
+		case "client_max_window_bits":
+			bits, ok := parseWindowBits(v)
+			if !ok || p.ClientMaxWindowBits == 0 || bits > p.ClientMaxWindowBits {
+				return st, errInvalidCompression
+			}
+			st.writeMaxWindowBits = bits
+		default:
+			return st, errInvalidCompression
+		}
+	}
+	if p.ServerNoContextTakeover && st.readContextTakeover {
+		return st, errInvalidCompression
+	}
+	if _, ok := ext["server_max_window_bits"]; p.ServerMaxWindowBits != 0 && !ok {
+		return st, errInvalidCompression
+	}
+	return st, nil
+}
+
+// negotiateServerOffer returns the server configuration and the extension
+// response for the permessage-deflate offer ext. It returns false if the
+// offer is not acceptable with the server parameters p.
+func negotiateServerOffer(p *CompressionParams, ext map[string]string) (deflateState, string, bool) {
+	st := deflateState{
+		readContextTakeover:  !p.ClientNoContextTakeover,
+		readMaxWindowBits:    15,
+		writeContextTakeover: !p.ServerNoContextTakeover,
+		writeMaxWindowBits:   15,
+	}
+	if p.ServerMaxWindowBits != 0 {
+		st.writeMaxWindowBits = p.ServerMaxWindowBits
+	}
+	clientMaxWindowBits := 0
+	for k, v := range ext {
+		switch k {
+		case "":
+		case "server_no_context_takeover":
+			if v != "" {
+				return st, "", false
+			}
+			st.writeContextTakeover = false
+		case "client_no_context_takeover":
+			if v != "" {
+				return st, "", false
+			}
+			st.readContextTakeover = false
+		case "server_max_window_bits":
+			bits, ok := parseWindowBits(v)
+			if !ok {
+				return st, "", false
+			}
+			if bits < st.writeMaxWindowBits {
+				st.writeMaxWindowBits = bits
+			}
+		case "client_max_window_bits":
+			bits := 15
+			if v != "" {
+				var ok bool
+				if bits, ok = parseWindowBits(v); !ok {
+					return st, "", false
+				}
+			}
+			clientMaxWindowBits = bits
+		default:
+			return st, "", false
+		}
+	}
+	if p.ClientMaxWindowBits != 0 {
+		// The client window can be limited only if the client supports
+		// the parameter.
+		if clientMaxWindowBits == 0 {
+			return st, "", false
+		}
+		if p.ClientMaxWindowBits < clientMaxWindowBits {
+			clientMaxWindowBits = p.ClientMaxWindowBits
+		}
+		st.readMaxWindowBits = clientMaxWindowBits
+	}
+
+	response := "permessage-deflate"
+	if !st.writeContextTakeover {
+		response += "; server_no_context_takeover"
+	}
+	if !st.readContextTakeover {
+		response += "; client_no_context_takeover"
+	}
+	if st.writeMaxWindowBits < 15 {
+		response += "; server_max_window_bits=" + strconv.Itoa(st.writeMaxWindowBits)
+	}
+	if p.ClientMaxWindowBits != 0 {
+		response += "; client_max_window_bits=" + strconv.Itoa(st.readMaxWindowBits)
+	}
+	return st, response, true
+}
+
+// params returns the compression parameters of a connection with the
+// configuration.
+func (st deflateState) params(isServer bool) CompressionParams {
+	if isServer {
+		return CompressionParams{
+			ServerNoContextTakeover: !st.writeContextTakeover,
+			ClientNoContextTakeover: !st.readContextTakeover,
+			ServerMaxWindowBits:     st.writeMaxWindowBits,
+			ClientMaxWindowBits:     st.readMaxWindowBits,
+		}
+	}
+	return CompressionParams{
+		ServerNoContextTakeover: !st.readContextTakeover,
+		ClientNoContextTakeover: !st.writeContextTakeover,
+		ServerMaxWindowBits:     st.readMaxWindowBits,
+		ClientMaxWindowBits:     st.writeMaxWindowBits,
+	}
+}
+
+// serverDeflateState returns the server configuration for the negotiated
+// parameters p.
+func serverDeflateState(p *CompressionParams) deflateState {
+	st := deflateState{
+		readContextTakeover:  !p.ClientNoContextTakeover,
+		readMaxWindowBits:    p.ClientMaxWindowBits,
+		writeContextTakeover: !p.ServerNoContextTakeover,
+		writeMaxWindowBits:   p.ServerMaxWindowBits,
+	}
+	if st.readMaxWindowBits == 0 {
+		st.readMaxWindowBits = 15
+	}
+	if st.writeMaxWindowBits == 0 {
+		st.writeMaxWindowBits = 15
+	}
+	return st
+}
+
+// configure sets the compression functions of the connection.
+func (st deflateState) configure(c *Conn) {
+	c.compressionParams = st.params(c.isServer)
+	c.compressionNegotiated = true
+	if st.writeContextTakeover || st.writeMaxWindowBits < 15 {
+		w := &contextWriter{
+			contextTakeover: st.writeContextTakeover,
+			huffmanOnly:     st.writeMaxWindowBits < 15,
+		}
+		c.newCompressionWriter = w.newWriter
+		c.statefulCompression = true
+	} else {
+		c.newCompressionWriter = compressNoContextTakeover
+	}
+	if st.readContextTakeover {
+		r := &contextReader{window: 1 << uint(st.readMaxWindowBits)}
+		c.newDecompressionReader = r.newReader
+	} else {
+		c.newDecompressionReader = decompressNoContextTakeover
+	}
+}
+
+// contextWriter compresses the messages written to a connection with a
+// flate writer that is optionally kept between messages.
+type contextWriter struct {
+	contextTakeover bool
+	huffmanOnly     bool // compress without references for a small window
+	fw              *flate.Writer
+	level           int
+	tw              truncWriter
+}
+
+func (cw *contextWriter) newWriter(w io.WriteCloser, level int) io.WriteCloser {
+	if cw.huffmanOnly {
+		level = flate.HuffmanOnly
+	}
+	if cw.fw == nil || level != cw.level {
+		// A new writer does not reference earlier messages.
+		cw.fw, _ = flate.NewWriter(&cw.tw, level)
+		cw.level = level
+	} else if !cw.contextTakeover {
+		cw.fw.Reset(&cw.tw)
+	}
+	cw.tw = truncWriter{w: w}
+	return &contextWriteWrapper{cw: cw}
+}
+
+type contextWriteWrapper struct {
+	cw *contextWriter
+}
+
+func (w *contextWriteWrapper) Write(p []byte) (int, error) {
+	if w.cw == nil {
+		return 0, errWriteClosed
+	}
+	return w.cw.fw.Write(p)
+}
+
+func (w *contextWriteWrapper) Close() error {
+	if w.cw == nil {
+		return errWriteClosed
+	}
+	cw := w.cw
+	w.cw = nil
+	err1 := cw.fw.Flush()
+	if err1 != nil {
+		// The compression context is not usable after a failed write.
+		cw.fw = nil
+	}
+	if cw.tw.p != [4]byte{0, 0, 0xff, 0xff} {
+		cw.fw = nil
+		return errors.New("websocket: internal error, unexpected bytes at end of flate stream")
+	}
+	err2 := cw.tw.w.Close()
+	cw.tw.w = nil
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// contextReader decompresses messages read from a connection with the
+// decompressed output of earlier messages as the dictionary.
+type contextReader struct {
+	window int
+	dict   []byte
+}
+
+func (cr *contextReader) newReader(r io.Reader) io.ReadCloser {
+	fr, _ := flateReaderPool.Get().(io.ReadCloser)
+	fr.(flate.Resetter).Reset(io.MultiReader(r, strings.NewReader(flateReadTail)), cr.dict)
+	return &contextReadWrapper{fr: fr, cr: cr}
+}
+
+// append adds decompressed output to the dictionary.
+func (cr *contextReader) append(p []byte) {
+	if len(p) >= cr.window {
+		cr.dict = append(cr.dict[:0], p[len(p)-cr.window:]...)
+		return
+	}
+	if n := len(cr.dict) + len(p) - cr.window; n > 0 {
+		copy(cr.dict, cr.dict[n:])
+		cr.dict = cr.dict[:len(cr.dict)-n]
+	}
+	cr.dict = append(cr.dict, p...)
+}
+
+type contextReadWrapper struct {
+	fr io.ReadCloser
+	cr *contextReader
+}
+
+func (r *contextReadWrapper) Read(p []byte) (int, error) {
+	if r.fr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := r.fr.Read(p)
+	r.cr.append(p[:n])
+	if err == io.EOF {
+		r.release()
+	}
+	return n, err
+}
+
+func (r *contextReadWrapper) release() {
+	flateReaderPool.Put(r.fr)
+	r.fr = nil
+}
+
+// Close reads the remainder of the message to keep the dictionary in sync
+// with the peer.
+func (r *contextReadWrapper) Close() error {
+	if r.fr == nil {
+		return io.ErrClosedPipe
+	}
+	var buf [512]byte
+	var err error
+	for err == nil {
+		var n int
+		n, err = r.fr.Read(buf[:])
+		r.cr.append(buf[:n])
+	}
+	r.release()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
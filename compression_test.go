@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -78,3 +81,300 @@ func TestValidCompressionLevel(t *testing.T) {
 		}
 	}
 }
+
+var negotiateClientCompressionTests = []struct {
+	params CompressionParams
+	ext    map[string]string
+	want   deflateState
+	ok     bool
+}{
+	{
+		defaultCompressionParams,
+		map[string]string{"server_no_context_takeover": "", "client_no_context_takeover": ""},
+		deflateState{false, 15, false, 15},
+		true,
+	},
+	{
+		defaultCompressionParams,
+		map[string]string{"server_no_context_takeover": ""},
+		deflateState{false, 15, false, 15},
+		true,
+	},
+	{
+		defaultCompressionParams,
+		map[string]string{"client_no_context_takeover": ""},
+		deflateState{},
+		false,
+	},
+	{
+		CompressionParams{},
+		map[string]string{},
+		deflateState{true, 15, true, 15},
+		true,
+	},
+	{
+		CompressionParams{},
+		map[string]string{"client_no_context_takeover": "", "server_max_window_bits": "10"},
+		deflateState{true, 10, false, 15},
+		true,
+	},
+	{
+		CompressionParams{ServerMaxWindowBits: 10, ClientMaxWindowBits: 12},
+		map[string]string{"server_max_window_bits": "9", "client_max_window_bits": "11"},
+		deflateState{true, 9, true, 11},
+		true,
+	},
+	{
+		CompressionParams{ClientMaxWindowBits: 12},
+		map[string]string{},
+		deflateState{true, 15, true, 12},
+		true,
+	},
+	{CompressionParams{ServerMaxWindowBits: 10}, map[string]string{}, deflateState{}, false},
+	{CompressionParams{ServerMaxWindowBits: 10}, map[string]string{"server_max_window_bits": "11"}, deflateState{}, false},
+	{CompressionParams{}, map[string]string{"client_max_window_bits": "10"}, deflateState{}, false},
+	{CompressionParams{}, map[string]string{"server_max_window_bits": "7"}, deflateState{}, false},
+	{CompressionParams{}, map[string]string{"server_max_window_bits": "010"}, deflateState{}, false},
+	{CompressionParams{}, map[string]string{"server_no_context_takeover": "1"}, deflateState{}, false},
+	{CompressionParams{}, map[string]string{"unknown": ""}, deflateState{}, false},
+}
+
+func TestNegotiateClientCompression(t *testing.T) {
+	for _, tt := range negotiateClientCompressionTests {
+		ext := map[string]string{"": "permessage-deflate"}
+		for k, v := range tt.ext {
+			ext[k] = v
+		}
+		got, err := negotiateClientCompression(&tt.params, ext)
+		if (err == nil) != tt.ok {
+			t.Errorf("negotiateClientCompression(%+v, %v) returned error %v", tt.params, tt.ext, err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("negotiateClientCompression(%+v, %v) = %+v, want %+v", tt.params, tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionParamsOffer(t *testing.T) {
+	p := CompressionParams{ClientNoContextTakeover: true, ServerMaxWindowBits: 10, ClientMaxWindowBits: 9}
+	want := "permessage-deflate; client_no_context_takeover; server_max_window_bits=10; client_max_window_bits=9"
+	if got := p.offer(); got != want {
+		t.Errorf("offer() = %q, want %q", got, want)
+	}
+	for _, bits := range []int{-1, 7, 16} {
+		p := CompressionParams{ServerMaxWindowBits: bits}
+		if err := p.validate(); err == nil {
+			t.Errorf("validate() with window bits %d returned nil error", bits)
+		}
+	}
+}
+
+func TestContextTakeover(t *testing.T) {
+	for _, st := range []deflateState{
+		{true, 15, true, 15},
+		{true, 8, true, 8},
+		{false, 15, true, 15},
+		{true, 15, false, 9},
+	} {
+		var buf bytes.Buffer
+		wc := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+		st.configure(wc)
+		rc := newConn(fakeNetConn{Reader: &buf}, false, 1024, 1024)
+		deflateState{st.writeContextTakeover, st.writeMaxWindowBits, st.readContextTakeover, st.readMaxWindowBits}.configure(rc)
+
+		// Messages share a body that is not compressible within one
+		// message.
+		var body []byte
+		for x := uint32(1); len(body) < 300; {
+			x = x*1103515245 + 12345
+			body = append(body, byte('a'+(x>>16)%26))
+		}
+		messages := make([][]byte, 20)
+		for i := range messages {
+			messages[i] = []byte(fmt.Sprintf("%d %s", i, body))
+		}
+		var sizes []int
+		for i, m := range messages {
+			n := buf.Len()
+			if i%5 == 4 {
+				pm, err := NewPreparedMessage(TextMessage, m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := wc.WritePreparedMessage(pm); err != nil {
+					t.Fatalf("%+v: WritePreparedMessage: %v", st, err)
+				}
+			} else if err := wc.WriteMessage(TextMessage, m); err != nil {
+				t.Fatalf("%+v: WriteMessage: %v", st, err)
+			}
+			sizes = append(sizes, buf.Len()-n)
+		}
+		for i, want := range messages {
+			if i%3 == 2 {
+				// Skip part of the message to check that the
+				// dictionary is kept in sync.
+				_, r, err := rc.NextReader()
+				if err != nil {
+					t.Fatalf("%+v: NextReader: %v", st, err)
+				}
+				r.Read(make([]byte, 3))
+				continue
+			}
+			_, p, err := rc.ReadMessage()
+			if err != nil {
+				t.Fatalf("%+v: ReadMessage: %v", st, err)
+			}
+			if !bytes.Equal(p, want) {
+				t.Fatalf("%+v: message %d = %q, want %q", st, i, p, want)
+			}
+		}
+		if st.writeContextTakeover && st.writeMaxWindowBits == 15 && sizes[1] >= sizes[0]/2 {
+			t.Errorf("%+v: context takeover did not reduce message size: %v", st, sizes)
+		}
+	}
+}
+
+func TestDialCompressionParams(t *testing.T) {
+	var offer string
+	s := extensionServer(t, "permessage-deflate; server_max_window_bits=10; client_max_window_bits=9", &offer)
+	defer s.Close()
+
+	d := Dialer{
+		EnableCompression: true,
+		CompressionParams: &CompressionParams{ServerMaxWindowBits: 12, ClientMaxWindowBits: 10},
+	}
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if want := "permessage-deflate; server_max_window_bits=12; client_max_window_bits=10"; offer != want {
+		t.Errorf("offer = %q, want %q", offer, want)
+	}
+	if !ws.statefulCompression || ws.newDecompressionReader == nil {
+		t.Errorf("compression not configured from response")
+	}
+	want := []Extension{{Name: "permessage-deflate", Params: map[string]string{"server_max_window_bits": "10", "client_max_window_bits": "9"}}}
+	if got := ws.Extensions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Extensions() = %v, want %v", got, want)
+	}
+
+	d.CompressionParams = &CompressionParams{ServerMaxWindowBits: 9}
+	if ws, _, err := d.Dial(makeWsProto(s.URL), nil); err != errInvalidCompression {
+		if ws != nil {
+			ws.Close()
+		}
+		t.Errorf("Dial with rejected parameters returned %v, want %v", err, errInvalidCompression)
+	}
+}
+
+var negotiateServerCompressionTests = []struct {
+	params   CompressionParams
+	offer    string
+	want     deflateState
+	response string
+}{
+	{
+		defaultCompressionParams,
+		"permessage-deflate",
+		deflateState{false, 15, false, 15},
+		"permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+	},
+	{
+		CompressionParams{},
+		"permessage-deflate; client_max_window_bits",
+		deflateState{true, 15, true, 15},
+		"permessage-deflate",
+	},
+	{
+		CompressionParams{},
+		"permessage-deflate; server_no_context_takeover; server_max_window_bits=10",
+		deflateState{true, 15, false, 10},
+		"permessage-deflate; server_no_context_takeover; server_max_window_bits=10",
+	},
+	{
+		CompressionParams{ServerMaxWindowBits: 9, ClientMaxWindowBits: 10},
+		"permessage-deflate; client_max_window_bits=12",
+		deflateState{true, 10, true, 9},
+		"permessage-deflate; server_max_window_bits=9; client_max_window_bits=10",
+	},
+	{
+		CompressionParams{ClientMaxWindowBits: 10},
+		"permessage-deflate, permessage-deflate; client_max_window_bits",
+		deflateState{true, 10, true, 15},
+		"permessage-deflate; client_max_window_bits=10",
+	},
+	{
+		CompressionParams{ClientMaxWindowBits: 10},
+		"permessage-deflate",
+		deflateState{},
+		"",
+	},
+	{
+		CompressionParams{},
+		"permessage-deflate; unknown, permessage-deflate; server_max_window_bits=16",
+		deflateState{},
+		"",
+	},
+}
+
+func TestNegotiateServerCompression(t *testing.T) {
+	for _, tt := range negotiateServerCompressionTests {
+		u := Upgrader{EnableCompression: true, CompressionParams: &tt.params}
+		r := &http.Request{Header: http.Header{"Sec-Websocket-Extensions": {tt.offer}}}
+		se, err := u.negotiateExtensions(r)
+		if err != nil {
+			t.Fatalf("negotiateExtensions: %v", err)
+		}
+		if se.compress != (tt.response != "") || se.deflate != tt.want || se.response() != tt.response {
+			t.Errorf("negotiate(%+v, %q) = %+v, %q, %v, want %+v, %q", tt.params, tt.offer, se.deflate, se.response(), se.compress, tt.want, tt.response)
+		}
+	}
+}
+
+func TestUpgradeCompressionParams(t *testing.T) {
+	u := Upgrader{
+		EnableCompression: true,
+		CompressionParams: &CompressionParams{ClientNoContextTakeover: true, ServerMaxWindowBits: 12},
+	}
+	params := make(chan CompressionParams, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		p, _ := ws.CompressionParams()
+		params <- p
+		mt, m, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		ws.WriteMessage(mt, m)
+	}))
+	defer s.Close()
+
+	d := Dialer{EnableCompression: true, CompressionParams: &CompressionParams{}}
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	want := CompressionParams{ClientNoContextTakeover: true, ServerMaxWindowBits: 12, ClientMaxWindowBits: 15}
+	if got, ok := ws.CompressionParams(); !ok || got != want {
+		t.Errorf("client CompressionParams() = %+v, %v, want %+v", got, ok, want)
+	}
+	if got := <-params; got != want {
+		t.Errorf("server CompressionParams() = %+v, want %+v", got, want)
+	}
+	msg := bytes.Repeat([]byte("hello "), 100)
+	if err := ws.WriteMessage(TextMessage, msg); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, p, err := ws.ReadMessage(); err != nil || !bytes.Equal(p, msg) {
+		t.Errorf("ReadMessage returned %q, %v", p, err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ConcurrentWriter serializes writes to a connection from multiple
+// goroutines. The methods of a ConcurrentWriter may be called concurrently.
+// Writers are served in the order they call a write method, so a goroutine
+// writing a stream of messages does not starve other goroutines.
+//
+// The application must not call the connection's write methods directly
+// while using a ConcurrentWriter, except for WriteControl.
+type ConcurrentWriter struct {
+	c *Conn
+
+	mu      sync.Mutex
+	locked  bool
+	waiters []chan struct{}
+}
+
+// NewConcurrentWriter returns a concurrent writer for the connection.
+func NewConcurrentWriter(c *Conn) *ConcurrentWriter {
+	return &ConcurrentWriter{c: c}
+}
+
+// lock acquires the write lock. Waiting goroutines acquire the lock in the
+// order they called lock.
+func (w *ConcurrentWriter) lock() {
+	w.mu.Lock()
+	if !w.locked {
+		w.locked = true
+		w.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	w.waiters = append(w.waiters, ch)
+	w.mu.Unlock()
+	<-ch
+}
+
+// unlock releases the write lock to the longest waiting goroutine.
+func (w *ConcurrentWriter) unlock() {
+	w.mu.Lock()
+	if len(w.waiters) > 0 {
+		ch := w.waiters[0]
+		w.waiters[0] = nil
+		w.waiters = w.waiters[1:]
+		close(ch)
+	} else {
+		w.locked = false
+	}
+	w.mu.Unlock()
+}
+
+// WriteMessage writes a message. See Conn.WriteMessage.
+func (w *ConcurrentWriter) WriteMessage(messageType int, data []byte) error {
+	w.lock()
+	defer w.unlock()
+	return w.c.WriteMessage(messageType, data)
+}
+
+// WritePreparedMessage writes a prepared message. See
+// Conn.WritePreparedMessage.
+func (w *ConcurrentWriter) WritePreparedMessage(pm *PreparedMessage) error {
+	w.lock()
+	defer w.unlock()
+	return w.c.WritePreparedMessage(pm)
+}
+
+// WriteJSON writes the JSON encoding of v as a message. See Conn.WriteJSON.
+func (w *ConcurrentWriter) WriteJSON(v interface{}) error {
+	w.lock()
+	defer w.unlock()
+	return w.c.WriteJSON(v)
+}
+
+// WriteControl writes a control message. Control messages are not queued
+// behind data messages. See Conn.WriteControl.
+func (w *ConcurrentWriter) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return w.c.WriteControl(messageType, data, deadline)
+}
+
+// NextWriter returns a writer for the next message. Other goroutines wait
+// to write until the returned writer is closed. The application must close
+// the writer. See Conn.NextWriter.
+func (w *ConcurrentWriter) NextWriter(messageType int) (io.WriteCloser, error) {
+	w.lock()
+	wc, err := w.c.NextWriter(messageType)
+	if err != nil {
+		w.unlock()
+		return nil, err
+	}
+	return &concurrentMessageWriter{WriteCloser: wc, w: w}, nil
+}
+
+// Do calls f with the connection while holding the write lock. Use Do to
+// call write methods of the connection that ConcurrentWriter does not wrap.
+func (w *ConcurrentWriter) Do(f func(c *Conn) error) error {
+	w.lock()
+	defer w.unlock()
+	return f(w.c)
+}
+
+type concurrentMessageWriter struct {
+	io.WriteCloser
+	w      *ConcurrentWriter
+	closed bool
+}
+
+func (mw *concurrentMessageWriter) Close() error {
+	err := mw.WriteCloser.Close()
+	if !mw.closed {
+		mw.closed = true
+		mw.w.unlock()
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWriter(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	w := NewConcurrentWriter(wc)
+
+	const (
+		goroutines = 8
+		messages   = 50
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				data := []byte(strconv.Itoa(i) + ":" + strconv.Itoa(j))
+				var err error
+				if j%2 == 0 {
+					err = w.WriteMessage(TextMessage, data)
+				} else {
+					var mw io.WriteCloser
+					mw, err = w.NextWriter(TextMessage)
+					if err == nil {
+						mw.Write(data[:1])
+						mw.Write(data[1:])
+						err = mw.Close()
+					}
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	rc := newConn(fakeNetConn{Reader: bufio.NewReader(&buf)}, false, 1024, 1024)
+	next := make(map[string]int)
+	for n := 0; n < goroutines*messages; n++ {
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		i := bytes.IndexByte(p, ':')
+		if i < 0 {
+			t.Fatalf("corrupt message %q", p)
+		}
+		g, j := string(p[:i]), string(p[i+1:])
+		if j != strconv.Itoa(next[g]) {
+			t.Fatalf("goroutine %s message %s, want %d", g, j, next[g])
+		}
+		next[g]++
+	}
+}
@@ -6,6 +6,9 @@ package websocket
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -14,6 +17,7 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -28,6 +32,8 @@ const (
 	// Frame header byte 1 bits from Section 5.2 of RFC 6455
 	maskBit = 1 << 7
 
+	extensionBits = rsv2Bit | rsv3Bit
+
 	maxFrameHeaderSize         = 2 + 8 + 4 // Fixed header + length + mask
 	maxControlFramePayloadSize = 125
 
@@ -35,6 +41,7 @@ const (
 
 	defaultReadBufferSize  = 4096
 	defaultWriteBufferSize = 4096
+	minReadBufferGrow      = 512 // smallest buffer returned by growReadBuffer
 
 	continuationFrame = 0
 	noFrame           = -1
@@ -81,6 +88,13 @@ const (
 	PongMessage = 10
 )
 
+// Reserved frame header bits available to extensions. The RSV1 bit is used by
+// the per message compression extension.
+const (
+	RSV2 = rsv2Bit
+	RSV3 = rsv3Bit
+)
+
 // ErrCloseSent is returned when the application writes a message to the
 // connection after sending a close message.
 var ErrCloseSent = errors.New("websocket: close sent")
@@ -89,6 +103,13 @@ var ErrCloseSent = errors.New("websocket: close sent")
 // read limit set for the connection.
 var ErrReadLimit = errors.New("websocket: read limit exceeded")
 
+// ErrConcurrentWrite is returned when the connection detects a write to the
+// network connection concurrent with another write. Detection is best-effort
+// and does not catch all concurrent use. The connection is not usable
+// for writing after this error is returned. See the concurrency section in the
+// package documentation for more info.
+var ErrConcurrentWrite = errors.New("websocket: concurrent write to websocket connection")
+
 // netError satisfies the net Error interface.
 type netError struct {
 	msg       string
@@ -178,6 +199,7 @@ var (
 	errBadWriteOpCode      = errors.New("websocket: bad write message type")
 	errWriteClosed         = errors.New("websocket: write closed")
 	errInvalidControlFrame = errors.New("websocket: invalid control frame")
+	errReservedBits        = errors.New("websocket: reserved bits not enabled for extension")
 )
 
 func newMaskKey() [4]byte {
@@ -223,18 +245,35 @@ func isValidReceivedCloseCode(code int) bool {
 	return validReceivedCloseCodes[code] || (code >= 3000 && code <= 4999)
 }
 
+// BufferPool represents a pool of buffers. The *sync.Pool type satisfies this
+// interface. The values stored in the pool are described by the field that
+// holds the pool.
+type BufferPool interface {
+	// Get gets a value from the pool or returns nil if the pool is empty.
+	Get() interface{}
+	// Put adds a value to the pool.
+	Put(interface{})
+}
+
 // The Conn type represents a WebSocket connection.
 type Conn struct {
 	conn        net.Conn
 	isServer    bool
 	subprotocol string
+	clientIP    net.IP // effective client address for server connections
+	codec       Codec
+	extensions  []Extension // extensions accepted by the server
 
 	// Write fields
-	mu            chan bool // used as mutex to protect write to conn
-	writeBuf      []byte    // frame is constructed in this buffer.
-	writeDeadline time.Time
-	writer        io.WriteCloser // the current writer returned to the application
-	isWriting     bool           // for best-effort concurrent write detection
+	mu           chan bool      // used as mutex to protect write to conn
+	writeBuf     []byte         // frame is constructed in this buffer.
+	writePool    BufferPool     // pool for writeBuf, nil if not pooled
+	writeBufSize int            // size of writeBuf when taken from the pool
+	writer       io.WriteCloser // the current writer returned to the application
+	isWriting    int32          // for concurrent write detection, accessed atomically
+
+	writeDeadlineMu sync.Mutex
+	writeDeadline   time.Time // set by SetWriteDeadline
 
 	writeErrMu sync.Mutex
 	writeErr   error
@@ -242,6 +281,9 @@ type Conn struct {
 	enableWriteCompression bool
 	compressionLevel       int
 	newCompressionWriter   func(io.WriteCloser, int) io.WriteCloser
+	statefulCompression    bool // compressed messages depend on connection state
+	compressionNegotiated  bool
+	compressionParams      CompressionParams // negotiated parameters
 
 	// Read fields
 	reader        io.ReadCloser // the current reader returned to the application
@@ -251,6 +293,7 @@ type Conn struct {
 	readFinal     bool  // true the current message has more frames.
 	readLength    int64 // Message size.
 	readLimit     int64 // Maximum message size.
+	jsonReadLimit int64 // Maximum message size for ReadJSON.
 	readMaskPos   int
 	readMaskKey   [4]byte
 	handlePong    func(string) error
@@ -258,13 +301,44 @@ type Conn struct {
 	handleClose   func(int, string) error
 	readErrCount  int
 	messageReader *messageReader // the current low-level reader
+	jsonDecoder   *jsonDecoder   // decoder reused by ReadJSON
 
 	readDecompress         bool // whether last read frame had RSV1 set
 	newDecompressionReader func(io.Reader) io.ReadCloser
+
+	reservedBits int  // RSV2 and RSV3 bits owned by an extension
+	readReserved byte // reserved bits in first frame of current message
+
+	closed    int32        // set to 1 by Close, accessed atomically
+	leak      *leakTracker // non-nil when leak detection is enabled
+	keepalive *keepalive   // non-nil when the keepalive is running
+	onClose   []func()     // called once by Close, see addCloseHook
+	id        uint64       // identifier assigned by a Registry
+
+	onReadError func() // called when NextReader first returns an error
+
+	metrics Metrics // Metrics of the Upgrader, used by EventLoop
+
+	tlsState *tls.ConnectionState // TLS state of the handshake request
+
+	ctx       context.Context // see Context
+	cancelCtx context.CancelFunc
+
+	readDeadline time.Time  // set by SetReadDeadline
+	readProbe    [1]byte    // used by ReadMessageInto
+	readPool     BufferPool // used by ReadMessageInto, holds []byte
+
+	// Close code and text sent for a read failure detected by this endpoint.
+	localCloseCode int
+	localCloseText string
+
+	pingMu  sync.Mutex
+	pingSeq uint64                   // guarded by pingMu
+	pings   map[string]chan struct{} // pending pings sent by Ping, keyed by payload
 }
 
 func newConn(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int) *Conn {
-	return newConnBRW(conn, isServer, readBufferSize, writeBufferSize, nil)
+	return newConnBRW(conn, isServer, readBufferSize, writeBufferSize, nil, nil)
 }
 
 type writeHook struct {
@@ -276,17 +350,17 @@ func (wh *writeHook) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func newConnBRW(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int, brw *bufio.ReadWriter) *Conn {
+func newConnBRW(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int, writeBufferPool BufferPool, brw *bufio.ReadWriter) *Conn {
 	mu := make(chan bool, 1)
 	mu <- true
 
 	var br *bufio.Reader
-	if readBufferSize == 0 && brw != nil && brw.Reader != nil {
-		// Reuse the supplied bufio.Reader if the buffer has a useful size.
-		// This code assumes that peek on a reader returns
-		// bufio.Reader.buf[:0].
+	if brw != nil && brw.Reader != nil {
+		// Reuse the supplied bufio.Reader if the buffer has a useful size
+		// and holds at least readBufferSize bytes. This code assumes that
+		// peek on a reader returns bufio.Reader.buf[:0].
 		brw.Reader.Reset(conn)
-		if p, err := brw.Reader.Peek(0); err == nil && cap(p) >= 256 {
+		if p, err := brw.Reader.Peek(0); err == nil && cap(p) >= 256 && cap(p) >= readBufferSize {
 			br = brw.Reader
 		}
 	}
@@ -301,24 +375,27 @@ func newConnBRW(conn net.Conn, isServer bool, readBufferSize, writeBufferSize in
 	}
 
 	var writeBuf []byte
-	if writeBufferSize == 0 && brw != nil && brw.Writer != nil {
-		// Use the bufio.Writer's buffer if the buffer has a useful size. This
-		// code assumes that bufio.Writer.buf[:1] is passed to the
-		// bufio.Writer's underlying writer.
+	if writeBufferPool == nil && brw != nil && brw.Writer != nil {
+		// Use the bufio.Writer's buffer if the buffer has a useful size and
+		// holds at least writeBufferSize bytes. This code assumes that
+		// bufio.Writer.buf[:1] is passed to the bufio.Writer's underlying
+		// writer.
 		var wh writeHook
 		brw.Writer.Reset(&wh)
 		brw.Writer.WriteByte(0)
 		brw.Flush()
-		if cap(wh.p) >= maxFrameHeaderSize+256 {
+		if cap(wh.p) >= maxFrameHeaderSize+256 && cap(wh.p) >= writeBufferSize {
 			writeBuf = wh.p[:cap(wh.p)]
 		}
 	}
 
-	if writeBuf == nil {
-		if writeBufferSize == 0 {
-			writeBufferSize = defaultWriteBufferSize
-		}
-		writeBuf = make([]byte, writeBufferSize+maxFrameHeaderSize)
+	if writeBufferSize == 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	writeBufferSize += maxFrameHeaderSize
+
+	if writeBuf == nil && writeBufferPool == nil {
+		writeBuf = make([]byte, writeBufferSize)
 	}
 
 	c := &Conn{
@@ -328,26 +405,95 @@ func newConnBRW(conn net.Conn, isServer bool, readBufferSize, writeBufferSize in
 		mu:                     mu,
 		readFinal:              true,
 		writeBuf:               writeBuf,
+		writePool:              writeBufferPool,
+		writeBufSize:           writeBufferSize,
 		enableWriteCompression: true,
 		compressionLevel:       defaultCompressionLevel,
+		leak:                   newLeakTracker(conn),
 	}
 	c.SetCloseHandler(nil)
 	c.SetPingHandler(nil)
 	c.SetPongHandler(nil)
+	statsConnOpened()
 	return c
 }
 
+// labels returns the key value pairs used to label goroutines owned by the
+// connection in profiles.
+func (c *Conn) labels(task string) []string {
+	role := "client"
+	if c.isServer {
+		role = "server"
+	}
+	remote := ""
+	if addr := c.conn.RemoteAddr(); addr != nil {
+		remote = addr.String()
+	}
+	return []string{
+		"websocket.task", task,
+		"websocket.role", role,
+		"websocket.remote", remote,
+		"websocket.subprotocol", c.subprotocol,
+	}
+}
+
+// CompressionParams returns the permessage-deflate parameters negotiated for
+// the connection. The window bits are 15 when the window is not limited. The
+// boolean result is false if compression was not negotiated.
+func (c *Conn) CompressionParams() (CompressionParams, bool) {
+	return c.compressionParams, c.compressionNegotiated
+}
+
 // Subprotocol returns the negotiated protocol for the connection.
 func (c *Conn) Subprotocol() string {
 	return c.subprotocol
 }
 
+// CloseWrite starts the closing handshake by sending a close message with
+// the code and text. After CloseWrite, the write methods return ErrCloseSent
+// and the connection remains open for reading. The read methods return
+// messages sent by the peer before its close message and then a *CloseError
+// with the peer's close code. Call Close after the peer's close message is
+// received or the application stops waiting for it.
+//
+// The close message is written with the deadline set by SetWriteDeadline,
+// or a one second deadline if none is set.
+func (c *Conn) CloseWrite(code int, text string) error {
+	deadline := c.getWriteDeadline()
+	if deadline.IsZero() {
+		deadline = time.Now().Add(writeWait)
+	}
+	return c.WriteControl(CloseMessage, FormatCloseMessage(code, text), deadline)
+}
+
 // Close closes the underlying network connection without sending or waiting
 // for a close message.
 func (c *Conn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.leak.close()
+		statsConnClosed()
+		if c.keepalive != nil {
+			c.keepalive.stop()
+		}
+		for _, f := range c.onClose {
+			f()
+		}
+	}
 	return c.conn.Close()
 }
 
+// addCloseHook adds a function called once when the connection is closed.
+// Hooks must be added before the connection is returned to the application.
+func (c *Conn) addCloseHook(f func()) {
+	c.onClose = append(c.onClose, f)
+}
+
+// ID returns the identifier assigned to the connection by the Upgrader's
+// Registry. ID returns zero if the connection is not in a registry.
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
 // LocalAddr returns the local network address.
 func (c *Conn) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
@@ -358,6 +504,42 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// ClientIP returns the IP address of the client for a server connection. The
+// address is taken from the forwarding headers when the peer is a trusted
+// proxy. See Upgrader.TrustedProxies. ClientIP returns nil for a client
+// connection.
+func (c *Conn) ClientIP() net.IP {
+	return c.clientIP
+}
+
+// TLSConnectionState returns basic TLS details about the connection. For a
+// server connection, the state is captured from the handshake request, which
+// includes connections served over HTTP/2. The ok result is false if the
+// connection does not use TLS.
+func (c *Conn) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	if c.tlsState != nil {
+		return *c.tlsState, true
+	}
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tlsConn.ConnectionState(), true
+}
+
+// VerifiedPeerCertificate returns the leaf certificate of the first verified
+// certificate chain presented by the peer. For a server connection, the
+// certificate identifies a client authenticated with mutual TLS. The
+// certificate is nil if the peer did not present a certificate or the
+// certificate was not verified.
+func (c *Conn) VerifiedPeerCertificate() *x509.Certificate {
+	state, ok := c.TLSConnectionState()
+	if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
 // Write methods
 
 func (c *Conn) writeFatal(err error) error {
@@ -365,13 +547,25 @@ func (c *Conn) writeFatal(err error) error {
 	c.writeErrMu.Lock()
 	if c.writeErr == nil {
 		c.writeErr = err
+		statsWriteError(err)
 	}
 	c.writeErrMu.Unlock()
 	return err
 }
 
-func (c *Conn) write(frameType int, deadline time.Time, buf0, buf1 []byte) error {
-	<-c.mu
+// write writes a frame to the network connection. If ctx is not nil, then
+// the write lock wait and the network write of this frame are interrupted
+// when ctx is done.
+func (c *Conn) write(ctx context.Context, frameType int, deadline time.Time, buf0, buf1 []byte) error {
+	if ctx != nil {
+		select {
+		case <-c.mu:
+		case <-ctx.Done():
+			return c.writeFatal(ctx.Err())
+		}
+	} else {
+		<-c.mu
+	}
 	defer func() { c.mu <- true }()
 
 	c.writeErrMu.Lock()
@@ -382,6 +576,12 @@ func (c *Conn) write(frameType int, deadline time.Time, buf0, buf1 []byte) error
 	}
 
 	c.conn.SetWriteDeadline(deadline)
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return c.writeFatal(err)
+		}
+		defer c.watchWriteContext(ctx)()
+	}
 	if len(buf1) == 0 {
 		_, err = c.conn.Write(buf0)
 	} else {
@@ -396,9 +596,30 @@ func (c *Conn) write(frameType int, deadline time.Time, buf0, buf1 []byte) error
 	return nil
 }
 
+// beginWrite marks the start of a data frame write. If another write is in
+// progress, then the shared write buffer may be corrupt and beginWrite fails
+// the connection with ErrConcurrentWrite.
+func (c *Conn) beginWrite() error {
+	if !atomic.CompareAndSwapInt32(&c.isWriting, 0, 1) {
+		return c.writeFatal(ErrConcurrentWrite)
+	}
+	return nil
+}
+
+func (c *Conn) endWrite() {
+	atomic.StoreInt32(&c.isWriting, 0)
+}
+
 // WriteControl writes a control message with the given deadline. The allowed
 // message types are CloseMessage, PingMessage and PongMessage.
 func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return c.writeControl(nil, messageType, data, deadline)
+}
+
+// writeControl writes a control message. If ctx is not nil, then the write
+// lock wait and the network write of this frame are interrupted when ctx is
+// done.
+func (c *Conn) writeControl(ctx context.Context, messageType int, data []byte, deadline time.Time) error {
 	if !isControl(messageType) {
 		return errBadWriteOpCode
 	}
@@ -432,12 +653,19 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 		}
 	}
 
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
 	timer := time.NewTimer(d)
 	select {
 	case <-c.mu:
 		timer.Stop()
 	case <-timer.C:
 		return errWriteTimeout
+	case <-done:
+		timer.Stop()
+		return ctx.Err()
 	}
 	defer func() { c.mu <- true }()
 
@@ -449,6 +677,12 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 	}
 
 	c.conn.SetWriteDeadline(deadline)
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		defer c.watchWriteContext(ctx)()
+	}
 	_, err = c.conn.Write(buf)
 	if err != nil {
 		return c.writeFatal(err)
@@ -478,6 +712,33 @@ func (c *Conn) prepWrite(messageType int) error {
 	return err
 }
 
+// writePoolData is the type added to the write buffer pool. This wrapper is
+// used to prevent applications from peeking at and depending on the values
+// added to the pool.
+type writePoolData struct{ buf []byte }
+
+// getWriteBuf gets a write buffer from the write buffer pool if the
+// connection does not hold a write buffer.
+func (c *Conn) getWriteBuf() {
+	if c.writeBuf != nil {
+		return
+	}
+	if wpd, ok := c.writePool.Get().(writePoolData); ok && len(wpd.buf) == c.writeBufSize {
+		c.writeBuf = wpd.buf
+	} else {
+		c.writeBuf = make([]byte, c.writeBufSize)
+	}
+}
+
+// putWriteBuf puts the write buffer in the write buffer pool when the
+// connection has a pool.
+func (c *Conn) putWriteBuf() {
+	if c.writePool != nil && c.writeBuf != nil {
+		c.writePool.Put(writePoolData{buf: c.writeBuf})
+		c.writeBuf = nil
+	}
+}
+
 // NextWriter returns a writer for the next message to send. The writer's Close
 // method flushes the complete message to the network.
 //
@@ -487,14 +748,24 @@ func (c *Conn) prepWrite(messageType int) error {
 // All message types (TextMessage, BinaryMessage, CloseMessage, PingMessage and
 // PongMessage) are supported.
 func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return c.nextWriter(nil, messageType, 0, nil)
+}
+
+// nextWriter returns a writer for the next message. The ctx and deadline
+// arguments are described in the messageWriter type.
+func (c *Conn) nextWriter(ctx context.Context, messageType int, reserved byte, deadline *time.Time) (io.WriteCloser, error) {
 	if err := c.prepWrite(messageType); err != nil {
 		return nil, err
 	}
+	c.getWriteBuf()
 
 	mw := &messageWriter{
 		c:         c,
 		frameType: messageType,
 		pos:       maxFrameHeaderSize,
+		reserved:  reserved,
+		ctx:       ctx,
+		deadline:  deadline,
 	}
 	c.writer = mw
 	if c.newCompressionWriter != nil && c.enableWriteCompression && isData(messageType) {
@@ -505,12 +776,37 @@ func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
 	return c.writer, nil
 }
 
+// NextWriterReservedBits is like NextWriter, but also sets the reserved bits
+// in the header of the first frame of the message. The bits must be enabled
+// with EnableReservedBits.
+func (c *Conn) NextWriterReservedBits(messageType int, bits int) (io.WriteCloser, error) {
+	if bits&^c.reservedBits != 0 {
+		return nil, errReservedBits
+	}
+	return c.nextWriter(nil, messageType, byte(bits), nil)
+}
+
 type messageWriter struct {
 	c         *Conn
 	compress  bool // whether next call to flushFrame should set RSV1
+	reserved  byte // RSV2 and RSV3 bits for next call to flushFrame
 	pos       int  // end of data in writeBuf.
 	frameType int  // type of the current frame.
 	err       error
+
+	// If ctx is not nil, then frame writes are interrupted when ctx is done.
+	ctx context.Context
+
+	// If deadline is not nil, then frames are written with this deadline
+	// instead of the deadline set with SetWriteDeadline.
+	deadline *time.Time
+}
+
+func (w *messageWriter) writeDeadline() time.Time {
+	if w.deadline != nil {
+		return *w.deadline
+	}
+	return w.c.getWriteDeadline()
 }
 
 func (w *messageWriter) fatal(err error) error {
@@ -541,6 +837,8 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 		b0 |= rsv1Bit
 	}
 	w.compress = false
+	b0 |= w.reserved
+	w.reserved = 0
 
 	b1 := byte(0)
 	if !c.isServer {
@@ -579,21 +877,15 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 		}
 	}
 
-	// Write the buffers to the connection with best-effort detection of
-	// concurrent writes. See the concurrency section in the package
-	// documentation for more info.
-
-	if c.isWriting {
-		panic("concurrent write to websocket connection")
-	}
-	c.isWriting = true
-
-	err := c.write(w.frameType, c.writeDeadline, c.writeBuf[framePos:w.pos], extra)
+	// Write the buffers to the connection with detection of concurrent
+	// writes. See the concurrency section in the package documentation for
+	// more info.
 
-	if !c.isWriting {
-		panic("concurrent write to websocket connection")
+	if err := c.beginWrite(); err != nil {
+		return w.fatal(err)
 	}
-	c.isWriting = false
+	err := c.write(w.ctx, w.frameType, w.writeDeadline(), c.writeBuf[framePos:w.pos], extra)
+	c.endWrite()
 
 	if err != nil {
 		return w.fatal(err)
@@ -601,6 +893,7 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 
 	if final {
 		c.writer = nil
+		c.putWriteBuf()
 		return nil
 	}
 
@@ -707,6 +1000,10 @@ func (w *messageWriter) Close() error {
 
 // WritePreparedMessage writes prepared message into connection.
 func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
+	if c.statefulCompression && c.enableWriteCompression && isData(pm.messageType) {
+		// The compressed message cannot be shared with other connections.
+		return c.WriteMessage(pm.messageType, pm.data)
+	}
 	frameType, frameData, err := pm.frame(prepareKey{
 		isServer:         c.isServer,
 		compress:         c.newCompressionWriter != nil && c.enableWriteCompression && isData(pm.messageType),
@@ -715,21 +1012,23 @@ func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
 	if err != nil {
 		return err
 	}
-	if c.isWriting {
-		panic("concurrent write to websocket connection")
-	}
-	c.isWriting = true
-	err = c.write(frameType, c.writeDeadline, frameData, nil)
-	if !c.isWriting {
-		panic("concurrent write to websocket connection")
+	if err := c.beginWrite(); err != nil {
+		return err
 	}
-	c.isWriting = false
+	err = c.write(nil, frameType, c.getWriteDeadline(), frameData, nil)
+	c.endWrite()
 	return err
 }
 
 // WriteMessage is a helper method for getting a writer using NextWriter,
 // writing the message and closing the writer.
 func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeMessage(nil, messageType, data, nil)
+}
+
+// writeMessage writes a message. The ctx and deadline arguments are
+// described in the messageWriter type.
+func (c *Conn) writeMessage(ctx context.Context, messageType int, data []byte, deadline *time.Time) error {
 
 	if c.isServer && (c.newCompressionWriter == nil || !c.enableWriteCompression) {
 		// Fast path with no allocations and single frame.
@@ -737,14 +1036,15 @@ func (c *Conn) WriteMessage(messageType int, data []byte) error {
 		if err := c.prepWrite(messageType); err != nil {
 			return err
 		}
-		mw := messageWriter{c: c, frameType: messageType, pos: maxFrameHeaderSize}
+		c.getWriteBuf()
+		mw := messageWriter{c: c, frameType: messageType, pos: maxFrameHeaderSize, ctx: ctx, deadline: deadline}
 		n := copy(c.writeBuf[mw.pos:], data)
 		mw.pos += n
 		data = data[n:]
 		return mw.flushFrame(true, data)
 	}
 
-	w, err := c.NextWriter(messageType)
+	w, err := c.nextWriter(ctx, messageType, 0, deadline)
 	if err != nil {
 		return err
 	}
@@ -754,15 +1054,68 @@ func (c *Conn) WriteMessage(messageType int, data []byte) error {
 	return w.Close()
 }
 
+// WriteMessageDeadline is like WriteMessage, but uses deadline as the write
+// deadline for this message only. The deadline set by SetWriteDeadline is not
+// changed. A zero value for deadline means the write will not time out.
+//
+// As with the other write methods, a timeout fails the connection for
+// writing.
+func (c *Conn) WriteMessageDeadline(messageType int, data []byte, deadline time.Time) error {
+	return c.writeMessage(nil, messageType, data, &deadline)
+}
+
+// Flush waits for writes in progress on the network connection to complete.
+// If the network connection has a Flush method, then Flush also flushes the
+// network connection. Flush returns the error that failed the connection for
+// writing, if any.
+//
+// Data messages are written to the network connection when the message writer
+// is closed. Flush does not write data buffered in an open message writer.
+func (c *Conn) Flush() error {
+	<-c.mu
+	defer func() { c.mu <- true }()
+	return c.flushLocked()
+}
+
+// flushLocked flushes the network connection. The caller must hold c.mu.
+func (c *Conn) flushLocked() error {
+	c.writeErrMu.Lock()
+	err := c.writeErr
+	c.writeErrMu.Unlock()
+	if err != nil && err != ErrCloseSent {
+		return err
+	}
+	if f, ok := c.conn.(interface {
+		Flush() error
+	}); ok {
+		c.conn.SetWriteDeadline(c.getWriteDeadline())
+		if err := f.Flush(); err != nil {
+			return c.writeFatal(err)
+		}
+	}
+	return nil
+}
+
 // SetWriteDeadline sets the write deadline on the underlying network
 // connection. After a write has timed out, the websocket state is corrupt and
 // all future writes will return an error. A zero value for t means writes will
 // not time out.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadlineMu.Lock()
 	c.writeDeadline = t
+	c.writeDeadlineMu.Unlock()
 	return nil
 }
 
+// getWriteDeadline returns the deadline set by SetWriteDeadline. The methods
+// that can be called concurrently with the writer, such as CloseWrite and
+// Flush, read the deadline while the writer may set it.
+func (c *Conn) getWriteDeadline() time.Time {
+	c.writeDeadlineMu.Lock()
+	defer c.writeDeadlineMu.Unlock()
+	return c.writeDeadline
+}
+
 // Read methods
 
 func (c *Conn) advanceFrame() (int, error) {
@@ -792,6 +1145,9 @@ func (c *Conn) advanceFrame() (int, error) {
 		p[0] &^= rsv1Bit
 	}
 
+	reserved := p[0] & byte(c.reservedBits)
+	p[0] &^= reserved
+
 	if rsv := p[0] & (rsv1Bit | rsv2Bit | rsv3Bit); rsv != 0 {
 		return noFrame, c.handleProtocolError("unexpected reserved bits 0x" + strconv.FormatInt(int64(rsv), 16))
 	}
@@ -809,6 +1165,7 @@ func (c *Conn) advanceFrame() (int, error) {
 			return noFrame, c.handleProtocolError("message start before final message frame")
 		}
 		c.readFinal = final
+		c.readReserved = reserved
 	case continuationFrame:
 		if c.readFinal {
 			return noFrame, c.handleProtocolError("continuation after final message frame")
@@ -856,7 +1213,7 @@ func (c *Conn) advanceFrame() (int, error) {
 
 		c.readLength += c.readRemaining
 		if c.readLimit > 0 && c.readLength > c.readLimit {
-			c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
+			c.sendLocalClose(CloseMessageTooBig, "")
 			return noFrame, ErrReadLimit
 		}
 
@@ -881,9 +1238,14 @@ func (c *Conn) advanceFrame() (int, error) {
 
 	switch frameType {
 	case PongMessage:
+		if c.keepalive != nil {
+			atomic.StoreInt64(&c.keepalive.pongReceived, time.Now().UnixNano())
+			c.extendReadDeadline()
+		}
 		if err := c.handlePong(string(payload)); err != nil {
 			return noFrame, err
 		}
+		c.pongReceived(payload)
 	case PingMessage:
 		if err := c.handlePing(string(payload)); err != nil {
 			return noFrame, err
@@ -911,10 +1273,47 @@ func (c *Conn) advanceFrame() (int, error) {
 }
 
 func (c *Conn) handleProtocolError(message string) error {
-	c.WriteControl(CloseMessage, FormatCloseMessage(CloseProtocolError, message), time.Now().Add(writeWait))
+	c.sendLocalClose(CloseProtocolError, message)
 	return errors.New("websocket: " + message)
 }
 
+// sendLocalClose records the close code for a read failure detected by this
+// endpoint and sends a close message with the code to the peer.
+func (c *Conn) sendLocalClose(code int, text string) {
+	c.localCloseCode, c.localCloseText = code, text
+	c.WriteControl(CloseMessage, FormatCloseMessage(code, text), time.Now().Add(writeWait))
+}
+
+// CloseCode returns the close code that ended reading from the connection.
+// The code is the code received from the peer's close message, the code
+// sent to the peer when this endpoint failed the connection, or
+// CloseAbnormalClosure if the connection ended without a close message.
+// CloseCode returns zero if reading has not ended. CloseCode must not be
+// called concurrently with the read methods.
+func (c *Conn) CloseCode() int {
+	code, _ := c.closeStatus()
+	return code
+}
+
+// CloseReason returns the close text corresponding to CloseCode.
+func (c *Conn) CloseReason() string {
+	_, text := c.closeStatus()
+	return text
+}
+
+func (c *Conn) closeStatus() (int, string) {
+	switch e := c.readErr.(type) {
+	case nil:
+		return 0, ""
+	case *CloseError:
+		return e.Code, e.Text
+	}
+	if c.localCloseCode != 0 {
+		return c.localCloseCode, c.localCloseText
+	}
+	return CloseAbnormalClosure, c.readErr.Error()
+}
+
 // NextReader returns the next data message received from the peer. The
 // returned messageType is either TextMessage or BinaryMessage.
 //
@@ -938,7 +1337,7 @@ func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
 	for c.readErr == nil {
 		frameType, err := c.advanceFrame()
 		if err != nil {
-			c.readErr = hideTempErr(err)
+			c.readErr = c.keepaliveError(hideTempErr(err))
 			break
 		}
 		if frameType == TextMessage || frameType == BinaryMessage {
@@ -955,6 +1354,12 @@ func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
 	// tight loop on connection failure. To help application developers detect
 	// this error, panic on repeated reads to the failed connection.
 	c.readErrCount++
+	if c.readErrCount == 1 {
+		statsReadError(c.readErr)
+		if c.onReadError != nil {
+			c.onReadError()
+		}
+	}
 	if c.readErrCount >= 1000 {
 		panic("repeated read on failed websocket connection")
 	}
@@ -977,7 +1382,7 @@ func (r *messageReader) Read(b []byte) (int, error) {
 				b = b[:c.readRemaining]
 			}
 			n, err := c.br.Read(b)
-			c.readErr = hideTempErr(err)
+			c.readErr = c.keepaliveError(hideTempErr(err))
 			if c.isServer {
 				c.readMaskPos = maskBytes(c.readMaskKey, c.readMaskPos, b[:n])
 			}
@@ -996,7 +1401,7 @@ func (r *messageReader) Read(b []byte) (int, error) {
 		frameType, err := c.advanceFrame()
 		switch {
 		case err != nil:
-			c.readErr = hideTempErr(err)
+			c.readErr = c.keepaliveError(hideTempErr(err))
 		case frameType == TextMessage || frameType == BinaryMessage:
 			c.readErr = errors.New("websocket: internal error, unexpected text or binary in Reader")
 		}
@@ -1025,12 +1430,81 @@ func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
 	return messageType, p, err
 }
 
+// ReadMessageInto reads the next data message into *buf and returns the
+// message type and the number of bytes read. Use ReadMessageInto to reuse a
+// buffer across messages instead of allocating a slice for each message.
+//
+// If the message is longer than *buf and the connection has a read buffer
+// pool (see the ReadBufferPool field of Dialer and Upgrader), then
+// ReadMessageInto gets a larger buffer from the pool, copies the data read so
+// far to it, puts the old buffer in the pool and sets *buf to the larger
+// buffer. The pool holds []byte values. Use SetReadLimit to bound the size of
+// the buffer.
+//
+// If the message is longer than *buf and the connection does not have a read
+// buffer pool, then ReadMessageInto fills *buf, discards the rest of the
+// message and returns io.ErrShortBuffer.
+func (c *Conn) ReadMessageInto(buf *[]byte) (messageType int, n int, err error) {
+	var r io.Reader
+	messageType, r, err = c.NextReader()
+	if err != nil {
+		return messageType, 0, err
+	}
+	p := *buf
+	for {
+		if n == len(p) {
+			if c.readPool == nil {
+				// Check for data past the end of buf.
+				if _, err := io.ReadFull(r, c.readProbe[:]); err != io.EOF {
+					if err == nil {
+						err = io.ErrShortBuffer
+					}
+					return messageType, n, err
+				}
+				return messageType, n, nil
+			}
+			p = c.growReadBuffer(p)
+			*buf = p
+		}
+		var m int
+		m, err = r.Read(p[n:])
+		n += m
+		if err == io.EOF {
+			return messageType, n, nil
+		}
+		if err != nil {
+			return messageType, n, err
+		}
+	}
+}
+
+// growReadBuffer returns a buffer from the read buffer pool with the contents
+// of p and room for at least len(p) more bytes. The buffer p is put in the
+// pool.
+func (c *Conn) growReadBuffer(p []byte) []byte {
+	size := 2 * len(p)
+	if size < minReadBufferGrow {
+		size = minReadBufferGrow
+	}
+	q, _ := c.readPool.Get().([]byte)
+	if cap(q) < size {
+		q = make([]byte, size)
+	}
+	q = q[:cap(q)]
+	copy(q, p)
+	if cap(p) > 0 {
+		c.readPool.Put(p[:cap(p)])
+	}
+	return q
+}
+
 // SetReadDeadline sets the read deadline on the underlying network connection.
 // After a read has timed out, the websocket connection state is corrupt and
 // all future reads will return an error. A zero value for t means reads will
 // not time out.
 func (c *Conn) SetReadDeadline(t time.Time) error {
-	return c.conn.SetReadDeadline(t)
+	c.readDeadline = t
+	return c.conn.SetReadDeadline(c.netReadDeadline())
 }
 
 // SetReadLimit sets the maximum size for a message read from the peer. If a
@@ -1129,6 +1603,26 @@ func (c *Conn) EnableWriteCompression(enable bool) {
 	c.enableWriteCompression = enable
 }
 
+// EnableReservedBits declares that an extension negotiated for the connection
+// owns the RSV2 and RSV3 bits in bits. Received frames with these bits set are
+// not treated as a protocol error. Use ReservedBits to get the bits of a
+// received message and NextWriterReservedBits to set the bits of a sent
+// message.
+func (c *Conn) EnableReservedBits(bits int) error {
+	if bits&^extensionBits != 0 {
+		return errReservedBits
+	}
+	c.reservedBits = bits
+	return nil
+}
+
+// ReservedBits returns the extension owned reserved bits from the header of
+// the first frame of the message most recently returned by NextReader or
+// ReadMessage.
+func (c *Conn) ReservedBits() int {
+	return int(c.readReserved)
+}
+
 // SetCompressionLevel sets the flate compression level for subsequent text and
 // binary messages. This function is a noop if compression was not negotiated
 // with the peer. See the compress/flate package for a description of
@@ -0,0 +1,277 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.7
+
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UpgradeWithContext upgrades the HTTP server connection to the WebSocket
+// protocol like Upgrade and associates the connection with a context
+// derived from ctx. The context is returned by the connection's Context
+// method and is canceled when the connection is closed. The ctx argument
+// must be non-nil.
+//
+// The request context is canceled when the HTTP handler returns. Use
+// another context if the application uses the connection after the handler
+// returns.
+func (u *Upgrader) UpgradeWithContext(ctx context.Context, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if ctx == nil {
+		panic("websocket: nil context")
+	}
+	c, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, err
+	}
+	c.setContext(ctx)
+	return c, nil
+}
+
+// setContext sets the connection context to a context derived from ctx that
+// is canceled when the connection is closed.
+func (c *Conn) setContext(ctx context.Context) {
+	c.ctx, c.cancelCtx = context.WithCancel(ctx)
+	c.addCloseHook(c.cancelCtx)
+}
+
+// Context returns the context associated with the connection by
+// UpgradeWithContext or Handler. The context is canceled when the connection
+// is closed. Context returns context.Background() if no context is
+// associated with the connection.
+func (c *Conn) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// FlushContext is like Flush, but returns the context error if the context is
+// done before writes in progress complete.
+func (c *Conn) FlushContext(ctx context.Context) error {
+	select {
+	case <-c.mu:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { c.mu <- true }()
+	return c.flushLocked()
+}
+
+// NextReaderContext is like NextReader, but returns the context error if the
+// context is done before a message is available. After the context
+// interrupts a read, the connection state is corrupt and all future reads
+// return the context error.
+func (c *Conn) NextReaderContext(ctx context.Context) (messageType int, r io.Reader, err error) {
+	if err := ctx.Err(); err != nil {
+		return noFrame, nil, err
+	}
+	stop := c.watchReadContext(ctx)
+	messageType, r, err = c.NextReader()
+	return messageType, r, stop(err)
+}
+
+// ReadMessageContext is like ReadMessage, but returns the context error if
+// the context is done before the message is read. After the context
+// interrupts a read, the connection state is corrupt and all future reads
+// return the context error.
+func (c *Conn) ReadMessageContext(ctx context.Context) (messageType int, p []byte, err error) {
+	if err := ctx.Err(); err != nil {
+		return noFrame, nil, err
+	}
+	stop := c.watchReadContext(ctx)
+	messageType, p, err = c.ReadMessage()
+	return messageType, p, stop(err)
+}
+
+// watchReadContext interrupts reads on the network connection when ctx is
+// done. The returned function stops the watch and returns the error to
+// report for the read error err.
+func (c *Conn) watchReadContext(ctx context.Context) func(err error) error {
+	if ctx.Done() == nil {
+		return func(err error) error { return err }
+	}
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetReadDeadline(aLongTimeAgo)
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func(err error) error {
+		close(done)
+		if !<-interrupted {
+			return err
+		}
+		if err == nil {
+			// The read completed before the deadline took effect.
+			c.conn.SetReadDeadline(c.netReadDeadline())
+			return nil
+		}
+		c.readErr = ctx.Err()
+		return c.readErr
+	}
+}
+
+// WriteMessageContext is like WriteMessage, but returns the context error if
+// the context is done before the message is written. The context deadline
+// applies if it is earlier than the deadline set with SetWriteDeadline. A
+// write interrupted by the context returns context.Canceled or
+// context.DeadlineExceeded; a write that times out with the deadline set by
+// SetWriteDeadline returns a net.Error. After the context interrupts a write,
+// the connection state is corrupt and all future writes return an error.
+func (c *Conn) WriteMessageContext(ctx context.Context, messageType int, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline := c.getWriteDeadline()
+	ctxDeadline := false
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+		ctxDeadline = true
+	}
+	err := c.writeMessage(ctx, messageType, data, &deadline)
+	return contextWriteError(ctx, ctxDeadline, err)
+}
+
+// contextWriteError returns the context error in place of the write error
+// err when the context interrupted the write. The ctxDeadline argument
+// specifies whether the write deadline was taken from the context.
+func contextWriteError(ctx context.Context, ctxDeadline bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() && ctxDeadline {
+		// The network deadline can expire before the context timer.
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// WriteControlContext is like WriteControl, but takes the deadline from the
+// context and returns the context error if the context is done before the
+// message is written. If the context does not have a deadline, then the
+// write does not time out.
+func (c *Conn) WriteControlContext(ctx context.Context, messageType int, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	err := c.writeControl(ctx, messageType, data, deadline)
+	return contextWriteError(ctx, ok, err)
+}
+
+// watchWriteContext interrupts the network write in progress when ctx is
+// done. The caller must hold c.mu so that the interrupt does not affect
+// frames written by other goroutines. The returned function stops the watch
+// and must be called before c.mu is released.
+func (c *Conn) watchWriteContext(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.conn.SetWriteDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// CloseHandshake performs the closing handshake. CloseHandshake sends a close
+// message with the code and text, discards received data messages until the
+// peer's close message arrives or ctx is done, and closes the connection.
+// CloseHandshake returns the close code received from the peer, or
+// CloseNoStatusReceived if the peer's close message is empty.
+//
+// If ctx is done first, then CloseHandshake returns the context error. If
+// the connection fails, then CloseHandshake returns the read error.
+func (c *Conn) CloseHandshake(ctx context.Context, code int, text string) (int, error) {
+	defer c.Close()
+	err := c.WriteControlContext(ctx, CloseMessage, FormatCloseMessage(code, text))
+	if err != nil && err != ErrCloseSent {
+		return 0, err
+	}
+	for {
+		_, _, err := c.ReadMessageContext(ctx)
+		if err == nil {
+			continue
+		}
+		if e, ok := err.(*CloseError); ok && e.Code != CloseAbnormalClosure {
+			return e.Code, nil
+		}
+		return 0, err
+	}
+}
+
+// Ping sends a ping message to the peer and waits for the matching pong
+// message. Ping returns the round trip time or the context error if the
+// context is done before the pong is received. The ping application data
+// identifies the ping; pongs with other application data are ignored.
+//
+// The pong is received by the goroutine reading the connection. The
+// application must read the connection concurrently with the call to Ping
+// as described in the section on Control Messages above. Ping returns after
+// the pong handler is called for the matching pong.
+func (c *Conn) Ping(ctx context.Context) (time.Duration, error) {
+	var data [8]byte
+	done := make(chan struct{})
+	c.pingMu.Lock()
+	c.pingSeq++
+	binary.BigEndian.PutUint64(data[:], c.pingSeq)
+	if c.pings == nil {
+		c.pings = make(map[string]chan struct{})
+	}
+	c.pings[string(data[:])] = done
+	c.pingMu.Unlock()
+
+	defer func() {
+		c.pingMu.Lock()
+		delete(c.pings, string(data[:]))
+		c.pingMu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := c.WriteControlContext(ctx, PingMessage, data[:]); err != nil {
+		return 0, err
+	}
+	select {
+	case <-done:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// pongReceived notifies a pending call to Ping of the pong message with the
+// application data payload.
+func (c *Conn) pongReceived(payload []byte) {
+	c.pingMu.Lock()
+	if done, ok := c.pings[string(payload)]; ok {
+		close(done)
+		delete(c.pings, string(payload))
+	}
+	c.pingMu.Unlock()
+}
@@ -0,0 +1,443 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.7
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flushingNetConn struct {
+	fakeNetConn
+	flushed int
+}
+
+func (c *flushingNetConn) Flush() error {
+	c.flushed++
+	return nil
+}
+
+func TestFlush(t *testing.T) {
+	var buf bytes.Buffer
+	nc := &flushingNetConn{fakeNetConn: fakeNetConn{Writer: &buf}}
+	c := newConn(nc, true, 1024, 1024)
+	c.WriteMessage(TextMessage, []byte("hello"))
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if nc.flushed != 1 {
+		t.Fatalf("network connection flushed %d times, want 1", nc.flushed)
+	}
+
+	// Hold the write lock to simulate a write in progress.
+	<-c.mu
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.FlushContext(ctx); err != context.Canceled {
+		t.Fatalf("FlushContext returned %v, want %v", err, context.Canceled)
+	}
+	c.mu <- true
+	if err := c.FlushContext(context.Background()); err != nil {
+		t.Fatalf("FlushContext: %v", err)
+	}
+}
+
+func TestFlushConcurrentSetWriteDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	nc := &flushingNetConn{fakeNetConn: fakeNetConn{Writer: &buf}}
+	c := newConn(nc, true, 1024, 1024)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.SetWriteDeadline(time.Now().Add(time.Hour))
+			c.WriteMessage(TextMessage, []byte("hello"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := c.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+	<-done
+}
+
+type contextKey struct{}
+
+func TestUpgradeWithContext(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(context.Background(), contextKey{}, "value")
+		ws, err := cstUpgrader.UpgradeWithContext(ctx, w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- ws
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	c := <-conns
+	ctx := c.Context()
+	if v := ctx.Value(contextKey{}); v != "value" {
+		t.Errorf("context value %v, want value", v)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("context done before close: %v", ctx.Err())
+	}
+	c.Close()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("context error after close %v, want %v", ctx.Err(), context.Canceled)
+	}
+
+	if ctx := ws.Context(); ctx != context.Background() {
+		t.Errorf("client connection Context returned %v, want background context", ctx)
+	}
+}
+
+func TestReadMessageContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			mt, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(mt, p)
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws.WriteMessage(TextMessage, []byte("hello"))
+	if _, p, err := ws.ReadMessageContext(ctx); err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessageContext returned %q, %v, want hello", p, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, _, err := ws.NextReaderContext(ctx); err != context.Canceled {
+		t.Fatalf("NextReaderContext returned %v, want %v", err, context.Canceled)
+	}
+	if _, _, err := ws.NextReader(); err != context.Canceled {
+		t.Fatalf("NextReader after cancel returned %v, want %v", err, context.Canceled)
+	}
+}
+
+// blockingWriteConn is a network connection where writes block until the
+// write deadline or until gate is closed.
+type blockingWriteConn struct {
+	fakeNetConn
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{}
+	gate     chan struct{}
+}
+
+func (c *blockingWriteConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *blockingWriteConn) Write(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		d := c.deadline
+		c.mu.Unlock()
+		if !d.IsZero() && !time.Now().Before(d) {
+			return 0, errWriteTimeout
+		}
+		select {
+		case <-c.gate:
+			return len(p), nil
+		case <-c.changed:
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// waitWriteLock waits for another goroutine to acquire the write lock.
+func waitWriteLock(c *Conn) {
+	for len(c.mu) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteMessageContext(t *testing.T) {
+	nc := &blockingWriteConn{changed: make(chan struct{}, 1)}
+	c := newConn(nc, true, 1024, 1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := c.WriteMessageContext(ctx, TextMessage, []byte("hello")); err != context.Canceled {
+		t.Fatalf("WriteMessageContext returned %v, want %v", err, context.Canceled)
+	}
+
+	c = newConn(&blockingWriteConn{changed: make(chan struct{}, 1)}, true, 1024, 1024)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WriteMessageContext(ctx, TextMessage, []byte("hello")); err != context.DeadlineExceeded {
+		t.Fatalf("WriteMessageContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	c = newConn(&blockingWriteConn{changed: make(chan struct{}, 1)}, true, 1024, 1024)
+	c.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	err := c.WriteMessageContext(context.Background(), TextMessage, []byte("hello"))
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("WriteMessageContext returned %v, want timeout", err)
+	}
+}
+
+func TestWriteMessageContextConcurrentControl(t *testing.T) {
+	nc := &blockingWriteConn{changed: make(chan struct{}, 1), gate: make(chan struct{})}
+	c := newConn(nc, true, 1024, 1024)
+	errs := make(chan error, 1)
+	go func() { errs <- c.WriteControl(PingMessage, nil, time.Now().Add(time.Minute)) }()
+	waitWriteLock(c)
+
+	// The context expires while the ping holds the write lock.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WriteMessageContext(ctx, TextMessage, []byte("hello")); err != context.DeadlineExceeded {
+		t.Fatalf("WriteMessageContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(nc.gate)
+	if err := <-errs; err != nil {
+		t.Fatalf("WriteControl returned %v, want nil", err)
+	}
+}
+
+func TestWriteControlContext(t *testing.T) {
+	var buf bytes.Buffer
+	c := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	if err := c.WriteControlContext(context.Background(), PingMessage, []byte("ping")); err != nil {
+		t.Fatalf("WriteControlContext: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("ping not written")
+	}
+
+	c = newConn(&blockingWriteConn{changed: make(chan struct{}, 1)}, true, 1024, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WriteControlContext(ctx, PingMessage, nil); err != context.DeadlineExceeded {
+		t.Fatalf("WriteControlContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Hold the write lock to simulate a write in progress.
+	c = newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	<-c.mu
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := c.WriteControlContext(ctx, PingMessage, nil); err != context.Canceled {
+		t.Fatalf("WriteControlContext returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWriteControlContextConcurrentMessage(t *testing.T) {
+	nc := &blockingWriteConn{changed: make(chan struct{}, 1), gate: make(chan struct{})}
+	c := newConn(nc, true, 1024, 1024)
+	errs := make(chan error, 1)
+	go func() { errs <- c.WriteMessage(TextMessage, []byte("hello")) }()
+	waitWriteLock(c)
+
+	// The context expires while the message holds the write lock.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WriteControlContext(ctx, PingMessage, nil); err != context.DeadlineExceeded {
+		t.Fatalf("WriteControlContext returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(nc.gate)
+	if err := <-errs; err != nil {
+		t.Fatalf("WriteMessage returned %v, want nil", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("again")); err != nil {
+		t.Fatalf("WriteMessage after interrupted control write returned %v, want nil", err)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.SetCloseHandler(func(code int, text string) error {
+			// Send a message before replying with a different code.
+			ws.WriteMessage(TextMessage, []byte("pending"))
+			return ws.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	code, err := ws.CloseHandshake(ctx, CloseNormalClosure, "done")
+	if err != nil || code != CloseGoingAway {
+		t.Fatalf("CloseHandshake returned %d, %v, want %d, nil", code, err, CloseGoingAway)
+	}
+
+	// The context ends the wait for a peer that does not reply.
+	s2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		time.Sleep(time.Second)
+	}))
+	defer s2.Close()
+	ws, _, err = cstDialer.Dial(makeWsProto(s2.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ws.CloseHandshake(ctx, CloseNormalClosure, ""); err != context.DeadlineExceeded {
+		t.Fatalf("CloseHandshake returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPing(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if r.URL.Query().Get("read") == "" {
+			time.Sleep(time.Second)
+			return
+		}
+		// Send an unsolicited pong before answering pings.
+		ws.WriteControl(PongMessage, []byte("unsolicited"), time.Now().Add(time.Second))
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL)+"?read=1", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	pongs := make(chan string, 3)
+	ws.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if rtt, err := ws.Ping(ctx); err != nil || rtt <= 0 {
+			t.Fatalf("Ping returned %v, %v", rtt, err)
+		}
+	}
+	if n := len(pongs); n != 3 {
+		t.Errorf("pong handler called %d times, want 3", n)
+	}
+
+	// The context ends the wait for a peer that does not reply.
+	ws2, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws2.Close()
+	go ws2.ReadMessage()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ws2.Ping(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Ping returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPingConcurrentMessage(t *testing.T) {
+	const size = 64 << 20
+	errs := make(chan error, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		go func() { errs <- ws.WriteMessage(BinaryMessage, make([]byte, size)) }()
+		waitWriteLock(ws)
+
+		// The ping times out while the message holds the write lock.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = ws.Ping(ctx)
+		errs <- err
+		ws.ReadMessage()
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Fatalf("Ping returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	_, p, err := ws.ReadMessage()
+	if err != nil || len(p) != size {
+		t.Fatalf("ReadMessage returned %d bytes, %v, want %d bytes", len(p), err, size)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("WriteMessage returned %v, want nil", err)
+	}
+}
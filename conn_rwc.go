@@ -0,0 +1,71 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// rwcConn adapts an io.ReadWriteCloser to the net.Conn interface. Addresses
+// and deadlines are taken from netConn, if set.
+type rwcConn struct {
+	rwc     io.ReadWriteCloser
+	netConn net.Conn
+}
+
+func (c *rwcConn) Read(p []byte) (int, error)  { return c.rwc.Read(p) }
+func (c *rwcConn) Write(p []byte) (int, error) { return c.rwc.Write(p) }
+
+func (c *rwcConn) Close() error {
+	if c.rwc == nil {
+		return nil
+	}
+	return c.rwc.Close()
+}
+
+type rwcAddr struct{}
+
+func (rwcAddr) Network() string { return "unknown" }
+func (rwcAddr) String() string  { return "unknown" }
+
+func (c *rwcConn) LocalAddr() net.Addr {
+	if c.netConn == nil {
+		return rwcAddr{}
+	}
+	return c.netConn.LocalAddr()
+}
+
+func (c *rwcConn) RemoteAddr() net.Addr {
+	if c.netConn == nil {
+		return rwcAddr{}
+	}
+	return c.netConn.RemoteAddr()
+}
+
+var errNoDeadline = errors.New("websocket: deadline not supported by connection")
+
+func (c *rwcConn) SetDeadline(t time.Time) error {
+	if c.netConn == nil {
+		return errNoDeadline
+	}
+	return c.netConn.SetDeadline(t)
+}
+
+func (c *rwcConn) SetReadDeadline(t time.Time) error {
+	if c.netConn == nil {
+		return errNoDeadline
+	}
+	return c.netConn.SetReadDeadline(t)
+}
+
+func (c *rwcConn) SetWriteDeadline(t time.Time) error {
+	if c.netConn == nil {
+		return errNoDeadline
+	}
+	return c.netConn.SetWriteDeadline(t)
+}
@@ -286,6 +286,54 @@ func TestWriteAfterMessageWriterClose(t *testing.T) {
 	}
 }
 
+func TestReservedBits(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var b1, b2 bytes.Buffer
+		wc := newConn(&fakeNetConn{Reader: nil, Writer: &b1}, true, 1024, 1024)
+		rc := newConn(&fakeNetConn{Reader: &b1, Writer: &b2}, false, 1024, 1024)
+		if compress {
+			wc.newCompressionWriter = compressNoContextTakeover
+			rc.newDecompressionReader = decompressNoContextTakeover
+		}
+
+		if _, err := wc.NextWriterReservedBits(TextMessage, RSV2); err != errReservedBits {
+			t.Fatalf("NextWriterReservedBits without enable returned %v, want %v", err, errReservedBits)
+		}
+		if err := wc.EnableReservedBits(rsv1Bit); err != errReservedBits {
+			t.Fatalf("EnableReservedBits(RSV1) returned %v, want %v", err, errReservedBits)
+		}
+		wc.EnableReservedBits(RSV2 | RSV3)
+		rc.EnableReservedBits(RSV2 | RSV3)
+
+		for _, bits := range []int{RSV2, 0, RSV3, RSV2 | RSV3} {
+			w, err := wc.NextWriterReservedBits(BinaryMessage, bits)
+			if err != nil {
+				t.Fatalf("NextWriterReservedBits: %v", err)
+			}
+			w.Write([]byte("hello"))
+			w.Close()
+
+			_, p, err := rc.ReadMessage()
+			if err != nil {
+				t.Fatalf("compress=%v, bits=%x: ReadMessage: %v", compress, bits, err)
+			}
+			if string(p) != "hello" {
+				t.Errorf("ReadMessage returned %q, want hello", p)
+			}
+			if rc.ReservedBits() != bits {
+				t.Errorf("compress=%v: ReservedBits() = %x, want %x", compress, rc.ReservedBits(), bits)
+			}
+		}
+
+		rc.EnableReservedBits(0)
+		w, _ := wc.NextWriterReservedBits(BinaryMessage, RSV3)
+		w.Close()
+		if _, _, err := rc.ReadMessage(); err == nil {
+			t.Errorf("ReadMessage with unexpected reserved bits returned nil error")
+		}
+	}
+}
+
 func TestReadLimit(t *testing.T) {
 
 	const readLimit = 512
@@ -330,6 +378,13 @@ func TestAddrs(t *testing.T) {
 	}
 }
 
+func TestTLSConnectionStateNoTLS(t *testing.T) {
+	c := newConn(fakeNetConn{}, true, 1024, 1024)
+	if _, ok := c.TLSConnectionState(); ok {
+		t.Errorf("TLSConnectionState() returned ok for non-TLS connection")
+	}
+}
+
 func TestUnderlyingConn(t *testing.T) {
 	var b1, b2 bytes.Buffer
 	fc := fakeNetConn{Reader: &b1, Writer: &b2}
@@ -416,30 +471,33 @@ type blockingWriter struct {
 func (w blockingWriter) Write(p []byte) (int, error) {
 	// Allow main to continue
 	close(w.c1)
-	// Wait for panic in main
+	// Wait for concurrent write in main
 	<-w.c2
 	return len(p), nil
 }
 
-func TestConcurrentWritePanic(t *testing.T) {
+func TestConcurrentWriteError(t *testing.T) {
 	w := blockingWriter{make(chan struct{}), make(chan struct{})}
 	c := newConn(fakeNetConn{Reader: nil, Writer: w}, false, 1024, 1024)
+	done := make(chan error)
 	go func() {
-		c.WriteMessage(TextMessage, []byte{})
+		done <- c.WriteMessage(TextMessage, []byte{})
 	}()
 
 	// wait for goroutine to block in write.
 	<-w.c1
 
-	defer func() {
-		close(w.c2)
-		if v := recover(); v != nil {
-			return
-		}
-	}()
-
-	c.WriteMessage(TextMessage, []byte{})
-	t.Fatal("should not get here")
+	err := c.WriteMessage(TextMessage, []byte{})
+	close(w.c2)
+	if err != ErrConcurrentWrite {
+		t.Fatalf("concurrent WriteMessage returned %v, want %v", err, ErrConcurrentWrite)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("first WriteMessage returned %v", err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte{}); err != ErrConcurrentWrite {
+		t.Fatalf("WriteMessage after concurrent write returned %v, want %v", err, ErrConcurrentWrite)
+	}
 }
 
 type failingReader struct{}
@@ -465,7 +523,7 @@ func TestFailedConnectionReadPanic(t *testing.T) {
 
 func TestBufioReuse(t *testing.T) {
 	brw := bufio.NewReadWriter(bufio.NewReader(nil), bufio.NewWriter(nil))
-	c := newConnBRW(nil, false, 0, 0, brw)
+	c := newConnBRW(nil, false, 0, 0, nil, brw)
 
 	if c.br != brw.Reader {
 		t.Error("connection did not reuse bufio.Reader")
@@ -480,7 +538,7 @@ func TestBufioReuse(t *testing.T) {
 	}
 
 	brw = bufio.NewReadWriter(bufio.NewReaderSize(nil, 0), bufio.NewWriterSize(nil, 0))
-	c = newConnBRW(nil, false, 0, 0, brw)
+	c = newConnBRW(nil, false, 0, 0, nil, brw)
 
 	if c.br == brw.Reader {
 		t.Error("connection used bufio.Reader with small size")
@@ -492,5 +550,226 @@ func TestBufioReuse(t *testing.T) {
 	if &c.writeBuf[0] != &wh.p[0] {
 		t.Error("connection used bufio.Writer with small size")
 	}
+	// Buffers are reused when they hold the requested sizes.
+	brw = bufio.NewReadWriter(bufio.NewReaderSize(nil, 4096), bufio.NewWriterSize(nil, 4096))
+	c = newConnBRW(nil, false, 2048, 2048, nil, brw)
+	if c.br != brw.Reader {
+		t.Error("connection did not reuse bufio.Reader with compatible size")
+	}
+	brw.Writer.Reset(&wh)
+	brw.WriteByte(0)
+	brw.Flush()
+	if &c.writeBuf[0] != &wh.p[0] {
+		t.Error("connection did not reuse bufio.Writer with compatible size")
+	}
+
+	c = newConnBRW(nil, false, 8192, 8192, nil, brw)
+	if c.br == brw.Reader || len(c.writeBuf) != 8192+maxFrameHeaderSize {
+		t.Error("connection reused buffers smaller than the requested sizes")
+	}
+}
+
+// simpleBufferPool is a BufferPool that holds one value. Unlike sync.Pool,
+// it does not drop values, so tests can check what was put in the pool.
+type simpleBufferPool struct {
+	v interface{}
+}
+
+func (p *simpleBufferPool) Get() interface{} {
+	v := p.v
+	p.v = nil
+	return v
+}
+
+func (p *simpleBufferPool) Put(v interface{}) {
+	p.v = v
+}
+
+func TestWriteBufferPool(t *testing.T) {
+	var b bytes.Buffer
+	var pool simpleBufferPool
+	wc := newConnBRW(fakeNetConn{Writer: &b}, false, 1024, 1024, &pool, nil)
+	rc := newConn(fakeNetConn{Reader: &b}, true, 1024, 1024)
+
+	if wc.writeBuf != nil {
+		t.Fatal("connection with a pool holds a write buffer before writing")
+	}
+	large := bytes.Repeat([]byte("0123456789"), 500)
+	for _, data := range [][]byte{[]byte("hello"), large} {
+		if err := wc.WriteMessage(TextMessage, data); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+		if wc.writeBuf != nil {
+			t.Fatal("write buffer not returned to the pool")
+		}
+		_, p, err := rc.ReadMessage()
+		if err != nil || !bytes.Equal(p, data) {
+			t.Fatalf("ReadMessage returned %d bytes, %v, want %d bytes", len(p), err, len(data))
+		}
+	}
+	if wpd, ok := pool.Get().(writePoolData); !ok || len(wpd.buf) != 1024+maxFrameHeaderSize {
+		t.Errorf("pool holds %v, want a write buffer", wpd)
+	}
+}
+
+func TestReadMessageInto(t *testing.T) {
+	var b bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &b}, false, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &b}, true, 1024, 1024)
+
+	wc.WriteMessage(TextMessage, []byte("hello"))
+	wc.WriteMessage(BinaryMessage, []byte("12345678"))
+	wc.WriteMessage(TextMessage, []byte("1234567890"))
+	wc.WriteMessage(TextMessage, []byte("next"))
+
+	buf := make([]byte, 8)
+	for _, tt := range []struct {
+		messageType int
+		data        string
+		err         error
+	}{
+		{TextMessage, "hello", nil},
+		{BinaryMessage, "12345678", nil},
+		{TextMessage, "12345678", io.ErrShortBuffer},
+		{TextMessage, "next", nil},
+	} {
+		mt, n, err := rc.ReadMessageInto(&buf)
+		if mt != tt.messageType || string(buf[:n]) != tt.data || err != tt.err {
+			t.Errorf("ReadMessageInto returned %d, %q, %v, want %d, %q, %v", mt, buf[:n], err, tt.messageType, tt.data, tt.err)
+		}
+	}
+}
+
+func TestReadMessageIntoPool(t *testing.T) {
+	var b bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &b}, false, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &b}, true, 1024, 1024)
+	var pool simpleBufferPool
+	rc.readPool = &pool
+
+	large := bytes.Repeat([]byte("0123456789"), 200)
+	wc.WriteMessage(TextMessage, []byte("hello"))
+	wc.WriteMessage(BinaryMessage, large)
+	wc.WriteMessage(TextMessage, []byte("next"))
+
+	buf := make([]byte, 8)
+	for _, data := range [][]byte{[]byte("hello"), large, []byte("next")} {
+		_, n, err := rc.ReadMessageInto(&buf)
+		if err != nil || !bytes.Equal(buf[:n], data) {
+			t.Fatalf("ReadMessageInto returned %d bytes, %v, want %d bytes", n, err, len(data))
+		}
+	}
+	if len(buf) < len(large) {
+		t.Errorf("buffer length is %d, want at least %d", len(buf), len(large))
+	}
+	if p, _ := pool.Get().([]byte); p == nil {
+		t.Error("replaced buffers were not put in the pool")
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	var b1, b2 bytes.Buffer
+	wc := newConn(fakeNetConn{Reader: &b2, Writer: &b1}, false, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &b1, Writer: &b2}, true, 1024, 1024)
+
+	if err := wc.CloseWrite(CloseGoingAway, "bye"); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	if err := wc.WriteMessage(TextMessage, []byte("hello")); err != ErrCloseSent {
+		t.Fatalf("WriteMessage after CloseWrite returned %v, want %v", err, ErrCloseSent)
+	}
+
+	// The peer sends a final message before replying to the close message.
+	rc.WriteMessage(TextMessage, []byte("last"))
+	if _, _, err := rc.NextReader(); !IsCloseError(err, CloseGoingAway) {
+		t.Fatalf("peer NextReader returned %v, want going away close error", err)
+	}
 
+	if _, p, err := wc.ReadMessage(); err != nil || string(p) != "last" {
+		t.Fatalf("ReadMessage after CloseWrite returned %q, %v, want last", p, err)
+	}
+	if _, _, err := wc.ReadMessage(); !IsCloseError(err, CloseGoingAway) {
+		t.Fatalf("ReadMessage returned %v, want echoed going away close error", err)
+	}
+}
+
+func TestCloseCode(t *testing.T) {
+	const readLimit = 16
+
+	for _, tt := range []struct {
+		name  string
+		write func(wc *Conn)
+		code  int
+		text  string
+	}{
+		{"peer", func(wc *Conn) {
+			wc.WriteMessage(CloseMessage, FormatCloseMessage(CloseGoingAway, "bye"))
+		}, CloseGoingAway, "bye"},
+		{"limit", func(wc *Conn) {
+			wc.WriteMessage(BinaryMessage, make([]byte, readLimit+1))
+		}, CloseMessageTooBig, ""},
+		{"protocol", func(wc *Conn) {
+			wc.isServer = true // send unmasked frame to server
+			wc.WriteMessage(TextMessage, []byte("hello"))
+		}, CloseProtocolError, "incorrect mask flag"},
+		{"eof", func(wc *Conn) {}, CloseAbnormalClosure, "unexpected EOF"},
+	} {
+		var b1, b2 bytes.Buffer
+		wc := newConn(fakeNetConn{Reader: nil, Writer: &b1}, false, 1024, 1024)
+		rc := newConn(fakeNetConn{Reader: &b1, Writer: &b2}, true, 1024, 1024)
+		rc.SetReadLimit(readLimit)
+
+		if code := rc.CloseCode(); code != 0 {
+			t.Errorf("%s: CloseCode before read returned %d, want 0", tt.name, code)
+		}
+		tt.write(wc)
+		if _, _, err := rc.ReadMessage(); err == nil {
+			t.Errorf("%s: ReadMessage returned nil error", tt.name)
+		}
+		if code, text := rc.CloseCode(), rc.CloseReason(); code != tt.code || text != tt.text {
+			t.Errorf("%s: CloseCode, CloseReason returned %d, %q, want %d, %q", tt.name, code, text, tt.code, tt.text)
+		}
+	}
+}
+
+func TestCloseWriteConcurrentSetWriteDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	c := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.SetWriteDeadline(time.Now().Add(time.Hour))
+		}
+	}()
+	if err := c.CloseWrite(CloseNormalClosure, ""); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	<-done
+}
+
+func TestWriteMessageDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	c := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	deadline := time.Now().Add(time.Hour)
+	c.SetWriteDeadline(deadline)
+	if err := c.WriteMessageDeadline(TextMessage, []byte("hello"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("WriteMessageDeadline: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("message not written")
+	}
+	if !c.writeDeadline.Equal(deadline) {
+		t.Errorf("write deadline = %v, want %v", c.writeDeadline, deadline)
+	}
+
+	c = newConn(&blockingWriteConn{changed: make(chan struct{}, 1)}, true, 1024, 1024)
+	err := c.WriteMessageDeadline(TextMessage, []byte("hello"), time.Now().Add(10*time.Millisecond))
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("WriteMessageDeadline returned %v, want timeout", err)
+	}
+	if !c.writeDeadline.IsZero() {
+		t.Errorf("write deadline = %v, want zero", c.writeDeadline)
+	}
 }
@@ -0,0 +1,149 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConnLimiter limits the number of simultaneous connections for each
+// client. Clients are identified by a key computed from the handshake
+// request. See the Upgrader ConnLimiter field.
+type ConnLimiter struct {
+	// Key specifies an optional function to compute the key for a request.
+	// If Key is nil, then the client IP address returned by
+	// Upgrader.ClientIP is used, or the remote address of the request if the
+	// client IP address is not known.
+	Key func(r *http.Request) string
+
+	// MaxConns specifies the maximum number of open connections for a key.
+	// Connections are not limited if MaxConns is not positive.
+	MaxConns int
+
+	// MaxViolations and Cooldown specify an optional penalty for clients
+	// that repeatedly exceed MaxConns. After MaxViolations consecutive
+	// rejected handshakes, all handshakes for the key are rejected for the
+	// Cooldown duration. The penalty is not applied if either field is not
+	// positive.
+	MaxViolations int
+	Cooldown      time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*connLimitKey
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type connLimitKey struct {
+	conns        int
+	violations   int
+	blockedUntil time.Time
+}
+
+func (l *ConnLimiter) timeNow() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// acquire reserves a connection for key. If the connection is not allowed,
+// then acquire returns false and the duration of the remaining cooldown.
+func (l *ConnLimiter) acquire(key string) (bool, time.Duration) {
+	if l.MaxConns <= 0 {
+		return true, 0
+	}
+	now := l.timeNow()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Keys without connections are kept only while blocked. Remove keys
+	// with an expired cooldown.
+	if l.Cooldown > 0 && now.Sub(l.lastSweep) > l.Cooldown {
+		for k, e := range l.keys {
+			if e.conns == 0 && !now.Before(e.blockedUntil) {
+				delete(l.keys, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e := l.keys[key]
+	if e == nil {
+		if l.keys == nil {
+			l.keys = make(map[string]*connLimitKey)
+		}
+		e = &connLimitKey{}
+		l.keys[key] = e
+	}
+	if now.Before(e.blockedUntil) {
+		return false, e.blockedUntil.Sub(now)
+	}
+	if e.conns >= l.MaxConns {
+		e.violations++
+		if l.MaxViolations > 0 && l.Cooldown > 0 && e.violations >= l.MaxViolations {
+			e.violations = 0
+			e.blockedUntil = now.Add(l.Cooldown)
+			return false, l.Cooldown
+		}
+		return false, 0
+	}
+	e.violations = 0
+	e.conns++
+	return true, 0
+}
+
+// release releases a connection acquired for key.
+func (l *ConnLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.keys[key]
+	if e == nil {
+		return
+	}
+	e.conns--
+	if e.conns <= 0 && !l.timeNow().Before(e.blockedUntil) {
+		delete(l.keys, key)
+	}
+}
+
+// Conns returns the number of open connections for key.
+func (l *ConnLimiter) Conns(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e := l.keys[key]; e != nil {
+		return e.conns
+	}
+	return 0
+}
+
+func releaseNothing() {}
+
+// acquireKeyConn reserves a connection in the ConnLimiter for the request.
+// If the connection is not allowed, then acquireKeyConn sets the
+// Retry-After header for a cooldown and returns false.
+func (u *Upgrader) acquireKeyConn(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	l := u.ConnLimiter
+	if l == nil || l.MaxConns <= 0 {
+		return releaseNothing, true
+	}
+	var key string
+	if l.Key != nil {
+		key = l.Key(r)
+	} else {
+		key = clientKey(r, u.ClientIP(r))
+	}
+	ok, d := l.acquire(key)
+	if !ok {
+		if d > 0 {
+			w.Header().Set("Retry-After", retryAfter(d))
+		}
+		return nil, false
+	}
+	return func() { l.release(key) }, true
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	l := ConnLimiter{MaxConns: 2, MaxViolations: 2, Cooldown: time.Minute, now: func() time.Time { return now }}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.acquire("a"); !ok {
+			t.Fatalf("acquire %d rejected", i)
+		}
+	}
+	if ok, d := l.acquire("a"); ok || d != 0 {
+		t.Fatalf("acquire over limit returned %v, %v, want false, 0", ok, d)
+	}
+	if ok, _ := l.acquire("b"); !ok {
+		t.Fatal("acquire for other key rejected")
+	}
+
+	// The second consecutive violation starts the cooldown.
+	if ok, d := l.acquire("a"); ok || d != time.Minute {
+		t.Fatalf("acquire returned %v, %v, want false, %v", ok, d, time.Minute)
+	}
+	l.release("a")
+	now = now.Add(10 * time.Second)
+	if ok, d := l.acquire("a"); ok || d != 50*time.Second {
+		t.Fatalf("acquire in cooldown returned %v, %v, want false, %v", ok, d, 50*time.Second)
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _ := l.acquire("a"); !ok {
+		t.Fatal("acquire after cooldown rejected")
+	}
+	if n := l.Conns("a"); n != 2 {
+		t.Errorf("Conns(a)=%d, want 2", n)
+	}
+
+	l.release("a")
+	l.release("a")
+	l.release("b")
+	if len(l.keys) != 0 {
+		t.Errorf("%d keys retained after release, want 0", len(l.keys))
+	}
+}
+
+func TestUpgraderConnLimiterUnknownAddress(t *testing.T) {
+	u := Upgrader{ConnLimiter: &ConnLimiter{MaxConns: 1}}
+	w := httptest.NewRecorder()
+	for _, addr := range []string{"client-1", "client-2"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = addr
+		if _, ok := u.acquireKeyConn(w, r); !ok {
+			t.Errorf("connection from %s with unknown address rejected", addr)
+		}
+	}
+	if n := u.ConnLimiter.Conns("client-1"); n != 1 {
+		t.Errorf("Conns(client-1)=%d, want 1", n)
+	}
+}
+
+func TestUpgraderConnLimiter(t *testing.T) {
+	u := Upgrader{ConnLimiter: &ConnLimiter{
+		Key:      func(r *http.Request) string { return r.URL.Query().Get("user") },
+		MaxConns: 1,
+	}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.ReadMessage()
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL)+"?user=a", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL)+"?user=a", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Dial over limit returned %v, %v, want status %d", resp, err, http.StatusTooManyRequests)
+	}
+	ws2, _, err := cstDialer.Dial(makeWsProto(s.URL)+"?user=b", nil)
+	if err != nil {
+		t.Fatalf("Dial for other user: %v", err)
+	}
+	ws2.Close()
+
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for u.ConnLimiter.Conns("a") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not released after close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var errBadCredentialProtocol = errors.New("websocket: credential is not a valid subprotocol token")
+
+// CredentialProvider provides the credential sent with the client opening
+// handshake. See Dialer.Credentials.
+type CredentialProvider interface {
+	// Token returns the credential for a dial attempt. Token is called with
+	// the dial context on every attempt, including redirects, retries and
+	// reconnects, so that an expired credential can be refreshed.
+	Token(ctx context.Context) (string, error)
+}
+
+// The CredentialProviderFunc type is an adapter to allow the use of ordinary
+// functions as credential providers.
+type CredentialProviderFunc func(ctx context.Context) (string, error)
+
+// Token returns f(ctx).
+func (f CredentialProviderFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// credential returns the request header or the subprotocol that carries the
+// credential from the Dialer's Credentials.
+func (d *Dialer) credential(ctx context.Context) (header http.Header, protocol string, err error) {
+	token, err := d.Credentials.Token(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if d.CredentialSubprotocolPrefix != "" {
+		protocol = d.CredentialSubprotocolPrefix + token
+		if !isToken(protocol) {
+			return nil, "", errBadCredentialProtocol
+		}
+		return nil, protocol, nil
+	}
+	return http.Header{"Authorization": {"Bearer " + token}}, "", nil
+}
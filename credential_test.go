@@ -0,0 +1,80 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestDialCredentials(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer s.Close()
+
+	n := 0
+	d := cstDialer
+	d.Credentials = CredentialProviderFunc(func(ctx context.Context) (string, error) {
+		n++
+		return "token" + strconv.Itoa(n), nil
+	})
+
+	for i := 1; i <= 2; i++ {
+		ws, _, err := d.Dial(makeWsProto(s.URL), http.Header{"Authorization": {"stale"}})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		ws.Close()
+		r := <-requests
+		if got, want := r.Header.Get("Authorization"), "Bearer token"+strconv.Itoa(i); got != want {
+			t.Errorf("Authorization=%q, want %q", got, want)
+		}
+	}
+
+	d.CredentialSubprotocolPrefix = "access_token."
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	r := <-requests
+	protocols := Subprotocols(r)
+	if got := protocols[len(protocols)-1]; got != "access_token.token3" {
+		t.Errorf("credential subprotocol=%q, want access_token.token3", got)
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Errorf("Authorization header sent with credential subprotocol")
+	}
+}
+
+func TestDialCredentialsError(t *testing.T) {
+	errToken := errors.New("token error")
+	d := cstDialer
+	d.Credentials = CredentialProviderFunc(func(ctx context.Context) (string, error) {
+		return "", errToken
+	})
+	if _, _, err := d.Dial("ws://example.com/", nil); err != errToken {
+		t.Errorf("Dial returned %v, want %v", err, errToken)
+	}
+
+	d.Credentials = CredentialProviderFunc(func(ctx context.Context) (string, error) {
+		return "a/b=", nil
+	})
+	d.CredentialSubprotocolPrefix = "token."
+	if _, _, err := d.Dial("ws://example.com/", nil); err != errBadCredentialProtocol {
+		t.Errorf("Dial returned %v, want %v", err, errBadCredentialProtocol)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDedupWindow     = time.Minute
+	defaultDedupMaxEntries = 10000
+)
+
+var (
+	errBadMessageID     = errors.New("websocket: message ID contains newline")
+	errMissingMessageID = errors.New("websocket: message does not have an ID")
+)
+
+// NewMessageID returns a random message ID for use with WriteMessageWithID.
+// NewMessageID panics if the system's secure random number generator fails.
+func NewMessageID() string {
+	var p [16]byte
+	if _, err := io.ReadFull(rand.Reader, p[:]); err != nil {
+		panic("websocket: failed to read random message ID: " + err.Error())
+	}
+	return hex.EncodeToString(p[:])
+}
+
+// WriteMessageWithID writes a data message stamped with the given ID. The ID
+// must not contain a newline. Retries of the same logical message should use
+// the same ID so that the receiver can suppress duplicates with a
+// Deduplicator.
+//
+// The ID is sent as a prefix of the message payload. The peer reads the
+// message with ReadMessageWithID.
+func (c *Conn) WriteMessageWithID(messageType int, id string, data []byte) error {
+	if strings.IndexByte(id, '\n') >= 0 {
+		return errBadMessageID
+	}
+	if !isData(messageType) {
+		return errBadWriteOpCode
+	}
+	w, err := c.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	p := make([]byte, 0, len(id)+1)
+	p = append(p, id...)
+	p = append(p, '\n')
+	if _, err := w.Write(p); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// ReadMessageWithID reads a message written with WriteMessageWithID.
+func (c *Conn) ReadMessageWithID() (messageType int, id string, p []byte, err error) {
+	messageType, p, err = c.ReadMessage()
+	if err != nil {
+		return messageType, "", nil, err
+	}
+	i := bytes.IndexByte(p, '\n')
+	if i < 0 {
+		return messageType, "", nil, errMissingMessageID
+	}
+	return messageType, string(p[:i]), p[i+1:], nil
+}
+
+// Deduplicator remembers recently received message IDs so that duplicate
+// deliveries of a message can be suppressed. A Deduplicator is safe for
+// concurrent use and can be shared by connections to deduplicate messages
+// retried across reconnects.
+type Deduplicator struct {
+	// Window specifies how long an ID is remembered. If zero, then a default
+	// of one minute is used.
+	Window time.Duration
+
+	// MaxEntries specifies the maximum number of remembered IDs. The oldest
+	// IDs are forgotten first. If zero, then a default of 10000 is used.
+	MaxEntries int
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	ring []dedupEntry // remembered IDs in arrival order, starting at head
+	head int
+	n    int // number of entries in ring
+	now  func() time.Time
+}
+
+type dedupEntry struct {
+	id string
+	t  time.Time
+}
+
+func (d *Deduplicator) window() time.Duration {
+	if d.Window <= 0 {
+		return defaultDedupWindow
+	}
+	return d.Window
+}
+
+func (d *Deduplicator) maxEntries() int {
+	if d.MaxEntries <= 0 {
+		return defaultDedupMaxEntries
+	}
+	return d.MaxEntries
+}
+
+// Seen returns true if id was seen within the window. Otherwise, Seen records
+// the id and returns false.
+func (d *Deduplicator) Seen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if d.now != nil {
+		now = d.now()
+	}
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+	// Forget expired entries.
+	expire := now.Add(-d.window())
+	for d.n > 0 && !d.ring[d.head].t.After(expire) {
+		d.forgetOldest()
+	}
+
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+
+	// Forget the oldest entries to make room for the new entry.
+	max := d.maxEntries()
+	for d.n >= max {
+		d.forgetOldest()
+	}
+	if d.n == len(d.ring) {
+		d.grow(max)
+	}
+	d.seen[id] = now
+	d.ring[(d.head+d.n)%len(d.ring)] = dedupEntry{id, now}
+	d.n++
+	return false
+}
+
+// forgetOldest removes the oldest entry. The caller must hold d.mu.
+func (d *Deduplicator) forgetOldest() {
+	delete(d.seen, d.ring[d.head].id)
+	d.ring[d.head] = dedupEntry{}
+	d.head = (d.head + 1) % len(d.ring)
+	d.n--
+}
+
+// grow increases the capacity of the ring up to max entries. The caller must
+// hold d.mu.
+func (d *Deduplicator) grow(max int) {
+	size := 2 * len(d.ring)
+	if size < 16 {
+		size = 16
+	}
+	if size > max {
+		size = max
+	}
+	ring := make([]dedupEntry, size)
+	for i := 0; i < d.n; i++ {
+		ring[i] = d.ring[(d.head+i)%len(d.ring)]
+	}
+	d.ring = ring
+	d.head = 0
+}
+
+// ReadMessage reads messages written with WriteMessageWithID from the
+// connection and returns the next message with an ID not seen within the
+// window.
+func (d *Deduplicator) ReadMessage(c *Conn) (messageType int, id string, p []byte, err error) {
+	for {
+		messageType, id, p, err = c.ReadMessageWithID()
+		if err != nil || !d.Seen(id) {
+			return messageType, id, p, err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeduplicator(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := Deduplicator{Window: time.Second, MaxEntries: 2, now: func() time.Time { return now }}
+
+	for _, tt := range []struct {
+		advance time.Duration
+		id      string
+		seen    bool
+	}{
+		{0, "a", false},
+		{0, "a", true},
+		{0, "b", false},
+		{0, "c", false}, // evicts a
+		{0, "a", false},
+		{0, "c", true},
+		{2 * time.Second, "c", false}, // expired
+	} {
+		now = now.Add(tt.advance)
+		if seen := d.Seen(tt.id); seen != tt.seen {
+			t.Errorf("Seen(%q) = %v, want %v", tt.id, seen, tt.seen)
+		}
+	}
+}
+
+func TestDeduplicatorWrap(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := Deduplicator{Window: time.Hour, MaxEntries: 20, now: func() time.Time { return now }}
+
+	// Add enough IDs to grow and wrap the ring.
+	for i := 0; i < 50; i++ {
+		now = now.Add(time.Second)
+		if d.Seen(strconv.Itoa(i)) {
+			t.Fatalf("Seen(%d) = true for new ID", i)
+		}
+	}
+	// The newest 20 IDs are remembered.
+	for i := 49; i >= 30; i-- {
+		if !d.Seen(strconv.Itoa(i)) {
+			t.Errorf("Seen(%d) = false, want true", i)
+		}
+	}
+	if d.Seen("29") {
+		t.Errorf("Seen(29) = true, want false for evicted ID")
+	}
+}
+
+func TestReadMessageDeduplicated(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &buf}, false, 1024, 1024)
+
+	id := NewMessageID()
+	wc.WriteMessageWithID(TextMessage, id, []byte("first"))
+	wc.WriteMessageWithID(TextMessage, id, []byte("first"))
+	wc.WriteMessageWithID(BinaryMessage, "2", []byte("second"))
+
+	if err := wc.WriteMessageWithID(TextMessage, "bad\nid", nil); err != errBadMessageID {
+		t.Errorf("WriteMessageWithID returned %v, want %v", err, errBadMessageID)
+	}
+
+	var d Deduplicator
+	for _, want := range []struct {
+		messageType int
+		id, data    string
+	}{
+		{TextMessage, id, "first"},
+		{BinaryMessage, "2", "second"},
+	} {
+		mt, id, p, err := d.ReadMessage(rc)
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if mt != want.messageType || id != want.id || string(p) != want.data {
+			t.Errorf("ReadMessage returned %d, %q, %q, want %d, %q, %q", mt, id, p, want.messageType, want.id, want.data)
+		}
+	}
+}
@@ -133,6 +133,14 @@
 // The Close and WriteControl methods can be called concurrently with all other
 // methods.
 //
+// The connection makes a best-effort attempt to detect concurrent calls to the
+// write methods. Detection covers only the writes of frames to the network
+// connection. Concurrent calls that do not overlap a frame write, such as
+// concurrent calls to the Write method of message writers, are not detected.
+// When a frame write overlaps with another frame write, the write returns
+// ErrConcurrentWrite and the connection fails all subsequent writes. Do not
+// rely on the detection for correctness.
+//
 // Origin Considerations
 //
 // Web browsers allow Javascript applications to open a WebSocket connection to
@@ -0,0 +1,116 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// EventLoop reads a connection and calls a function for each event. The
+// functions are called on the goroutine running Run, one at a time. Any
+// particular function may be nil.
+type EventLoop struct {
+	// OnMessage is called with each data message. If OnMessage returns an
+	// error, then Run returns the error.
+	OnMessage func(c *Conn, m Message) error
+
+	// OnPing is called after the pong reply to a ping message is sent.
+	OnPing func(c *Conn, appData string)
+
+	// OnPong is called with each pong message.
+	OnPong func(c *Conn, appData string)
+
+	// OnClose is called when a close message is received from the peer. The
+	// code is CloseNoStatusReceived if the close message is empty. The close
+	// message is echoed to the peer before OnClose is called.
+	OnClose func(c *Conn, code int, text string)
+
+	// OnError is called with the error that ends Run, except for close
+	// messages from the peer and cancellation of the Run context.
+	OnError func(c *Conn, err error)
+
+	// PingInterval and PongTimeout configure a keepalive on the connection.
+	// See the fields with the same names on Dialer. The keepalive is not
+	// started if the connection already has a keepalive.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// Metrics specifies an optional receiver for the time spent in
+	// OnMessage. If nil, then the Metrics of the Upgrader that created the
+	// connection is used if the Metrics implements MessageMetrics.
+	Metrics MessageMetrics
+}
+
+// Run reads the connection until the peer closes the connection, an error
+// occurs or ctx is done. When ctx is done, Run sends a close message with
+// the going away code. Run closes the connection before returning.
+//
+// Run returns nil when the peer sends a close message, the context error
+// when ctx is done, and the read error or the error from OnMessage
+// otherwise. The application may write to the connection from the event
+// functions and from other goroutines, but must not read from the
+// connection while Run is running.
+func (l *EventLoop) Run(ctx context.Context, c *Conn) error {
+	defer c.Close()
+
+	ping := c.PingHandler()
+	c.SetPingHandler(func(appData string) error {
+		err := ping(appData)
+		if err == nil && l.OnPing != nil {
+			l.OnPing(c, appData)
+		}
+		return err
+	})
+	pong := c.PongHandler()
+	c.SetPongHandler(func(appData string) error {
+		if l.OnPong != nil {
+			l.OnPong(c, appData)
+		}
+		return pong(appData)
+	})
+	closeHandler := c.CloseHandler()
+	c.SetCloseHandler(func(code int, text string) error {
+		err := closeHandler(code, text)
+		if l.OnClose != nil {
+			l.OnClose(c, code, text)
+		}
+		return err
+	})
+
+	if l.PingInterval > 0 && c.keepalive == nil {
+		c.startKeepalive(l.PingInterval, l.PongTimeout)
+	}
+
+	metrics := l.Metrics
+	if metrics == nil {
+		metrics, _ = c.metrics.(MessageMetrics)
+	}
+
+	for {
+		mt, p, err := c.ReadMessageContext(ctx)
+		if err == nil && l.OnMessage != nil {
+			start := time.Now()
+			err = l.OnMessage(c, Message{Type: mt, Data: p})
+			if metrics != nil {
+				metrics.MessageHandled(c, mt, time.Since(start), err)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			c.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(shutdownWriteWait))
+			return ctx.Err()
+		}
+		if e, ok := err.(*CloseError); ok && e.Code != CloseAbnormalClosure {
+			return nil
+		}
+		if l.OnError != nil {
+			l.OnError(c, err)
+		}
+		return err
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventLoop(t *testing.T) {
+	events := make(chan []string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var got []string
+		l := EventLoop{
+			OnMessage: func(c *Conn, m Message) error {
+				got = append(got, "message "+string(m.Data))
+				return c.WriteMessage(m.Type, m.Data)
+			},
+			OnPing:  func(c *Conn, appData string) { got = append(got, "ping "+appData) },
+			OnPong:  func(c *Conn, appData string) { got = append(got, "pong "+appData) },
+			OnClose: func(c *Conn, code int, text string) { got = append(got, "close "+text) },
+			OnError: func(c *Conn, err error) { got = append(got, "error") },
+		}
+		if err := l.Run(context.Background(), ws); err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+		events <- got
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	deadline := time.Now().Add(time.Second)
+	ws.SetReadDeadline(deadline)
+
+	ws.WriteControl(PingMessage, []byte("a"), deadline)
+	ws.WriteControl(PongMessage, []byte("b"), deadline)
+	ws.WriteMessage(TextMessage, []byte("c"))
+	if _, p, err := ws.ReadMessage(); err != nil || string(p) != "c" {
+		t.Fatalf("ReadMessage returned %q, %v, want c", p, err)
+	}
+	ws.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, "d"), deadline)
+	if _, _, err := ws.ReadMessage(); !IsCloseError(err, CloseNormalClosure) {
+		t.Errorf("ReadMessage returned %v, want normal closure", err)
+	}
+
+	want := []string{"ping a", "pong b", "message c", "close d"}
+	if got := <-events; !reflect.DeepEqual(got, want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestEventLoopContext(t *testing.T) {
+	runErr := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var l EventLoop
+		runErr <- l.Run(ctx, ws)
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	cancel()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); !IsCloseError(err, CloseGoingAway) {
+		t.Errorf("ReadMessage returned %v, want going away close error", err)
+	}
+	if err := <-runErr; err != context.Canceled {
+		t.Errorf("Run returned %v, want %v", err, context.Canceled)
+	}
+}
+
+// handledMetrics records the message types passed to MessageHandled.
+type handledMetrics struct {
+	recordingMetrics
+	handled chan int
+}
+
+func (m *handledMetrics) MessageHandled(c *Conn, messageType int, d time.Duration, err error) {
+	m.handled <- messageType
+}
+
+func TestEventLoopMetrics(t *testing.T) {
+	m := &handledMetrics{handled: make(chan int, 1)}
+	u := Upgrader{Metrics: m}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		l := EventLoop{OnMessage: func(c *Conn, m Message) error { return nil }}
+		l.Run(context.Background(), ws)
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	ws.WriteMessage(BinaryMessage, []byte("a"))
+	select {
+	case mt := <-m.handled:
+		if mt != BinaryMessage {
+			t.Errorf("MessageHandled called with type %d, want %d", mt, BinaryMessage)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("MessageHandled not called")
+	}
+}
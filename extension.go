@@ -0,0 +1,217 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var errUnexpectedExtension = errors.New("websocket: server accepted an extension not offered by the client")
+
+// Extension is a WebSocket extension offer or response as sent in the
+// Sec-WebSocket-Extensions header.
+type Extension struct {
+	// Name is the extension token.
+	Name string
+
+	// Params holds the extension parameters. A parameter without a value
+	// has the empty string as value.
+	Params map[string]string
+}
+
+// String returns the extension in the format of the
+// Sec-WebSocket-Extensions header. The parameters are sorted by name.
+func (e Extension) String() string {
+	keys := make([]string, 0, len(e.Params))
+	for k := range e.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := e.Name
+	for _, k := range keys {
+		s += "; " + k
+		if v := e.Params[k]; v != "" {
+			if isToken(v) {
+				s += "=" + v
+			} else {
+				s += "=" + quoteString(v)
+			}
+		}
+	}
+	return s
+}
+
+// quoteString returns s as an HTTP quoted-string.
+func quoteString(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	b = append(b, '"')
+	return string(b)
+}
+
+func (e Extension) valid() bool {
+	if !isToken(e.Name) {
+		return false
+	}
+	for k, v := range e.Params {
+		// Quoted values must conform to the token ABNF after unescaping.
+		if !isToken(k) || (v != "" && !isToken(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatExtensionOffers returns the Sec-WebSocket-Extensions value for the
+// offers.
+func formatExtensionOffers(offers []Extension) (string, error) {
+	s := make([]string, len(offers))
+	for i, e := range offers {
+		if !e.valid() {
+			return "", errors.New("websocket: invalid extension offer " + e.String())
+		}
+		if strings.EqualFold(e.Name, "permessage-deflate") {
+			return "", errors.New("websocket: use EnableCompression to offer permessage-deflate")
+		}
+		s[i] = e.String()
+	}
+	return strings.Join(s, ", "), nil
+}
+
+// extensionFromParsed converts an extension returned from parseExtensions.
+func extensionFromParsed(ext map[string]string) Extension {
+	e := Extension{Name: ext[""], Params: make(map[string]string, len(ext)-1)}
+	for k, v := range ext {
+		if k != "" {
+			e.Params[k] = v
+		}
+	}
+	return e
+}
+
+// ExtensionHandler negotiates an extension in the server opening handshake.
+// See the Upgrader Extensions field.
+type ExtensionHandler struct {
+	// Name is the extension token. Use the Upgrader EnableCompression field
+	// for permessage-deflate.
+	Name string
+
+	// Negotiate is called with the client's offers for the extension in the
+	// order of the offers until an offer is accepted. Negotiate returns the
+	// parameters of the response and true to accept the offer.
+	Negotiate func(r *http.Request, offer Extension) (params map[string]string, ok bool)
+
+	// ReservedBits specifies the RSV2 and RSV3 bits owned by the extension.
+	// The bits are enabled on the connection when the extension is accepted.
+	// An offer is declined if the bits are owned by an extension accepted
+	// earlier in the handshake.
+	ReservedBits int
+}
+
+// serverExtensions is the result of the server extension negotiation.
+type serverExtensions struct {
+	compress     bool
+	deflate      deflateState
+	accepted     []Extension
+	responses    []string
+	reservedBits int
+}
+
+// response returns the Sec-WebSocket-Extensions response header value.
+func (se *serverExtensions) response() string {
+	return strings.Join(se.responses, ", ")
+}
+
+// configure sets the negotiated extensions on the connection.
+func (se *serverExtensions) configure(c *Conn) {
+	if se.compress {
+		se.deflate.configure(c)
+	}
+	c.extensions = se.accepted
+	c.reservedBits = se.reservedBits
+}
+
+// negotiateExtensions negotiates permessage-deflate, if enabled, and the
+// extensions with handlers. The extensions are accepted in the order of the
+// client's offers.
+func (u *Upgrader) negotiateExtensions(r *http.Request) (*serverExtensions, error) {
+	var params *CompressionParams
+	if u.EnableCompression {
+		params = u.compressionParams()
+		if err := params.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range u.Extensions {
+		if !isToken(h.Name) || strings.EqualFold(h.Name, "permessage-deflate") || h.ReservedBits&^extensionBits != 0 {
+			return nil, errors.New("websocket: invalid extension handler " + h.Name)
+		}
+	}
+	if params == nil && len(u.Extensions) == 0 {
+		return &serverExtensions{}, nil
+	}
+
+	se := &serverExtensions{}
+	accepted := make(map[string]bool)
+	for _, ext := range parseExtensions(r.Header) {
+		name := ext[""]
+		if accepted[name] {
+			continue
+		}
+		if name == "permessage-deflate" {
+			if params == nil {
+				continue
+			}
+			st, response, ok := negotiateServerOffer(params, ext)
+			if !ok {
+				continue
+			}
+			se.compress = true
+			se.deflate = st
+			se.accepted = append(se.accepted, extensionFromParsed(parseExtensions(http.Header{"Sec-Websocket-Extensions": {response}})[0]))
+			se.responses = append(se.responses, response)
+			accepted[name] = true
+			continue
+		}
+		for _, h := range u.Extensions {
+			if h.Name != name || h.ReservedBits&se.reservedBits != 0 || h.Negotiate == nil {
+				continue
+			}
+			params, ok := h.Negotiate(r, extensionFromParsed(ext))
+			if !ok {
+				break
+			}
+			e := Extension{Name: name, Params: params}
+			if !e.valid() {
+				return nil, errors.New("websocket: invalid extension response " + e.String())
+			}
+			se.accepted = append(se.accepted, e)
+			se.responses = append(se.responses, e.String())
+			se.reservedBits |= h.ReservedBits
+			accepted[name] = true
+			break
+		}
+	}
+	return se, nil
+}
+
+// Extensions returns the extensions accepted in the opening handshake,
+// including permessage-deflate.
+//
+// The application is responsible for implementing extensions other than
+// permessage-deflate. See EnableReservedBits.
+func (c *Conn) Extensions() []Extension {
+	return c.extensions
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var extensionStringTests = []struct {
+	ext  Extension
+	want string
+}{
+	{Extension{Name: "x-test"}, "x-test"},
+	{Extension{Name: "x-test", Params: map[string]string{"b": "2", "a": "", "c": "x.y"}}, "x-test; a; b=2; c=x.y"},
+	{Extension{Name: "x-test", Params: map[string]string{"a": `b "c"`}}, `x-test; a="b \"c\""`},
+}
+
+func TestExtensionString(t *testing.T) {
+	for _, tt := range extensionStringTests {
+		if got := tt.ext.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestParseExtensionHeaders(t *testing.T) {
+	h := http.Header{"Sec-Websocket-Extensions": {`x-a; p="1"`, "x-b, ,x-c; q"}}
+	exts := ParseExtensions(h)
+	want := []Extension{
+		{Name: "x-a", Params: map[string]string{"p": "1"}},
+		{Name: "x-b", Params: map[string]string{}},
+		{Name: "x-c", Params: map[string]string{"q": ""}},
+	}
+	if !reflect.DeepEqual(exts, want) {
+		t.Fatalf("ParseExtensions returned %v, want %v", exts, want)
+	}
+	if s := FormatExtensions(exts); s != "x-a; p=1, x-b, x-c; q" {
+		t.Errorf("FormatExtensions returned %q", s)
+	}
+}
+
+// extensionServer completes the opening handshake with the given
+// Sec-WebSocket-Extensions response and records the client offer.
+func extensionServer(t *testing.T, response string, offer *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*offer = r.Header.Get("Sec-Websocket-Extensions")
+		netConn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer netConn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(r.Header.Get("Sec-Websocket-Key")) + "\r\n")
+		if response != "" {
+			brw.WriteString("Sec-WebSocket-Extensions: " + response + "\r\n")
+		}
+		brw.WriteString("\r\n")
+		brw.Flush()
+	}))
+}
+
+func TestDialExtensions(t *testing.T) {
+	var offer string
+	s := extensionServer(t, "x-test; mode=fast", &offer)
+	defer s.Close()
+
+	d := Dialer{
+		EnableCompression: true,
+		Extensions: []Extension{
+			{Name: "x-test", Params: map[string]string{"mode": "fast"}},
+			{Name: "x-other"},
+		},
+	}
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	wantOffer := "permessage-deflate; server_no_context_takeover; client_no_context_takeover, x-test; mode=fast, x-other"
+	if offer != wantOffer {
+		t.Errorf("offer = %q, want %q", offer, wantOffer)
+	}
+	want := []Extension{{Name: "x-test", Params: map[string]string{"mode": "fast"}}}
+	if got := ws.Extensions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Extensions() = %v, want %v", got, want)
+	}
+}
+
+func TestDialUnexpectedExtension(t *testing.T) {
+	var offer string
+	s := extensionServer(t, "x-unknown", &offer)
+	defer s.Close()
+
+	d := Dialer{Extensions: []Extension{{Name: "x-test"}}}
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != errUnexpectedExtension {
+		if ws != nil {
+			ws.Close()
+		}
+		t.Fatalf("Dial returned %v, want %v", err, errUnexpectedExtension)
+	}
+}
+
+func TestDialInvalidExtensionOffer(t *testing.T) {
+	for _, ext := range []Extension{
+		{Name: "bad name"},
+		{Name: "x-test", Params: map[string]string{"k": "bad value"}},
+		{Name: "permessage-deflate"},
+	} {
+		d := Dialer{Extensions: []Extension{ext}}
+		if _, _, err := d.Dial("ws://example.com/", nil); err == nil {
+			t.Errorf("Dial with offer %v returned nil error", ext)
+		}
+	}
+}
+
+func TestUpgradeExtensions(t *testing.T) {
+	var offers []Extension
+	u := Upgrader{
+		EnableCompression: true,
+		Extensions: []ExtensionHandler{
+			{
+				Name: "x-foo",
+				Negotiate: func(r *http.Request, offer Extension) (map[string]string, bool) {
+					offers = append(offers, offer)
+					if offer.Params["v"] != "2" {
+						return nil, false
+					}
+					return map[string]string{"v": "2"}, true
+				},
+				ReservedBits: rsv2Bit,
+			},
+			{
+				// Declined because the bits are owned by x-foo.
+				Name:         "x-bar",
+				Negotiate:    func(r *http.Request, offer Extension) (map[string]string, bool) { return nil, true },
+				ReservedBits: rsv2Bit,
+			},
+		},
+	}
+	server := make(chan *Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		server <- ws
+	}))
+	defer s.Close()
+
+	d := cstDialer
+	d.EnableCompression = true
+	d.Extensions = []Extension{
+		{Name: "x-foo", Params: map[string]string{"v": "1"}},
+		{Name: "x-foo", Params: map[string]string{"v": "2"}},
+		{Name: "x-bar"},
+	}
+	ws, resp, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sc := <-server
+	defer sc.Close()
+
+	want := "permessage-deflate; server_no_context_takeover; client_no_context_takeover, x-foo; v=2"
+	if got := resp.Header.Get("Sec-Websocket-Extensions"); got != want {
+		t.Errorf("response extensions = %q, want %q", got, want)
+	}
+	if len(offers) != 2 || offers[0].Params["v"] != "1" || offers[1].Params["v"] != "2" {
+		t.Errorf("offers = %v", offers)
+	}
+	names := func(extensions []Extension) []string {
+		var s []string
+		for _, e := range extensions {
+			s = append(s, e.Name)
+		}
+		return s
+	}
+	if got := names(sc.Extensions()); !reflect.DeepEqual(got, []string{"permessage-deflate", "x-foo"}) {
+		t.Errorf("server Extensions() = %v", sc.Extensions())
+	}
+	if sc.reservedBits != rsv2Bit {
+		t.Errorf("server reserved bits = %x, want %x", sc.reservedBits, rsv2Bit)
+	}
+	if got := names(ws.Extensions()); !reflect.DeepEqual(got, []string{"permessage-deflate", "x-foo"}) {
+		t.Errorf("client Extensions() = %v", ws.Extensions())
+	}
+}
@@ -0,0 +1,481 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.7
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MessageConn is the message API shared by *Conn and the connections returned
+// from FallbackDialer.
+type MessageConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+var errFallbackClosed = errors.New("websocket: fallback connection closed")
+
+const defaultFallbackReadLimit = 1 << 20
+
+func (h *FallbackHandler) readLimit() int64 {
+	if h.ReadLimit <= 0 {
+		return defaultFallbackReadLimit
+	}
+	return h.ReadLimit
+}
+
+// FallbackHandler serves WebSocket connections with a fallback for clients
+// that cannot complete the WebSocket handshake, typically because a proxy
+// between the client and server strips the Upgrade header.
+//
+// In fallback mode, messages to the client are delivered as Server-Sent Events
+// on a long lived GET request and messages from the client are sent as POST
+// requests. Use FallbackDialer to connect to a FallbackHandler.
+type FallbackHandler struct {
+	// Upgrader upgrades WebSocket requests. The Upgrader's CheckOrigin
+	// function is also applied to fallback requests.
+	Upgrader Upgrader
+
+	// Serve is called in a new goroutine for each connection. The connection
+	// is closed when Serve returns.
+	Serve func(c MessageConn)
+
+	// ReadLimit specifies the maximum size in bytes of a message from the
+	// client. The limit is set on WebSocket connections with SetReadLimit
+	// and applied to the body of fallback POST requests. If zero, then a
+	// default of 1 MB is used.
+	ReadLimit int64
+
+	mu       sync.Mutex
+	sessions map[string]*sseServerConn
+}
+
+func (h *FallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if IsWebSocketUpgrade(r) {
+		c, err := h.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetReadLimit(h.readLimit())
+		h.Serve(c)
+		return
+	}
+
+	checkOrigin := h.Upgrader.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/event-stream"):
+		h.serveEvents(w, r)
+	case r.Method == "POST":
+		h.serveMessage(w, r)
+	case r.Method == "DELETE":
+		if c := h.session(r.URL.Query().Get("session")); c != nil {
+			c.Close()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	}
+}
+
+func (h *FallbackHandler) session(id string) *sseServerConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+func (h *FallbackHandler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "websocket: response does not implement http.Flusher", http.StatusInternalServerError)
+		return
+	}
+	id, err := generateChallengeKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c := &sseServerConn{
+		w:        w,
+		flusher:  flusher,
+		incoming: make(chan sseMessage, 16),
+		done:     make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*sseServerConn)
+	}
+	h.sessions[id] = c
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.sessions, id)
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := c.writeEvent("session", id); err != nil {
+		return
+	}
+
+	go func() {
+		defer c.Close()
+		h.Serve(c)
+	}()
+
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		c.Close()
+	}
+}
+
+func (h *FallbackHandler) serveMessage(w http.ResponseWriter, r *http.Request) {
+	c := h.session(r.URL.Query().Get("session"))
+	if c == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	messageType := TextMessage
+	if r.Header.Get("Content-Type") == "application/octet-stream" {
+		messageType = BinaryMessage
+	}
+	p, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.readLimit()))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	select {
+	case c.incoming <- sseMessage{messageType, p}:
+		w.WriteHeader(http.StatusNoContent)
+	case <-c.done:
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+	case <-r.Context().Done():
+	}
+}
+
+type sseMessage struct {
+	messageType int
+	data        []byte
+}
+
+// sseServerConn is the server side of a fallback connection.
+type sseServerConn struct {
+	mu      sync.Mutex // protects w and closed
+	w       io.Writer
+	flusher http.Flusher
+	closed  bool
+
+	incoming  chan sseMessage
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *sseServerConn) writeEvent(event, data string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errFallbackClosed
+	}
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	// Event stream lines end with CRLF, LF or CR.
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	data = strings.Replace(data, "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	if _, err := c.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+func (c *sseServerConn) ReadMessage() (int, []byte, error) {
+	select {
+	case m := <-c.incoming:
+		return m.messageType, m.data, nil
+	case <-c.done:
+		return noFrame, nil, &CloseError{Code: CloseNormalClosure}
+	}
+}
+
+func (c *sseServerConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage:
+		if bytes.IndexByte(data, '\r') >= 0 {
+			// The event stream does not preserve CR characters.
+			return c.writeEvent("text", base64.StdEncoding.EncodeToString(data))
+		}
+		return c.writeEvent("", string(data))
+	case BinaryMessage:
+		return c.writeEvent("binary", base64.StdEncoding.EncodeToString(data))
+	case CloseMessage:
+		return c.Close()
+	case PingMessage, PongMessage:
+		return nil
+	}
+	return errBadWriteOpCode
+}
+
+func (c *sseServerConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		close(c.done)
+	})
+	return nil
+}
+
+// FallbackDialer connects to a FallbackHandler. The dialer first attempts a
+// WebSocket connection. If the server responds that it does not support the
+// WebSocket protocol, then the dialer connects using Server-Sent Events and
+// POST requests.
+//
+// The dialer falls back when the handshake response status is 200 OK, 400
+// Bad Request or 501 Not Implemented. Other failed handshakes, such as 401
+// Unauthorized and 403 Forbidden, are returned to the application.
+type FallbackDialer struct {
+	// Dialer is used for the WebSocket connection attempt. If nil,
+	// DefaultDialer is used.
+	Dialer *Dialer
+
+	// Client is used for fallback requests. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+}
+
+// Dial connects to the ws or wss URL urlStr. The returned connection is a
+// *Conn when the WebSocket handshake succeeds. If the server rejects the
+// handshake or the fallback request, then Dial returns a HandshakeError.
+func (d *FallbackDialer) Dial(urlStr string, requestHeader http.Header) (MessageConn, error) {
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+	ws, resp, err := dialer.Dial(urlStr, requestHeader)
+	if err == nil {
+		return ws, nil
+	}
+	if resp == nil {
+		return nil, err
+	}
+	if !fallbackStatus(resp.StatusCode) {
+		return nil, fallbackError(resp)
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range requestHeader {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fallbackError(resp)
+	}
+
+	c := &sseClientConn{
+		client: client,
+		header: requestHeader,
+		body:   resp.Body,
+		br:     bufio.NewReader(resp.Body),
+	}
+	event, data, err := c.readEvent()
+	if err != nil || event != "session" {
+		resp.Body.Close()
+		return nil, fallbackError(resp)
+	}
+	q := u.Query()
+	q.Set("session", data)
+	u.RawQuery = q.Encode()
+	c.sessionURL = u.String()
+	return c, nil
+}
+
+// fallbackStatus returns true if the handshake response status indicates
+// that the server does not support the WebSocket protocol for the request.
+func fallbackStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusBadRequest, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// fallbackError returns the error for a failed fallback request.
+func fallbackError(resp *http.Response) error {
+	return HandshakeError{
+		message:    ErrBadHandshake.Error(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Reason:     HandshakeBadStatus,
+	}
+}
+
+// sseClientConn is the client side of a fallback connection.
+type sseClientConn struct {
+	client     *http.Client
+	header     http.Header
+	sessionURL string
+	body       io.ReadCloser
+	br         *bufio.Reader
+}
+
+// readLine reads a line terminated by CRLF, LF or CR and returns the line
+// without the terminator.
+func (c *sseClientConn) readLine() (string, error) {
+	var buf []byte
+	for {
+		b, err := c.br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '\n':
+			return string(buf), nil
+		case '\r':
+			if next, err := c.br.Peek(1); err == nil && next[0] == '\n' {
+				c.br.ReadByte()
+			}
+			return string(buf), nil
+		}
+		buf = append(buf, b)
+	}
+}
+
+func (c *sseClientConn) readEvent() (event, data string, err error) {
+	var lines []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", "", err
+		}
+		switch {
+		case line == "":
+			if len(lines) > 0 || event != "" {
+				return event, strings.Join(lines, "\n"), nil
+			}
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			lines = append(lines, strings.TrimPrefix(line[len("data:"):], " "))
+		}
+	}
+}
+
+func (c *sseClientConn) ReadMessage() (int, []byte, error) {
+	event, data, err := c.readEvent()
+	if err == io.EOF {
+		err = &CloseError{Code: CloseNormalClosure}
+	}
+	if err != nil {
+		return noFrame, nil, err
+	}
+	switch event {
+	case "binary":
+		p, err := base64.StdEncoding.DecodeString(data)
+		return BinaryMessage, p, err
+	case "text":
+		p, err := base64.StdEncoding.DecodeString(data)
+		return TextMessage, p, err
+	}
+	return TextMessage, []byte(data), nil
+}
+
+func (c *sseClientConn) send(method, contentType string, body io.Reader) error {
+	req, err := http.NewRequest(method, c.sessionURL, body)
+	if err != nil {
+		return err
+	}
+	for k, vs := range c.header {
+		req.Header[k] = vs
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return errFallbackClosed
+	}
+	return nil
+}
+
+func (c *sseClientConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage:
+		return c.send("POST", "text/plain; charset=utf-8", bytes.NewReader(data))
+	case BinaryMessage:
+		return c.send("POST", "application/octet-stream", bytes.NewReader(data))
+	case CloseMessage:
+		return c.Close()
+	case PingMessage, PongMessage:
+		return nil
+	}
+	return errBadWriteOpCode
+}
+
+func (c *sseClientConn) Close() error {
+	err := c.send("DELETE", "", nil)
+	c.body.Close()
+	return err
+}
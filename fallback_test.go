@@ -0,0 +1,122 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.7
+
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func echoMessages(c MessageConn) {
+	for {
+		mt, p, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(mt, p); err != nil {
+			return
+		}
+	}
+}
+
+func testFallbackEcho(t *testing.T, c MessageConn) {
+	messages := []struct {
+		messageType int
+		data        []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{TextMessage, []byte("multi\nline\n")},
+		{TextMessage, []byte("cr\rcrlf\r\nend")},
+		{BinaryMessage, []byte{0, 1, 2, '\n', 255}},
+	}
+	for _, m := range messages {
+		if err := c.WriteMessage(m.messageType, m.data); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+		mt, p, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if mt != m.messageType || !bytes.Equal(p, m.data) {
+			t.Errorf("ReadMessage returned %d, %q, want %d, %q", mt, p, m.messageType, m.data)
+		}
+	}
+}
+
+func TestFallbackWebSocket(t *testing.T) {
+	s := httptest.NewServer(&FallbackHandler{Serve: echoMessages})
+	defer s.Close()
+
+	var d FallbackDialer
+	c, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if _, ok := c.(*Conn); !ok {
+		t.Fatalf("Dial returned %T, want *Conn", c)
+	}
+	testFallbackEcho(t, c)
+}
+
+func TestFallbackEvents(t *testing.T) {
+	h := &FallbackHandler{Serve: echoMessages}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a proxy that does not forward the Upgrade header.
+		r.Header.Del("Upgrade")
+		h.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	var d FallbackDialer
+	c, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, ok := c.(*sseClientConn); !ok {
+		t.Fatalf("Dial returned %T, want fallback connection", c)
+	}
+	testFallbackEcho(t, c)
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestFallbackForbidden(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}))
+	defer s.Close()
+
+	var d FallbackDialer
+	_, err := d.Dial(makeWsProto(s.URL), nil)
+	e, ok := err.(HandshakeError)
+	if !ok || e.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial returned %v, want handshake error with status 403", err)
+	}
+}
+
+func TestFallbackReadLimit(t *testing.T) {
+	h := &FallbackHandler{Serve: echoMessages, ReadLimit: 16}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Upgrade")
+		h.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	var d FallbackDialer
+	c, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if err := c.WriteMessage(TextMessage, bytes.Repeat([]byte("x"), 17)); err == nil {
+		t.Errorf("WriteMessage of message over limit did not return an error")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Handler is an http.Handler that upgrades requests to the WebSocket
+// protocol and calls a function with the connection.
+//
+// The function is called in a new goroutine with the connection context. The
+// context is canceled when the function returns, when reading the next
+// message from the connection fails because the client disconnected or closed
+// the connection, when the request context is canceled, or when the
+// http.Server serving the request is shut down. The connection is closed when
+// the function returns.
+type Handler struct {
+	// Upgrader specifies the upgrader for requests. If nil, then an Upgrader
+	// with the zero value for all fields is used.
+	Upgrader *Upgrader
+
+	// Serve is called with the upgraded connection. The goroutine serving
+	// the request waits for Serve to return.
+	Serve func(ctx context.Context, c *Conn) error
+
+	// WriteOnly specifies that Serve does not read from the connection. If
+	// WriteOnly is true, then the Handler reads from the connection and
+	// discards the data messages so that control messages are processed and
+	// the context is canceled when the client disconnects.
+	WriteOnly bool
+
+	// ErrorLog specifies an optional logger for errors returned from Serve.
+	// If nil, then logging is done via the log package's
+	// standard logger. Close errors with the normal closure, going away and
+	// abnormal closure codes are not logged.
+	ErrorLog *log.Logger
+
+	// OnError specifies an optional function called with the errors
+	// returned from Serve. If OnError is set, then errors are not logged.
+	OnError func(r *http.Request, err error)
+
+	mu       sync.Mutex
+	servers  map[*http.Server]bool
+	sessions map[*handlerSession]bool
+}
+
+type handlerSession struct {
+	server *http.Server
+	cancel context.CancelFunc
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// track registers the session for cancellation on shutdown of the server.
+func (h *Handler) track(s *handlerSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions == nil {
+		h.sessions = make(map[*handlerSession]bool)
+		h.servers = make(map[*http.Server]bool)
+	}
+	h.sessions[s] = true
+	if s.server != nil && !h.servers[s.server] {
+		h.servers[s.server] = true
+		srv := s.server
+		srv.RegisterOnShutdown(func() { h.shutdown(srv) })
+	}
+}
+
+func (h *Handler) untrack(s *handlerSession) {
+	h.mu.Lock()
+	delete(h.sessions, s)
+	h.mu.Unlock()
+}
+
+// shutdown cancels the sessions for requests served by srv.
+func (h *Handler) shutdown(srv *http.Server) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.sessions {
+		if s.server == srv {
+			s.cancel()
+		}
+	}
+}
+
+// ServeHTTP upgrades the request and calls the Serve function.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := h.Upgrader
+	if u == nil {
+		u = &Upgrader{}
+	}
+	c, err := u.UpgradeWithContext(r.Context(), w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	c.onReadError = c.cancelCtx
+
+	s := &handlerSession{cancel: c.cancelCtx}
+	s.server, _ = r.Context().Value(http.ServerContextKey).(*http.Server)
+	h.track(s)
+	defer h.untrack(s)
+
+	if h.WriteOnly {
+		c.goLabeled("read", func() {
+			for {
+				if _, _, err := c.NextReader(); err != nil {
+					return
+				}
+			}
+		})
+	}
+
+	done := make(chan error, 1)
+	c.goLabeled("serve", func() { done <- h.Serve(c.Context(), c) })
+	err = <-done
+	switch {
+	case err == nil:
+	case h.OnError != nil:
+		h.OnError(r, err)
+	case !IsCloseError(err, CloseNormalClosure, CloseGoingAway, CloseAbnormalClosure):
+		h.logf("websocket: serving %v: %v", r.RemoteAddr, err)
+	}
+}
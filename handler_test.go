@@ -0,0 +1,136 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	errServe := errors.New("serve error")
+	served := make(chan error, 1)
+	errs := make(chan error, 1)
+	h := &Handler{
+		Upgrader: &Upgrader{},
+		Serve: func(ctx context.Context, c *Conn) error {
+			go func() {
+				// Read until the client disconnects.
+				for {
+					if _, _, err := c.NextReader(); err != nil {
+						return
+					}
+				}
+			}()
+			select {
+			case <-ctx.Done():
+				served <- ctx.Err()
+			case <-time.After(5 * time.Second):
+				served <- errors.New("context not canceled")
+			}
+			return errServe
+		},
+		OnError: func(r *http.Request, err error) { errs <- err },
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+
+	if err := <-served; err != context.Canceled {
+		t.Errorf("context error %v, want %v", err, context.Canceled)
+	}
+	if err := <-errs; err != errServe {
+		t.Errorf("OnError called with %v, want %v", err, errServe)
+	}
+}
+
+func TestHandlerShutdown(t *testing.T) {
+	started := make(chan bool)
+	done := make(chan error, 1)
+	h := &Handler{
+		Serve: func(ctx context.Context, c *Conn) error {
+			close(started)
+			<-ctx.Done()
+			done <- ctx.Err()
+			return nil
+		},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	<-started
+
+	s.Config.Shutdown(context.Background())
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("context error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not canceled on shutdown")
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("connection not closed after Serve returned")
+	}
+}
+
+func TestHandlerWriteOnly(t *testing.T) {
+	var logBuf bytes.Buffer
+	served := make(chan error, 1)
+	h := &Handler{
+		WriteOnly: true,
+		Serve: func(ctx context.Context, c *Conn) error {
+			c.WriteMessage(TextMessage, []byte("hello"))
+			select {
+			case <-ctx.Done():
+				served <- ctx.Err()
+			case <-time.After(5 * time.Second):
+				served <- errors.New("context not canceled")
+			}
+			return &CloseError{Code: CloseAbnormalClosure}
+		},
+		ErrorLog: log.New(&logBuf, "", 0),
+	}
+	returned := make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		close(returned)
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, p, err := ws.ReadMessage(); err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessage returned %q, %v, want hello", p, err)
+	}
+	ws.Close()
+
+	if err := <-served; err != context.Canceled {
+		t.Errorf("context error %v, want %v", err, context.Canceled)
+	}
+	<-returned
+	if logBuf.Len() != 0 {
+		t.Errorf("abnormal closure logged: %s", logBuf.String())
+	}
+}
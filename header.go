@@ -0,0 +1,57 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ParseSubprotocols returns the subprotocols in the Sec-WebSocket-Protocol
+// headers of h. The function accepts multiple headers and comma separated
+// lists with optional whitespace. Empty list elements are ignored.
+func ParseSubprotocols(h http.Header) []string {
+	var protocols []string
+	for _, s := range h["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+// FormatSubprotocols returns the Sec-WebSocket-Protocol header value for the
+// subprotocols.
+func FormatSubprotocols(protocols []string) string {
+	return strings.Join(protocols, ", ")
+}
+
+// ParseExtensions returns the extensions in the Sec-WebSocket-Extensions
+// headers of h. Parameter values may be tokens or quoted strings. Parsing of
+// a header stops at the first syntax error; the extensions before the error
+// are returned.
+func ParseExtensions(h http.Header) []Extension {
+	parsed := parseExtensions(h)
+	if parsed == nil {
+		return nil
+	}
+	exts := make([]Extension, len(parsed))
+	for i, ext := range parsed {
+		exts[i] = extensionFromParsed(ext)
+	}
+	return exts
+}
+
+// FormatExtensions returns the Sec-WebSocket-Extensions header value for the
+// extensions.
+func FormatExtensions(exts []Extension) string {
+	s := make([]string, len(exts))
+	for i, e := range exts {
+		s[i] = e.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.20
+
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// hijack takes over the network connection from the HTTP server. The
+// response controller finds the http.Hijacker through response writers
+// that wrap another writer and provide an Unwrap method.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		if errors.Is(err, http.ErrNotSupported) {
+			err = nil
+		}
+		return nil, nil, &HijackError{ResponseWriter: fmt.Sprintf("%T", w), Err: err}
+	}
+	return netConn, brw, nil
+}
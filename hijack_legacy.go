@@ -0,0 +1,26 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !go1.20
+
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, &HijackError{ResponseWriter: fmt.Sprintf("%T", w)}
+	}
+	netConn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, &HijackError{ResponseWriter: fmt.Sprintf("%T", w), Err: err}
+	}
+	return netConn, brw, nil
+}
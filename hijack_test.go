@@ -0,0 +1,64 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.20
+
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// wrappedResponseWriter hides the http.Hijacker implementation of the
+// writer that it wraps, as done by some middleware.
+type wrappedResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrappedResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func TestUpgradeWrappedResponseWriter(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(wrappedResponseWriter{w}, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		ws.Close()
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+}
+
+func TestUpgradeNotHijacker(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Connection", "upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-Websocket-Version", "13")
+	req.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	w := httptest.NewRecorder()
+	_, err := cstUpgrader.Upgrade(w, req, nil)
+	var herr *HijackError
+	if !errors.As(err, &herr) {
+		t.Fatalf("Upgrade returned %v, want HijackError", err)
+	}
+	if herr.Err != nil || herr.ResponseWriter != "*httptest.ResponseRecorder" {
+		t.Errorf("HijackError = %+v, want writer *httptest.ResponseRecorder with nil Err", herr)
+	}
+	if _, ok := err.(HandshakeError); !ok {
+		t.Errorf("Upgrade returned %T, want HandshakeError", err)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status=%d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build websocket_hixie76
+
+package websocket
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// This file implements the server side of the legacy draft-hixie-76 protocol,
+// also published as draft-ietf-hybi-thewebsocketprotocol-00. The protocol is
+// only supported for old embedded clients and is not compiled unless the
+// websocket_hixie76 build tag is set.
+
+var errHixieKey = errors.New("websocket: invalid hixie-76 key")
+
+// HixieConn is a connection using the legacy draft-hixie-76 protocol. The
+// protocol supports text messages only and has no ping or pong messages.
+//
+// HixieConn supports one concurrent reader and one concurrent writer.
+type HixieConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	readLimit   int64
+
+	mu     sync.Mutex // protects conn writes and closed
+	closed bool
+}
+
+// IsHixie76Upgrade returns true if the request is a draft-hixie-76 opening
+// handshake.
+func IsHixie76Upgrade(r *http.Request) bool {
+	return tokenListContainsValue(r.Header, "Connection", "upgrade") &&
+		tokenListContainsValue(r.Header, "Upgrade", "websocket") &&
+		r.Header.Get("Sec-Websocket-Key1") != "" &&
+		r.Header.Get("Sec-Websocket-Key2") != ""
+}
+
+// hixieKeyNumber returns the number encoded in a Sec-WebSocket-Key1 or
+// Sec-WebSocket-Key2 header value: the digits of the key divided by the
+// number of spaces in the key.
+func hixieKeyNumber(key string) (uint32, error) {
+	var n uint64
+	var spaces uint64
+	for i := 0; i < len(key); i++ {
+		switch b := key[i]; {
+		case '0' <= b && b <= '9':
+			n = n*10 + uint64(b-'0')
+			if n > 1<<36 {
+				// Larger than 2^32 times the maximum number of spaces.
+				return 0, errHixieKey
+			}
+		case b == ' ':
+			spaces++
+		}
+	}
+	if spaces == 0 || n%spaces != 0 || n/spaces > 1<<32-1 {
+		return 0, errHixieKey
+	}
+	return uint32(n / spaces), nil
+}
+
+// UpgradeHixie76 upgrades a draft-hixie-76 request to a HixieConn. Use
+// IsHixie76Upgrade to detect these requests before calling Upgrade.
+//
+// The Upgrader's CheckOrigin, Subprotocols, SelectSubprotocol and
+// HandshakeTimeout fields are used. Other fields are ignored.
+func (u *Upgrader) UpgradeHixie76(w http.ResponseWriter, r *http.Request) (*HixieConn, error) {
+	if r.Method != "GET" || !IsHixie76Upgrade(r) {
+		return nil, u.hixieError(w, r, http.StatusBadRequest, "websocket: not a hixie-76 handshake")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return nil, u.hixieError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	}
+	key1, err := hixieKeyNumber(r.Header.Get("Sec-Websocket-Key1"))
+	if err != nil {
+		return nil, u.hixieError(w, r, http.StatusBadRequest, err.Error())
+	}
+	key2, err := hixieKeyNumber(r.Header.Get("Sec-Websocket-Key2"))
+	if err != nil {
+		return nil, u.hixieError(w, r, http.StatusBadRequest, err.Error())
+	}
+
+	subprotocol, ok := u.selectSubprotocol(r, nil)
+	if !ok {
+		return nil, u.hixieError(w, r, http.StatusBadRequest, errSubprotocolRejected)
+	}
+
+	netConn, brw, err := hijack(w)
+	if err != nil {
+		_, err = u.returnHandshakeError(w, r, HandshakeError{message: err.Error(), StatusCode: http.StatusInternalServerError, err: err})
+		return nil, err
+	}
+
+	// Clear deadlines set by HTTP server.
+	netConn.SetDeadline(time.Time{})
+	if u.HandshakeTimeout > 0 {
+		netConn.SetDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+
+	// The eight byte key follows the request header. The key is not
+	// described by a Content-Length header, so the HTTP server leaves the
+	// key in the hijacked reader.
+	var challenge [16]byte
+	binary.BigEndian.PutUint32(challenge[0:], key1)
+	binary.BigEndian.PutUint32(challenge[4:], key2)
+	if _, err := io.ReadFull(brw.Reader, challenge[8:]); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	response := md5.Sum(challenge[:])
+
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+
+	p := []byte("HTTP/1.1 101 WebSocket Protocol Handshake\r\nUpgrade: WebSocket\r\nConnection: Upgrade\r\n")
+	if origin := r.Header.Get("Origin"); origin != "" {
+		p = append(p, "Sec-WebSocket-Origin: "+sanitizeHeaderValue(origin)+"\r\n"...)
+	}
+	p = append(p, "Sec-WebSocket-Location: "+scheme+"://"+sanitizeHeaderValue(r.Host+r.URL.RequestURI())+"\r\n"...)
+	if subprotocol != "" {
+		p = append(p, "Sec-WebSocket-Protocol: "+subprotocol+"\r\n"...)
+	}
+	p = append(p, "\r\n"...)
+	p = append(p, response[:]...)
+	if _, err := netConn.Write(p); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	return &HixieConn{conn: netConn, br: brw.Reader, subprotocol: subprotocol}, nil
+}
+
+func (u *Upgrader) hixieError(w http.ResponseWriter, r *http.Request, status int, reason string) error {
+	_, err := u.returnError(w, r, status, reason)
+	return err
+}
+
+func sanitizeHeaderValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' {
+			// prevent response splitting.
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// Subprotocol returns the negotiated protocol for the connection.
+func (c *HixieConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetReadLimit sets the maximum size for a message read from the peer. If a
+// message exceeds the limit, then ReadMessage returns ErrReadLimit.
+func (c *HixieConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// ReadMessage reads the next text message from the peer. ReadMessage returns
+// a *CloseError when the peer sends a closing frame.
+func (c *HixieConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		frameType, err := c.br.ReadByte()
+		if err != nil {
+			return noFrame, nil, err
+		}
+		if frameType&0x80 == 0 {
+			// Sentinel delimited text frame.
+			for {
+				b, err := c.br.ReadByte()
+				if err != nil {
+					return noFrame, nil, err
+				}
+				if b == 0xff {
+					break
+				}
+				p = append(p, b)
+				if c.readLimit > 0 && int64(len(p)) > c.readLimit {
+					return noFrame, nil, ErrReadLimit
+				}
+			}
+			if frameType != 0 {
+				// Discard frames with unknown types.
+				p = p[:0]
+				continue
+			}
+			if !utf8.Valid(p) {
+				return noFrame, nil, errors.New("websocket: invalid utf8 in hixie-76 frame")
+			}
+			return TextMessage, p, nil
+		}
+
+		// Length prefixed frame.
+		var n int64
+		for {
+			b, err := c.br.ReadByte()
+			if err != nil {
+				return noFrame, nil, err
+			}
+			n = n*128 + int64(b&0x7f)
+			if n > 1<<40 {
+				return noFrame, nil, errors.New("websocket: invalid hixie-76 frame length")
+			}
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		if frameType == 0xff && n == 0 {
+			c.writeFrame([]byte{0xff, 0x00})
+			return noFrame, nil, &CloseError{Code: CloseNoStatusReceived}
+		}
+		if _, err := io.CopyN(ioutil.Discard, c.br, n); err != nil {
+			return noFrame, nil, err
+		}
+	}
+}
+
+func (c *HixieConn) writeFrame(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrCloseSent
+	}
+	if len(p) == 2 && p[0] == 0xff {
+		c.closed = true
+	}
+	_, err := c.conn.Write(p)
+	return err
+}
+
+// WriteMessage writes a message to the peer. The TextMessage and CloseMessage
+// types are supported. The data argument is ignored for close messages. Ping
+// and pong messages are silently discarded because the protocol does not
+// support them.
+func (c *HixieConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage:
+		if !utf8.Valid(data) {
+			return errors.New("websocket: invalid utf8 in hixie-76 message")
+		}
+		for _, b := range data {
+			if b == 0xff {
+				return errors.New("websocket: invalid byte in hixie-76 message")
+			}
+		}
+		p := make([]byte, 0, len(data)+2)
+		p = append(p, 0x00)
+		p = append(p, data...)
+		p = append(p, 0xff)
+		return c.writeFrame(p)
+	case CloseMessage:
+		return c.writeFrame([]byte{0xff, 0x00})
+	case PingMessage, PongMessage:
+		return nil
+	}
+	return errBadWriteOpCode
+}
+
+// SetReadDeadline sets the read deadline on the underlying network connection.
+func (c *HixieConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the underlying network
+// connection.
+func (c *HixieConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the remote network address.
+func (c *HixieConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying network connection without sending a closing
+// frame.
+func (c *HixieConn) Close() error {
+	return c.conn.Close()
+}
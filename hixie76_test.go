@@ -0,0 +1,113 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build websocket_hixie76
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHixieKeyNumber(t *testing.T) {
+	for _, tt := range []struct {
+		key string
+		n   uint32
+		ok  bool
+	}{
+		{"4 @1  46546xW%0l 1 5", 829309203, true},
+		{"12998 5 Y3 1  .P00", 259970620, true},
+		{"123", 0, false},
+		{"1 2 3", 0, false},
+		{"99999999999999999999 ", 0, false},
+	} {
+		n, err := hixieKeyNumber(tt.key)
+		if (err == nil) != tt.ok || n != tt.n {
+			t.Errorf("hixieKeyNumber(%q) = %d, %v, want %d, ok=%v", tt.key, n, err, tt.n, tt.ok)
+		}
+	}
+}
+
+func TestHixie76(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsHixie76Upgrade(r) {
+			t.Errorf("IsHixie76Upgrade returned false")
+			return
+		}
+		u := Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+		c, err := u.UpgradeHixie76(w, r)
+		if err != nil {
+			t.Errorf("UpgradeHixie76: %v", err)
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, p)
+		}
+	}))
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", strings.TrimPrefix(s.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	io.WriteString(nc, "GET /demo HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key2: 12998 5 Y3 1  .P00\r\n"+
+		"Upgrade: WebSocket\r\n"+
+		"Sec-WebSocket-Key1: 4 @1  46546xW%0l 1 5\r\n"+
+		"Origin: http://example.com\r\n"+
+		"\r\n"+
+		"^n:ds[4U")
+
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 101 {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Sec-Websocket-Location"); loc != "ws://example.com/demo" {
+		t.Errorf("location = %q", loc)
+	}
+	response := make([]byte, 16)
+	if _, err := io.ReadFull(br, response); err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "8jKS'y:G*Co,Wxa-" {
+		t.Fatalf("response = %q, want %q", response, "8jKS'y:G*Co,Wxa-")
+	}
+
+	nc.Write([]byte("\x00hello\xff"))
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame, []byte("\x00hello\xff")) {
+		t.Fatalf("frame = %q", frame)
+	}
+
+	nc.Write([]byte{0xff, 0x00})
+	if _, err := io.ReadFull(br, frame[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame[:2], []byte{0xff, 0x00}) {
+		t.Fatalf("close frame = %q", frame[:2])
+	}
+}
@@ -6,9 +6,14 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 )
 
+// ErrJSONLimit is returned from ReadJSON when a message is larger than the
+// limit set with SetJSONReadLimit.
+var ErrJSONLimit = errors.New("websocket: JSON message exceeds limit")
+
 // WriteJSON writes the JSON encoding of v as a message.
 //
 // Deprecated: Use c.WriteJSON instead.
@@ -44,6 +49,10 @@ func ReadJSON(c *Conn, v interface{}) error {
 // ReadJSON reads the next JSON-encoded message from the connection and stores
 // it in the value pointed to by v.
 //
+// The value is decoded directly from the message reader without buffering the
+// complete message. Use SetJSONReadLimit to limit the size of the message.
+// The connection reuses the JSON decoder for consecutive messages.
+//
 // See the documentation for the encoding/json Unmarshal function for details
 // about the conversion of JSON to a Go value.
 func (c *Conn) ReadJSON(v interface{}) error {
@@ -51,10 +60,82 @@ func (c *Conn) ReadJSON(v interface{}) error {
 	if err != nil {
 		return err
 	}
-	err = json.NewDecoder(r).Decode(v)
+	d := c.jsonDecoder
+	if d == nil {
+		d = &jsonDecoder{}
+		d.dec = json.NewDecoder(d)
+		c.jsonDecoder = d
+	}
+	d.r = r
+	d.limit = c.jsonReadLimit > 0
+	d.n = c.jsonReadLimit
+	err = d.dec.Decode(v)
+	d.r = nil
+	if err != nil || !d.reusable() {
+		c.jsonDecoder = nil
+	}
 	if err == io.EOF {
 		// One value is expected in the message.
 		err = io.ErrUnexpectedEOF
 	}
 	return err
 }
+
+// SetJSONReadLimit sets the maximum size in bytes of a message decoded by
+// ReadJSON. If a message exceeds the limit, then ReadJSON returns ErrJSONLimit
+// and the remainder of the message is discarded by the next read. Unlike the
+// limit set with SetReadLimit, exceeding this limit does not close the
+// connection. A limit of zero means no limit.
+func (c *Conn) SetJSONReadLimit(limit int64) {
+	c.jsonReadLimit = limit
+}
+
+// jsonDecoder is a JSON decoder that is reused for the messages read by
+// ReadJSON. The decoder reads from the current message reader r. If limit is
+// true, then the decoder reads at most n bytes from r and returns
+// ErrJSONLimit if r has more data.
+type jsonDecoder struct {
+	dec   *json.Decoder
+	r     io.Reader
+	limit bool
+	n     int64
+}
+
+func (d *jsonDecoder) Read(p []byte) (int, error) {
+	if !d.limit {
+		return d.r.Read(p)
+	}
+	if d.n <= 0 {
+		var b [1]byte
+		n, err := d.r.Read(b[:])
+		if n > 0 {
+			return 0, ErrJSONLimit
+		}
+		return 0, err
+	}
+	if int64(len(p)) > d.n {
+		p = p[:d.n]
+	}
+	n, err := d.r.Read(p)
+	d.n -= int64(n)
+	return n, err
+}
+
+// reusable returns true if the decoder can decode the next message. The
+// decoder cannot be reused when data following the value in the previous
+// message is buffered in the decoder.
+func (d *jsonDecoder) reusable() bool {
+	br, ok := d.dec.Buffered().(io.ByteReader)
+	if !ok {
+		return false
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return true
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return false
+		}
+	}
+}
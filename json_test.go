@@ -117,3 +117,67 @@ func TestDeprecatedJSON(t *testing.T) {
 		t.Fatal("equal", actual, expect)
 	}
 }
+
+func TestJSONReadLimit(t *testing.T) {
+	var buf bytes.Buffer
+	c := fakeNetConn{&buf, &buf}
+	wc := newConn(c, true, 1024, 1024)
+	rc := newConn(c, false, 1024, 1024)
+	rc.SetJSONReadLimit(8)
+
+	wc.WriteMessage(TextMessage, []byte(`{"A":1,"B":"hello"}`))
+	wc.WriteMessage(TextMessage, []byte(`12345678`))
+	wc.WriteMessage(TextMessage, []byte(`{"A":2}`))
+
+	var v struct{ A int }
+	if err := rc.ReadJSON(&v); err != ErrJSONLimit {
+		t.Fatalf("ReadJSON returned %v, want %v", err, ErrJSONLimit)
+	}
+
+	var n int
+	if err := rc.ReadJSON(&n); err != nil || n != 12345678 {
+		t.Fatalf("ReadJSON returned %d, %v, want 12345678, nil", n, err)
+	}
+
+	if err := rc.ReadJSON(&v); err != nil || v.A != 2 {
+		t.Fatalf("ReadJSON returned %+v, %v, want A=2, nil", v, err)
+	}
+}
+
+func TestReadJSONReuseDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	c := fakeNetConn{&buf, &buf}
+	wc := newConn(c, true, 1024, 1024)
+	rc := newConn(c, false, 1024, 1024)
+
+	wc.WriteJSON(1)
+	wc.WriteMessage(TextMessage, []byte(`2`))
+	wc.WriteMessage(TextMessage, []byte(`3 "trailing"`))
+	wc.WriteMessage(TextMessage, []byte(`4`))
+	wc.WriteMessage(TextMessage, []byte(`{`))
+	wc.WriteMessage(TextMessage, []byte(`6`))
+
+	var n int
+	if err := rc.ReadJSON(&n); err != nil || n != 1 {
+		t.Fatalf("ReadJSON returned %d, %v, want 1, nil", n, err)
+	}
+	d := rc.jsonDecoder
+	if err := rc.ReadJSON(&n); err != nil || n != 2 {
+		t.Fatalf("ReadJSON returned %d, %v, want 2, nil", n, err)
+	}
+	if rc.jsonDecoder != d {
+		t.Errorf("decoder not reused")
+	}
+	if err := rc.ReadJSON(&n); err != nil || n != 3 {
+		t.Fatalf("ReadJSON returned %d, %v, want 3, nil", n, err)
+	}
+	if err := rc.ReadJSON(&n); err != nil || n != 4 {
+		t.Fatalf("ReadJSON returned %d, %v, want 4, nil", n, err)
+	}
+	if err := rc.ReadJSON(&n); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadJSON returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if err := rc.ReadJSON(&n); err != nil || n != 6 {
+		t.Fatalf("ReadJSON returned %d, %v, want 6, nil", n, err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrKeepaliveTimeout is returned from the read methods of a connection
+// closed by the keepalive because the peer did not answer a ping within the
+// pong timeout. See Dialer.PingInterval and Upgrader.PingInterval.
+var ErrKeepaliveTimeout = errors.New("websocket: keepalive timeout")
+
+// keepalive pings the peer of a connection and closes the connection when the
+// peer stops responding. The pings and pong checks are scheduled on
+// keepaliveWheel.
+type keepalive struct {
+	// pongReceived is the time of the last pong in Unix nanoseconds. The
+	// field is first in the struct for 64-bit alignment of atomic access.
+	pongReceived int64
+
+	c        *Conn
+	interval time.Duration
+	timeout  time.Duration
+	failed   int32 // set to 1 on timeout, accessed atomically
+
+	// readDeadline is the time when reads time out if a pong is not
+	// received. The field is accessed by the goroutine reading the
+	// connection.
+	readDeadline time.Time
+
+	mu      sync.Mutex
+	timer   *wheelTimer // next ping or pong check
+	stopped bool
+}
+
+// startKeepalive sends a ping every interval and closes the connection if a
+// pong is not received within timeout of a ping. The keepalive also sets the
+// read deadline on the network connection and extends the deadline when a
+// pong is received, so that a blocked read fails when the peer stops
+// responding. The pong handler is not used to detect pongs.
+//
+// startKeepalive must be called before the connection is returned to the
+// application. The c.keepalive field is not otherwise synchronized; the read
+// methods and Close access it without a lock.
+func (c *Conn) startKeepalive(interval, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	k := &keepalive{c: c, interval: interval, timeout: timeout}
+	c.keepalive = k
+	c.extendReadDeadline()
+	k.schedule(interval, k.ping)
+}
+
+// schedule calls f in a new goroutine after d unless the keepalive is
+// stopped.
+func (k *keepalive) schedule(d time.Duration, f func()) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stopped {
+		return
+	}
+	k.timer = keepaliveWheel.afterFunc(d, func() { k.c.goLabeled("keepalive", f) })
+}
+
+func (k *keepalive) ping() {
+	sent := time.Now()
+	if err := k.c.WriteControl(PingMessage, nil, sent.Add(k.timeout)); err != nil {
+		return
+	}
+	k.schedule(k.timeout, func() { k.check(sent) })
+}
+
+// check closes the connection if a pong is not received after the ping sent
+// at sent. Otherwise, check schedules the next ping.
+func (k *keepalive) check(sent time.Time) {
+	if atomic.LoadInt64(&k.pongReceived) < sent.UnixNano() {
+		atomic.StoreInt32(&k.failed, 1)
+		k.c.Close()
+		return
+	}
+	k.schedule(k.period()-k.timeout, k.ping)
+}
+
+func (k *keepalive) stop() {
+	k.mu.Lock()
+	k.stopped = true
+	if k.timer != nil {
+		keepaliveWheel.stop(k.timer)
+	}
+	k.mu.Unlock()
+}
+
+// period returns the time between pings. The next ping is not sent until
+// the pong timeout of the previous ping expires.
+func (k *keepalive) period() time.Duration {
+	if k.timeout > k.interval {
+		return k.timeout
+	}
+	return k.interval
+}
+
+// extendReadDeadline moves the keepalive read deadline to one ping period
+// and pong timeout from now. The method is called when the keepalive starts
+// and by the read methods when a pong is received.
+func (c *Conn) extendReadDeadline() {
+	k := c.keepalive
+	k.readDeadline = time.Now().Add(k.period() + k.timeout)
+	c.conn.SetReadDeadline(c.netReadDeadline())
+}
+
+// netReadDeadline returns the read deadline for the network connection: the
+// earlier of the deadline set by the application and the keepalive read
+// deadline.
+func (c *Conn) netReadDeadline() time.Time {
+	if c.keepalive == nil || (!c.readDeadline.IsZero() && c.readDeadline.Before(c.keepalive.readDeadline)) {
+		return c.readDeadline
+	}
+	return c.keepalive.readDeadline
+}
+
+// keepaliveError returns ErrKeepaliveTimeout in place of the read error err
+// when the keepalive closed the connection or when the keepalive read
+// deadline expired.
+func (c *Conn) keepaliveError(err error) error {
+	k := c.keepalive
+	if err == nil || k == nil {
+		return err
+	}
+	if atomic.LoadInt32(&k.failed) != 0 {
+		return ErrKeepaliveTimeout
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() && c.netReadDeadline().Equal(k.readDeadline) {
+		return ErrKeepaliveTimeout
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// keepaliveServer returns a server that echoes messages. If answerPings is
+// false, then the server does not read from the connection and pings are not
+// answered.
+func keepaliveServer(t *testing.T, answerPings bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		if !answerPings {
+			time.Sleep(time.Second)
+			return
+		}
+		for {
+			mt, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestKeepalive(t *testing.T) {
+	s := keepaliveServer(t, true)
+	defer s.Close()
+
+	d := cstDialer
+	d.PingInterval = 10 * time.Millisecond
+	d.PongTimeout = time.Second
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	pongs := make(chan string, 10)
+	ws.SetPongHandler(func(appData string) error {
+		select {
+		case pongs <- appData:
+		default:
+		}
+		return nil
+	})
+
+	// Pongs are processed by the read methods.
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for n := 0; n < 3; n++ {
+		select {
+		case <-pongs:
+		case <-timeout:
+			t.Fatalf("received %d pongs, want 3", n)
+		}
+	}
+}
+
+func TestUpgraderKeepalive(t *testing.T) {
+	pongs := make(chan string, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := cstUpgrader
+		u.PingInterval = 10 * time.Millisecond
+		u.PongTimeout = time.Second
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		ws.SetPongHandler(func(appData string) error {
+			select {
+			case pongs <- appData:
+			default:
+			}
+			return nil
+		})
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	// The default ping handler answers the server's pings.
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for n := 0; n < 3; n++ {
+		select {
+		case <-pongs:
+		case <-timeout:
+			t.Fatalf("received %d pongs, want 3", n)
+		}
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	s := keepaliveServer(t, false)
+	defer s.Close()
+
+	d := cstDialer
+	d.PingInterval = 10 * time.Millisecond
+	d.PongTimeout = 20 * time.Millisecond
+	ws, _, err := d.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	start := time.Now()
+	_, _, err = ws.ReadMessage()
+	if err != ErrKeepaliveTimeout {
+		t.Fatalf("ReadMessage returned %v, want %v", err, ErrKeepaliveTimeout)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("keepalive timeout after %v", d)
+	}
+}
+
+// deadlineNetConn records the read deadline.
+type deadlineNetConn struct {
+	fakeNetConn
+	readDeadline time.Time
+}
+
+func (c *deadlineNetConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+func TestKeepaliveReadDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	nc := &deadlineNetConn{fakeNetConn: fakeNetConn{Reader: &buf, Writer: &buf}}
+	c := newConn(nc, false, 1024, 1024)
+	c.startKeepalive(time.Hour, time.Minute)
+	defer c.Close()
+
+	first := nc.readDeadline
+	if want := time.Now().Add(time.Hour + time.Minute); first.After(want) || first.Before(want.Add(-time.Second)) {
+		t.Fatalf("read deadline = %v, want about %v", first, want)
+	}
+	// An earlier application deadline takes precedence.
+	early := time.Now().Add(time.Second)
+	c.SetReadDeadline(early)
+	if !nc.readDeadline.Equal(early) {
+		t.Errorf("read deadline = %v, want %v", nc.readDeadline, early)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	// A pong extends the deadline.
+	time.Sleep(10 * time.Millisecond)
+	w := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	w.WriteControl(PongMessage, nil, time.Now().Add(time.Second))
+	w.WriteMessage(TextMessage, []byte("hello"))
+	if _, _, err := c.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if !nc.readDeadline.After(first) {
+		t.Errorf("read deadline %v not extended after pong, was %v", nc.readDeadline, first)
+	}
+}
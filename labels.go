@@ -0,0 +1,19 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.9
+
+package websocket
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// goLabeled runs f in a new goroutine tagged with pprof labels describing the
+// connection and the goroutine's task. The labels make goroutines owned by the
+// package attributable in CPU and goroutine profiles.
+func (c *Conn) goLabeled(task string, f func()) {
+	go pprof.Do(context.Background(), pprof.Labels(c.labels(task)...), func(context.Context) { f() })
+}
@@ -0,0 +1,13 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !go1.9
+
+package websocket
+
+// goLabeled runs f in a new goroutine. Profiler labels are not supported in
+// Go < 1.9.
+func (c *Conn) goLabeled(task string, f func()) {
+	go f()
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.9
+
+package websocket
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestGoLabeled(t *testing.T) {
+	c := newConn(fakeNetConn{}, true, 1024, 1024)
+	c.subprotocol = "chat"
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	c.goLabeled("test", func() {
+		close(started)
+		<-done
+	})
+	<-started
+
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	close(done)
+
+	for _, label := range []string{
+		`"websocket.task":"test"`,
+		`"websocket.role":"server"`,
+		`"websocket.remote":"str"`,
+		`"websocket.subprotocol":"chat"`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(label)) {
+			t.Errorf("goroutine profile does not contain label %s", label)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"runtime"
+	"sync/atomic"
+)
+
+var leakHandler atomic.Value // of leakHandlerFunc
+
+type leakHandlerFunc func(remoteAddr net.Addr, stack []byte)
+
+// SetLeakHandler sets a function to report connections that are garbage
+// collected without a call to Close. The function is called with the remote
+// address of the connection and the stack trace of the goroutine that created
+// the connection. A nil function disables leak detection.
+//
+// Leak detection records a stack trace for every new connection and should
+// only be enabled for debugging. Connections created before the call to
+// SetLeakHandler are not tracked.
+func SetLeakHandler(h func(remoteAddr net.Addr, stack []byte)) {
+	leakHandler.Store(leakHandlerFunc(h))
+}
+
+// leakTracker records the creation of a connection. The tracker does not
+// reference the connection so that the finalizer is not part of a reference
+// cycle.
+type leakTracker struct {
+	closed     int32
+	remoteAddr net.Addr
+	stack      []byte
+}
+
+func newLeakTracker(conn net.Conn) *leakTracker {
+	h, _ := leakHandler.Load().(leakHandlerFunc)
+	if h == nil {
+		return nil
+	}
+	lt := &leakTracker{stack: make([]byte, 4096)}
+	lt.stack = lt.stack[:runtime.Stack(lt.stack, false)]
+	if conn != nil {
+		lt.remoteAddr = conn.RemoteAddr()
+	}
+	runtime.SetFinalizer(lt, func(lt *leakTracker) {
+		if atomic.LoadInt32(&lt.closed) == 0 {
+			h(lt.remoteAddr, lt.stack)
+		}
+	})
+	return lt
+}
+
+func (lt *leakTracker) close() {
+	if lt != nil {
+		atomic.StoreInt32(&lt.closed, 1)
+	}
+}
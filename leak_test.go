@@ -0,0 +1,48 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newLeakTestConn() {
+	newConn(fakeNetConn{}, false, 1024, 1024)
+}
+
+func TestLeakHandler(t *testing.T) {
+	leaks := make(chan []byte, 10)
+	SetLeakHandler(func(remoteAddr net.Addr, stack []byte) {
+		leaks <- stack
+	})
+	defer SetLeakHandler(nil)
+
+	newConn(fakeNetConn{}, false, 1024, 1024).Close()
+	newLeakTestConn()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case stack := <-leaks:
+			if !bytes.Contains(stack, []byte("newLeakTestConn")) {
+				t.Errorf("stack does not contain creator:\n%s", stack)
+			}
+			select {
+			case <-leaks:
+				t.Error("closed connection reported as leak")
+			case <-time.After(100 * time.Millisecond):
+			}
+			return
+		case <-timeout:
+			t.Fatal("leak not reported")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
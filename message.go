@@ -0,0 +1,20 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+// Message is a data message read from or written to a connection.
+type Message struct {
+	// Type is TextMessage or BinaryMessage.
+	Type int
+
+	// Data is the message payload.
+	Data []byte
+}
+
+// isEndOfMessages reports whether err ends a stream of messages without an
+// error: the peer closed the connection normally or is going away.
+func isEndOfMessages(err error) bool {
+	return IsCloseError(err, CloseNormalClosure, CloseGoingAway)
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.23
+
+package websocket
+
+import (
+	"context"
+	"iter"
+)
+
+// Messages returns an iterator over the data messages read from the
+// connection:
+//
+//	for msg, err := range c.Messages(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The iteration ends without an error when the peer closes the connection
+// with the normal closure or going away code, or when ctx is done. Other
+// read errors are yielded once and end the iteration. The iterator is the
+// connection's reader and must not be used concurrently with other read
+// methods.
+func (c *Conn) Messages(ctx context.Context) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		for {
+			mt, p, err := c.ReadMessageContext(ctx)
+			if err != nil {
+				if ctx.Err() == nil && !isEndOfMessages(err) {
+					yield(Message{}, err)
+				}
+				return
+			}
+			if !yield(Message{Type: mt, Data: p}, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.23
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMessages(t *testing.T) {
+	var b bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &b}, false, 1024, 1024)
+	wc.WriteMessage(TextMessage, []byte("one"))
+	wc.WriteMessage(BinaryMessage, []byte("two"))
+	wc.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	rc := newConn(fakeNetConn{Reader: &b, Writer: &bytes.Buffer{}}, true, 1024, 1024)
+	var got []Message
+	for msg, err := range rc.Messages(context.Background()) {
+		if err != nil {
+			t.Fatalf("Messages yielded error %v", err)
+		}
+		got = append(got, msg)
+	}
+	if len(got) != 2 || got[0].Type != TextMessage || string(got[0].Data) != "one" ||
+		got[1].Type != BinaryMessage || string(got[1].Data) != "two" {
+		t.Errorf("Messages yielded %v", got)
+	}
+
+	// A connection that ends without a close message yields the error.
+	rc = newConn(fakeNetConn{Reader: &b}, true, 1024, 1024)
+	var errs int
+	for _, err := range rc.Messages(context.Background()) {
+		if err == nil {
+			t.Fatal("Messages yielded message, want error")
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("Messages yielded %d errors, want 1", errs)
+	}
+
+	// The iteration ends without an error when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for msg, err := range newConn(fakeNetConn{Reader: &b}, true, 1024, 1024).Messages(ctx) {
+		t.Errorf("Messages yielded %v, %v after cancel", msg, err)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"time"
+)
+
+// Metrics receives events from an Upgrader. Use Metrics to update counters
+// and histograms in a monitoring system. The methods are called
+// synchronously and must not block.
+//
+// The request passed to HandshakeAttempted and HandshakeFailed is nil if
+// UpgradeConn could not read the request.
+type Metrics interface {
+	// HandshakeAttempted is called when Upgrade or UpgradeConn receives a
+	// handshake request.
+	HandshakeAttempted(r *http.Request)
+
+	// HandshakeSucceeded is called when the handshake completes. The
+	// duration is the time spent in Upgrade or UpgradeConn.
+	HandshakeSucceeded(r *http.Request, d time.Duration)
+
+	// HandshakeFailed is called when the handshake fails. The error is the
+	// error returned from Upgrade or UpgradeConn. If the request is rejected
+	// with an HTTP error response, then the error is a HandshakeError with
+	// the response status code.
+	HandshakeFailed(r *http.Request, d time.Duration, err error)
+
+	// ConnOpened is called when the Upgrader takes over the network
+	// connection for c. ConnOpened is called before the handshake response
+	// is written; if writing the response fails, then the connection is
+	// closed.
+	ConnOpened(c *Conn)
+
+	// ConnClosed is called when c is closed with the Close method.
+	ConnClosed(c *Conn)
+}
+
+// MessageMetrics is an optional interface implemented by a Metrics to
+// receive the time spent handling messages. Use MessageMetrics to find slow
+// message handlers that delay reading from the connection.
+type MessageMetrics interface {
+	// MessageHandled is called by EventLoop when the OnMessage function
+	// returns. The duration is the time from when the message was read from
+	// the connection to when OnMessage returned. The error is the error
+	// returned from OnMessage.
+	MessageHandled(c *Conn, messageType int, d time.Duration, err error)
+}
+
+// handshakeDone reports the result of a handshake started at start.
+func (u *Upgrader) handshakeDone(r *http.Request, start time.Time, err error) {
+	if err != nil {
+		u.Metrics.HandshakeFailed(r, time.Since(start), err)
+	} else {
+		u.Metrics.HandshakeSucceeded(r, time.Since(start))
+	}
+}
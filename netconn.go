@@ -0,0 +1,86 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// NetConn returns a net.Conn that presents the data messages of the
+// connection as a byte stream. Use NetConn to layer a stream protocol such
+// as TLS, SSH or a multiplexer over a WebSocket connection.
+//
+// Each Write sends one message of the given type. Read returns the payload
+// of the received data messages in order, regardless of type. Read returns
+// io.EOF when the peer closes the connection with the normal closure or
+// going away code. Close sends a close message with the normal closure code
+// and closes the connection.
+//
+// The deadline methods set the deadlines of the connection. As with the
+// connection's read and write methods, the stream is corrupt after a read
+// or write times out.
+//
+// The application must not call the connection's read and write methods
+// while using the returned net.Conn. The WriteControl method is an exception.
+func (c *Conn) NetConn(messageType int) net.Conn {
+	return &netConn{c: c, messageType: messageType}
+}
+
+type netConn struct {
+	c           *Conn
+	messageType int
+	r           io.Reader
+}
+
+func (nc *netConn) Read(p []byte) (int, error) {
+	for {
+		if nc.r == nil {
+			var err error
+			_, nc.r, err = nc.c.NextReader()
+			if err != nil {
+				if isEndOfMessages(err) {
+					err = io.EOF
+				}
+				return 0, err
+			}
+		}
+		n, err := nc.r.Read(p)
+		if err == io.EOF {
+			// Continue with the next message.
+			nc.r = nil
+			err = nil
+		}
+		if n > 0 || err != nil || len(p) == 0 {
+			return n, err
+		}
+	}
+}
+
+func (nc *netConn) Write(p []byte) (int, error) {
+	if err := nc.c.WriteMessage(nc.messageType, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (nc *netConn) Close() error {
+	nc.c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(writeWait))
+	return nc.c.Close()
+}
+
+func (nc *netConn) LocalAddr() net.Addr  { return nc.c.LocalAddr() }
+func (nc *netConn) RemoteAddr() net.Addr { return nc.c.RemoteAddr() }
+
+func (nc *netConn) SetDeadline(t time.Time) error {
+	if err := nc.c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return nc.c.SetWriteDeadline(t)
+}
+
+func (nc *netConn) SetReadDeadline(t time.Time) error  { return nc.c.SetReadDeadline(t) }
+func (nc *netConn) SetWriteDeadline(t time.Time) error { return nc.c.SetWriteDeadline(t) }
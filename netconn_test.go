@@ -0,0 +1,80 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNetConnTLS(t *testing.T) {
+	// Use the certificate of a TLS test server for the inner TLS session.
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certificates := certServer.TLS.Certificates
+	certServer.Close()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		tc := tls.Server(ws.NetConn(BinaryMessage), &tls.Config{Certificates: certificates})
+		defer tc.Close()
+		io.Copy(tc, tc)
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	nc := ws.NetConn(BinaryMessage)
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	tc := tls.Client(nc, &tls.Config{InsecureSkipVerify: true})
+	defer tc.Close()
+
+	br := bufio.NewReader(tc)
+	for _, line := range []string{"hello\n", "world\n"} {
+		if _, err := io.WriteString(tc, line); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		got, err := br.ReadString('\n')
+		if err != nil || got != line {
+			t.Fatalf("ReadString returned %q, %v, want %q", got, err, line)
+		}
+	}
+}
+
+func TestNetConnEOF(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		nc := ws.NetConn(TextMessage)
+		io.WriteString(nc, "one")
+		io.WriteString(nc, "two")
+		nc.Close()
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	nc := ws.NetConn(TextMessage)
+	nc.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := ioutil.ReadAll(nc)
+	if err != nil || string(b) != "onetwo" {
+		t.Errorf("ReadAll returned %q, %v, want onetwo", b, err)
+	}
+}
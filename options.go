@@ -0,0 +1,236 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DialOption configures a Dialer created with NewDialer.
+type DialOption interface {
+	applyDialer(d *Dialer) error
+}
+
+// UpgradeOption configures an Upgrader created with NewUpgrader.
+type UpgradeOption interface {
+	applyUpgrader(u *Upgrader) error
+}
+
+// Option configures both a Dialer and an Upgrader.
+type Option interface {
+	DialOption
+	UpgradeOption
+}
+
+type dialOption func(d *Dialer) error
+
+func (f dialOption) applyDialer(d *Dialer) error { return f(d) }
+
+type upgradeOption func(u *Upgrader) error
+
+func (f upgradeOption) applyUpgrader(u *Upgrader) error { return f(u) }
+
+type option struct {
+	dial    dialOption
+	upgrade upgradeOption
+}
+
+func (o option) applyDialer(d *Dialer) error     { return o.dial(d) }
+func (o option) applyUpgrader(u *Upgrader) error { return o.upgrade(u) }
+
+// NewDialer returns a Dialer configured with the options. The options are
+// applied in order to a copy of DefaultDialer. NewDialer returns an error if
+// an option is not valid.
+func NewDialer(opts ...DialOption) (*Dialer, error) {
+	d := new(Dialer)
+	*d = *DefaultDialer
+	for _, opt := range opts {
+		if err := opt.applyDialer(d); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// NewUpgrader returns an Upgrader configured with the options. The options
+// are applied in order to an Upgrader with the zero value for all fields.
+// NewUpgrader returns an error if an option is not valid.
+func NewUpgrader(opts ...UpgradeOption) (*Upgrader, error) {
+	u := &Upgrader{}
+	for _, opt := range opts {
+		if err := opt.applyUpgrader(u); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func isToken(s string) bool {
+	t, rest := nextToken(s)
+	return t != "" && rest == ""
+}
+
+// WithSubprotocols sets the subprotocols in order of preference. Each
+// subprotocol must be a valid HTTP token.
+func WithSubprotocols(protocols ...string) Option {
+	validate := func() error {
+		for _, p := range protocols {
+			if !isToken(p) {
+				return errors.New("websocket: invalid subprotocol " + p)
+			}
+		}
+		return nil
+	}
+	return option{
+		dial: func(d *Dialer) error {
+			d.Subprotocols = protocols
+			return validate()
+		},
+		upgrade: func(u *Upgrader) error {
+			u.Subprotocols = protocols
+			return validate()
+		},
+	}
+}
+
+// WithCompression sets whether the Dialer or Upgrader negotiates per message
+// compression.
+func WithCompression(enable bool) Option {
+	return option{
+		dial: func(d *Dialer) error {
+			d.EnableCompression = enable
+			return nil
+		},
+		upgrade: func(u *Upgrader) error {
+			u.EnableCompression = enable
+			return nil
+		},
+	}
+}
+
+// WithCompressionParams enables compression and sets the permessage-deflate
+// parameters. The parameters are copied.
+func WithCompressionParams(params CompressionParams) Option {
+	return option{
+		dial: func(d *Dialer) error {
+			if err := params.validate(); err != nil {
+				return err
+			}
+			d.EnableCompression = true
+			d.CompressionParams = &params
+			return nil
+		},
+		upgrade: func(u *Upgrader) error {
+			if err := params.validate(); err != nil {
+				return err
+			}
+			u.EnableCompression = true
+			u.CompressionParams = &params
+			return nil
+		},
+	}
+}
+
+// WithBufferSizes sets the read and write buffer sizes. The sizes must not be
+// negative. A size of zero selects the default size.
+func WithBufferSizes(readBufferSize, writeBufferSize int) Option {
+	validate := func() error {
+		if readBufferSize < 0 || writeBufferSize < 0 {
+			return errors.New("websocket: negative buffer size")
+		}
+		return nil
+	}
+	return option{
+		dial: func(d *Dialer) error {
+			d.ReadBufferSize, d.WriteBufferSize = readBufferSize, writeBufferSize
+			return validate()
+		},
+		upgrade: func(u *Upgrader) error {
+			u.ReadBufferSize, u.WriteBufferSize = readBufferSize, writeBufferSize
+			return validate()
+		},
+	}
+}
+
+// WithHandshakeTimeout sets the duration for the opening handshake to
+// complete. The timeout must not be negative.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	validate := func() error {
+		if timeout < 0 {
+			return errors.New("websocket: negative handshake timeout")
+		}
+		return nil
+	}
+	return option{
+		dial: func(d *Dialer) error {
+			d.HandshakeTimeout = timeout
+			return validate()
+		},
+		upgrade: func(u *Upgrader) error {
+			u.HandshakeTimeout = timeout
+			return validate()
+		},
+	}
+}
+
+// WithNetDial sets the function for creating network connections.
+func WithNetDial(dial func(network, addr string) (net.Conn, error)) DialOption {
+	return dialOption(func(d *Dialer) error {
+		if dial == nil {
+			return errors.New("websocket: nil dial function")
+		}
+		d.NetDial = dial
+		return nil
+	})
+}
+
+// WithProxy sets the function to return a proxy for a handshake request.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) DialOption {
+	return dialOption(func(d *Dialer) error {
+		d.Proxy = proxy
+		return nil
+	})
+}
+
+// WithTLSClientConfig sets the TLS configuration for wss URLs. The
+// configuration is not copied.
+func WithTLSClientConfig(config *tls.Config) DialOption {
+	return dialOption(func(d *Dialer) error {
+		d.TLSClientConfig = config
+		return nil
+	})
+}
+
+// WithJar sets the cookie jar for the opening handshake.
+func WithJar(jar http.CookieJar) DialOption {
+	return dialOption(func(d *Dialer) error {
+		d.Jar = jar
+		return nil
+	})
+}
+
+// WithCheckOrigin sets the function to check the request origin.
+func WithCheckOrigin(checkOrigin func(r *http.Request) bool) UpgradeOption {
+	return upgradeOption(func(u *Upgrader) error {
+		if checkOrigin == nil {
+			return errors.New("websocket: nil origin check function")
+		}
+		u.CheckOrigin = checkOrigin
+		return nil
+	})
+}
+
+// WithErrorHandler sets the function for generating HTTP error responses.
+func WithErrorHandler(handler func(w http.ResponseWriter, r *http.Request, status int, reason error)) UpgradeOption {
+	return upgradeOption(func(u *Upgrader) error {
+		u.Error = handler
+		return nil
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewDialerOptions(t *testing.T) {
+	d, err := NewDialer(
+		WithSubprotocols("p1", "p2"),
+		WithCompression(true),
+		WithBufferSizes(1024, 2048),
+		WithHandshakeTimeout(time.Second),
+		WithProxy(http.ProxyFromEnvironment),
+	)
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if !reflect.DeepEqual(d.Subprotocols, []string{"p1", "p2"}) ||
+		!d.EnableCompression ||
+		d.ReadBufferSize != 1024 || d.WriteBufferSize != 2048 ||
+		d.HandshakeTimeout != time.Second ||
+		d.Proxy == nil {
+		t.Errorf("NewDialer returned %+v", d)
+	}
+}
+
+func TestNewDialerDefaults(t *testing.T) {
+	d, err := NewDialer()
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if d.HandshakeTimeout != DefaultDialer.HandshakeTimeout || d.Proxy == nil {
+		t.Errorf("NewDialer returned %+v, want copy of DefaultDialer", d)
+	}
+	if d == DefaultDialer {
+		t.Errorf("NewDialer returned DefaultDialer")
+	}
+}
+
+func TestWithCompressionParams(t *testing.T) {
+	params := CompressionParams{ClientNoContextTakeover: true, ClientMaxWindowBits: 10}
+	d, err := NewDialer(WithCompressionParams(params))
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+	if !d.EnableCompression || d.CompressionParams == nil || *d.CompressionParams != params {
+		t.Errorf("NewDialer returned %+v", d)
+	}
+	if _, err := NewDialer(WithCompressionParams(CompressionParams{ServerMaxWindowBits: 7})); err == nil {
+		t.Errorf("NewDialer with invalid window bits returned nil error")
+	}
+}
+
+func TestNewUpgraderOptions(t *testing.T) {
+	u, err := NewUpgrader(
+		WithSubprotocols("p1"),
+		WithCompression(true),
+		WithBufferSizes(1024, 2048),
+		WithCheckOrigin(func(r *http.Request) bool { return true }),
+	)
+	if err != nil {
+		t.Fatalf("NewUpgrader: %v", err)
+	}
+	if !reflect.DeepEqual(u.Subprotocols, []string{"p1"}) ||
+		!u.EnableCompression ||
+		u.ReadBufferSize != 1024 || u.WriteBufferSize != 2048 ||
+		u.CheckOrigin == nil {
+		t.Errorf("NewUpgrader returned %+v", u)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opt := range []Option{
+		WithSubprotocols("p1", "bad protocol"),
+		WithSubprotocols(""),
+		WithBufferSizes(-1, 0),
+		WithHandshakeTimeout(-time.Second),
+	} {
+		if _, err := NewDialer(opt); err == nil {
+			t.Errorf("NewDialer(%#v) returned nil error", opt)
+		}
+		if _, err := NewUpgrader(opt); err == nil {
+			t.Errorf("NewUpgrader(%#v) returned nil error", opt)
+		}
+	}
+	if _, err := NewDialer(WithNetDial(nil)); err == nil {
+		t.Errorf("NewDialer(WithNetDial(nil)) returned nil error")
+	}
+	if _, err := NewUpgrader(WithCheckOrigin(nil)); err == nil {
+		t.Errorf("NewUpgrader(WithCheckOrigin(nil)) returned nil error")
+	}
+}
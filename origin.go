@@ -0,0 +1,201 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The functions in this file return functions for the Upgrader CheckOrigin
+// field. The functions accept requests without an Origin header because
+// browsers send the header with every WebSocket handshake. Requests without
+// the header are from clients that are not subject to cross-site request
+// forgery.
+
+// origin is a parsed and normalized web origin.
+type origin struct {
+	scheme string
+	host   string
+	port   string
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// parseOrigin parses an origin in the form scheme://host[:port]. The scheme
+// and host are converted to lower case and the port is set to the default
+// port for the scheme if not specified.
+func parseOrigin(s string) (origin, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return origin{}, false
+	}
+	o := origin{
+		scheme: strings.ToLower(u.Scheme),
+		host:   strings.TrimSuffix(strings.ToLower(u.Hostname()), "."),
+		port:   u.Port(),
+	}
+	if o.host == "" {
+		return origin{}, false
+	}
+	if o.port == "" {
+		o.port = defaultPort(o.scheme)
+	}
+	return o, true
+}
+
+// requestOrigin returns the parsed Origin header and whether the header is
+// present.
+func requestOrigin(r *http.Request) (o origin, present, ok bool) {
+	h := r.Header["Origin"]
+	if len(h) == 0 {
+		return origin{}, false, true
+	}
+	o, ok = parseOrigin(h[0])
+	return o, true, ok
+}
+
+// SameOriginOnly returns a CheckOrigin function that accepts requests where
+// the Origin host and port are equal to the request Host. The port in the
+// Origin header defaults to the default port for the origin's scheme. If the
+// request Host does not specify a port, then the origin must use the default
+// port. The scheme is not compared because the request scheme is not known
+// to a server behind a TLS terminating proxy.
+func SameOriginOnly() func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		o, present, ok := requestOrigin(r)
+		if !present {
+			return true
+		}
+		if !ok {
+			return false
+		}
+		host, port := r.Host, ""
+		if h, p, err := net.SplitHostPort(r.Host); err == nil {
+			host, port = h, p
+		}
+		host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+		if port == "" {
+			port = defaultPort(o.scheme)
+		}
+		return o.host == host && o.port == port
+	}
+}
+
+// originPattern is a parsed OriginAllowlist pattern.
+type originPattern struct {
+	scheme   string // empty for http and https
+	host     string // suffix with leading dot if wildcard
+	wildcard bool
+	port     string // empty for the default port of the origin's scheme
+}
+
+func parseOriginPattern(pattern string) (originPattern, bool) {
+	s := pattern
+	hasScheme := strings.Contains(s, "://")
+	if !hasScheme {
+		s = "http://" + s
+	}
+	wildcard := false
+	if i := strings.Index(s, "://*."); i >= 0 {
+		wildcard = true
+		s = s[:i+3] + s[i+5:]
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return originPattern{}, false
+	}
+	p := originPattern{
+		host:     strings.TrimSuffix(strings.ToLower(u.Hostname()), "."),
+		wildcard: wildcard,
+		port:     u.Port(),
+	}
+	if p.host == "" || strings.Contains(p.host, "*") {
+		return originPattern{}, false
+	}
+	if hasScheme {
+		p.scheme = strings.ToLower(u.Scheme)
+	}
+	if wildcard {
+		p.host = "." + p.host
+	}
+	return p, true
+}
+
+func (p originPattern) match(o origin) bool {
+	if p.scheme == "" {
+		if o.scheme != "http" && o.scheme != "https" {
+			return false
+		}
+	} else if p.scheme != o.scheme {
+		return false
+	}
+	if p.port == "" {
+		if o.port != defaultPort(o.scheme) {
+			return false
+		}
+	} else if p.port != o.port {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(o.host, p.host)
+	}
+	return o.host == p.host
+}
+
+// OriginAllowlist returns a CheckOrigin function that accepts requests with
+// an origin matching one of the patterns.
+//
+// A pattern has the form [scheme://]host[:port]. A pattern without a scheme
+// matches the http and https schemes. A pattern without a port matches the
+// default port for the origin's scheme. A host starting with "*." matches
+// all subdomains of the remaining domain, but not the domain itself. Hosts
+// and schemes are compared without regard to case.
+//
+// OriginAllowlist panics if a pattern is not valid.
+func OriginAllowlist(patterns ...string) func(r *http.Request) bool {
+	ps := make([]originPattern, len(patterns))
+	for i, pattern := range patterns {
+		p, ok := parseOriginPattern(pattern)
+		if !ok {
+			panic("websocket: invalid origin pattern " + pattern)
+		}
+		ps[i] = p
+	}
+	return func(r *http.Request) bool {
+		o, present, ok := requestOrigin(r)
+		if !present {
+			return true
+		}
+		if !ok {
+			return false
+		}
+		for _, p := range ps {
+			if p.match(o) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowSubdomainsOf returns a CheckOrigin function that accepts requests
+// with an http or https origin on the default port where the host is domain
+// or a subdomain of domain.
+func AllowSubdomainsOf(domain string) func(r *http.Request) bool {
+	return OriginAllowlist(domain, "*."+domain)
+}
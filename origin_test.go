@@ -0,0 +1,74 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"testing"
+)
+
+var originPolicyTests = []struct {
+	name   string
+	check  func(r *http.Request) bool
+	host   string
+	origin string
+	ok     bool
+}{
+	{"same/none", SameOriginOnly(), "example.com", "", true},
+	{"same/equal", SameOriginOnly(), "example.com", "https://example.com", true},
+	{"same/case", SameOriginOnly(), "Example.COM", "https://EXAMPLE.com", true},
+	{"same/port", SameOriginOnly(), "example.com:8080", "http://example.com:8080", true},
+	{"same/default port", SameOriginOnly(), "example.com:443", "https://example.com", true},
+	{"same/other port", SameOriginOnly(), "example.com", "http://example.com:8080", false},
+	{"same/other host", SameOriginOnly(), "example.com", "https://example.org", false},
+	{"same/subdomain", SameOriginOnly(), "example.com", "https://www.example.com", false},
+	{"same/null", SameOriginOnly(), "example.com", "null", false},
+	{"same/ipv6", SameOriginOnly(), "[::1]:8080", "http://[::1]:8080", true},
+
+	{"allow/equal", OriginAllowlist("example.com"), "api.example.com", "https://example.com", true},
+	{"allow/http", OriginAllowlist("example.com"), "api.example.com", "http://example.com", true},
+	{"allow/port", OriginAllowlist("example.com"), "api.example.com", "https://example.com:8443", false},
+	{"allow/pattern port", OriginAllowlist("example.com:8443"), "api.example.com", "https://example.com:8443", true},
+	{"allow/scheme", OriginAllowlist("https://example.com"), "api.example.com", "http://example.com", false},
+	{"allow/scheme default port", OriginAllowlist("https://example.com"), "api.example.com", "https://example.com:443", true},
+	{"allow/trailing dot", OriginAllowlist("example.com"), "api.example.com", "https://example.com.", true},
+	{"allow/wildcard", OriginAllowlist("*.example.com"), "api.example.com", "https://a.b.example.com", true},
+	{"allow/wildcard domain", OriginAllowlist("*.example.com"), "api.example.com", "https://example.com", false},
+	{"allow/wildcard suffix", OriginAllowlist("*.example.com"), "api.example.com", "https://badexample.com", false},
+	{"allow/other scheme", OriginAllowlist("example.com"), "api.example.com", "file://example.com", false},
+	{"allow/path", OriginAllowlist("example.com"), "api.example.com", "https://example.com/path", false},
+	{"allow/userinfo", OriginAllowlist("example.com"), "api.example.com", "https://example.com@evil.com", false},
+	{"allow/second", OriginAllowlist("example.org", "example.com"), "api.example.com", "https://example.com", true},
+
+	{"subdomains/domain", AllowSubdomainsOf("example.com"), "api.example.com", "https://example.com", true},
+	{"subdomains/sub", AllowSubdomainsOf("example.com"), "api.example.com", "https://www.example.com", true},
+	{"subdomains/suffix", AllowSubdomainsOf("example.com"), "api.example.com", "https://evilexample.com", false},
+	{"subdomains/parent", AllowSubdomainsOf("example.com"), "api.example.com", "https://example.com.evil.com", false},
+}
+
+func TestOriginPolicies(t *testing.T) {
+	for _, tt := range originPolicyTests {
+		r := &http.Request{Host: tt.host, Header: http.Header{}}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if ok := tt.check(r); ok != tt.ok {
+			t.Errorf("%s: check(host=%q, origin=%q) = %v, want %v", tt.name, tt.host, tt.origin, ok, tt.ok)
+		}
+	}
+}
+
+func TestOriginAllowlistInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "https://", "example.com/path", "a.*.example.com", "user@example.com"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("OriginAllowlist(%q) did not panic", pattern)
+				}
+			}()
+			OriginAllowlist(pattern)
+		}()
+	}
+}
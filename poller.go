@@ -0,0 +1,59 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import "errors"
+
+var (
+	errPollerUnsupported = errors.New("websocket: poller not supported on this platform")
+	errPollerConn        = errors.New("websocket: connection does not support polling")
+	errPollerClosed      = errors.New("websocket: poller closed")
+)
+
+// A Poller parks idle connections in an operating system readiness
+// notifier and calls an application function when data arrives. Use a
+// Poller to serve a large number of mostly idle connections without
+// dedicating a goroutine to each connection.
+//
+// Create a Poller with NewPoller and add connections with Add. A Poller is
+// supported on Linux only. The network connection must implement
+// syscall.Conn; TLS connections are not supported.
+//
+// When data is available to read on a connection, the Poller calls the
+// function passed to Add in a new goroutine. The function typically reads a
+// single message with NextReader or ReadMessage. After the function returns,
+// the connection is parked again unless the function closed the connection.
+// The Poller calls the function for at most one readiness event at a time
+// per connection, so the function is the connection's only reader.
+//
+// Reads in the function block until a complete message arrives. Set a read
+// deadline to bound the time a slow peer holds the goroutine.
+type Poller struct {
+	poller
+}
+
+// NewPoller returns a new Poller and starts its event loop.
+func NewPoller() (*Poller, error) {
+	p := &Poller{}
+	if err := p.init(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Add parks the connection in the poller. The function f is called with c
+// each time data is available to read. The connection is removed from the
+// poller when the connection is closed.
+//
+// The application must not read from c after calling Add except in f.
+func (p *Poller) Add(c *Conn, f func(c *Conn)) error {
+	return p.add(c, f)
+}
+
+// Close stops the poller. Connections in the poller are not closed. After
+// Close returns, f is not called for new readiness events.
+func (p *Poller) Close() error {
+	return p.close()
+}
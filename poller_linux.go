@@ -0,0 +1,187 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// poller is an epoll event loop.
+type poller struct {
+	epfd   int
+	wake   [2]int // pipe used to stop the event loop
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+	conns  map[int]*pollConn
+}
+
+type pollConn struct {
+	c   *Conn
+	f   func(*Conn)
+	fd  int
+	reg bool // fd is in the epoll set, protected by poller.mu
+}
+
+func (p *poller) init() error {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	p.epfd = epfd
+	p.done = make(chan struct{})
+	p.conns = make(map[int]*pollConn)
+	if err := syscall.Pipe2(p.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epfd)
+		return err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p.wake[0], &ev); err != nil {
+		p.closeFDs()
+		return err
+	}
+	go p.loop()
+	return nil
+}
+
+func (p *poller) add(c *Conn, f func(c *Conn)) error {
+	fd, err := connFD(c.conn)
+	if err != nil {
+		return err
+	}
+	pc := &pollConn{c: c, f: f, fd: fd}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errPollerClosed
+	}
+	p.conns[fd] = pc
+	p.mu.Unlock()
+	c.addCloseHook(func() { p.remove(pc) })
+	if err := p.arm(pc); err != nil {
+		p.remove(pc)
+		return err
+	}
+	return nil
+}
+
+func (p *poller) close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+	syscall.Write(p.wake[1], []byte{0})
+	<-p.done
+	return p.closeFDs()
+}
+
+func (p *poller) closeFDs() error {
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+	return syscall.Close(p.epfd)
+}
+
+// arm waits for the next readiness event on the connection. Data buffered
+// by the connection is dispatched immediately because the peer may not send
+// more data.
+func (p *poller) arm(pc *pollConn) error {
+	if pc.c.br.Buffered() > 0 {
+		go p.dispatch(pc)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.conns[pc.fd] != pc {
+		return nil
+	}
+	op := syscall.EPOLL_CTL_MOD
+	if !pc.reg {
+		op = syscall.EPOLL_CTL_ADD
+		pc.reg = true
+	}
+	ev := syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(pc.fd),
+	}
+	return syscall.EpollCtl(p.epfd, op, pc.fd, &ev)
+}
+
+func (p *poller) dispatch(pc *pollConn) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return
+	}
+	pc.f(pc.c)
+	if atomic.LoadInt32(&pc.c.closed) != 0 {
+		return
+	}
+	if err := p.arm(pc); err != nil {
+		pc.c.Close()
+	}
+}
+
+// remove deletes the connection from the poller. Close calls remove before
+// closing the network connection, so the file descriptor is still valid.
+func (p *poller) remove(pc *pollConn) {
+	p.mu.Lock()
+	if p.conns[pc.fd] == pc {
+		delete(p.conns, pc.fd)
+		if pc.reg && !p.closed {
+			syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, pc.fd, &syscall.EpollEvent{})
+		}
+	}
+	p.mu.Unlock()
+}
+
+func (p *poller) loop() {
+	defer close(p.done)
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == p.wake[0] {
+				return
+			}
+			p.mu.Lock()
+			pc := p.conns[fd]
+			p.mu.Unlock()
+			if pc != nil {
+				go p.dispatch(pc)
+			}
+		}
+	}
+}
+
+// connFD returns the file descriptor of a network connection.
+func connFD(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, errPollerConn
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := rc.Control(func(s uintptr) { fd = int(s) }); err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPoller(t *testing.T) {
+	p, err := NewPoller()
+	if err != nil {
+		t.Fatalf("NewPoller: %v", err)
+	}
+	defer p.Close()
+
+	closed := make(chan struct{})
+	var upgrader Upgrader
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// Echo messages from the poller. The handler returns immediately.
+		err = p.Add(c, func(c *Conn) {
+			mt, b, err := c.ReadMessage()
+			if err != nil {
+				c.Close()
+				close(closed)
+				return
+			}
+			c.WriteMessage(mt, b)
+		})
+		if err != nil {
+			t.Errorf("Add: %v", err)
+			c.Close()
+		}
+	}))
+	defer s.Close()
+
+	c, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+
+	// Write two messages at once so that the second message is buffered
+	// by the server connection when the first is read.
+	c.WriteMessage(TextMessage, []byte("one"))
+	c.WriteMessage(TextMessage, []byte("two"))
+	for _, want := range []string{"one", "two"} {
+		if _, b, err := c.ReadMessage(); err != nil || string(b) != want {
+			t.Fatalf("ReadMessage returned %q, %v, want %q", b, err, want)
+		}
+	}
+
+	// Write a message after the connection is parked again.
+	c.WriteMessage(TextMessage, []byte("three"))
+	if _, b, err := c.ReadMessage(); err != nil || string(b) != "three" {
+		t.Fatalf("ReadMessage returned %q, %v, want three", b, err)
+	}
+
+	c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("read error not dispatched")
+	}
+}
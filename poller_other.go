@@ -0,0 +1,14 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package websocket
+
+type poller struct{}
+
+func (p *poller) init() error                        { return errPollerUnsupported }
+func (p *poller) add(c *Conn, f func(c *Conn)) error { return errPollerUnsupported }
+func (p *poller) close() error                       { return nil }
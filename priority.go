@@ -0,0 +1,222 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"sync"
+)
+
+// Message priorities for PriorityWriter. Lower values are written first.
+const (
+	PriorityHigh   = 0
+	PriorityNormal = 1
+	PriorityLow    = 2
+
+	numPriorities = 3
+)
+
+const (
+	defaultPriorityQueueSize = 64
+	defaultPriorityMaxBurst  = 16
+)
+
+var (
+	errPriorityWriterClosed = errors.New("websocket: priority writer closed")
+	errBadPriority          = errors.New("websocket: bad message priority")
+)
+
+// PriorityWriter queues messages for a connection and writes the messages
+// from a single goroutine in priority order. High priority messages, such as
+// control plane updates, are written ahead of queued low priority bulk
+// messages. Messages with the same priority are written in the order queued.
+//
+// To prevent starvation, a queued lower priority message is written after
+// MaxBurst consecutive higher priority messages.
+//
+// The application must not call the connection's write methods while a
+// PriorityWriter is running. The WriteControl method is an exception.
+type PriorityWriter struct {
+	// QueueSize specifies the maximum number of queued messages for each
+	// priority. WriteMessage blocks while the queue for the message priority
+	// is full. If zero, then a default size of 64 is used.
+	QueueSize int
+
+	// MaxBurst specifies the maximum number of consecutive messages written
+	// while a lower priority message is queued. If zero, then a default of
+	// 16 is used.
+	MaxBurst int
+
+	c *Conn
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  [numPriorities][]queuedMessage
+	burst   [numPriorities]int // consecutive writes ahead of queued priority
+	writing bool
+	closed  bool
+	err     error
+	done    chan struct{}
+	started bool
+}
+
+type queuedMessage struct {
+	messageType int
+	data        []byte
+}
+
+// NewPriorityWriter returns a priority writer for the connection. The writer
+// goroutine is started on the first call to WriteMessage.
+func NewPriorityWriter(c *Conn) *PriorityWriter {
+	w := &PriorityWriter{c: c, done: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+func (w *PriorityWriter) queueSize() int {
+	if w.QueueSize <= 0 {
+		return defaultPriorityQueueSize
+	}
+	return w.QueueSize
+}
+
+func (w *PriorityWriter) maxBurst() int {
+	if w.MaxBurst <= 0 {
+		return defaultPriorityMaxBurst
+	}
+	return w.MaxBurst
+}
+
+// WriteMessage queues a message with the given priority. The data slice must
+// not be modified after the call. WriteMessage returns the error that stopped
+// the writer, if any.
+func (w *PriorityWriter) WriteMessage(priority int, messageType int, data []byte) error {
+	if priority < 0 || priority >= numPriorities {
+		return errBadPriority
+	}
+	if !isData(messageType) && !isControl(messageType) {
+		return errBadWriteOpCode
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.started {
+		w.started = true
+		w.c.goLabeled("priority writer", w.run)
+	}
+	for w.err == nil && !w.closed && len(w.queues[priority]) >= w.queueSize() {
+		w.cond.Wait()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errPriorityWriterClosed
+	}
+	w.queues[priority] = append(w.queues[priority], queuedMessage{messageType, data})
+	w.cond.Broadcast()
+	return nil
+}
+
+// next removes and returns the next message to write. The caller must hold
+// w.mu and at least one queue must not be empty.
+func (w *PriorityWriter) next() queuedMessage {
+	selected := -1
+	for p := range w.queues {
+		if len(w.queues[p]) == 0 {
+			continue
+		}
+		if selected < 0 {
+			selected = p
+		}
+		if w.burst[p] >= w.maxBurst() {
+			// Lower priority message waited for too long.
+			selected = p
+			break
+		}
+	}
+	for p := range w.queues {
+		switch {
+		case p == selected || len(w.queues[p]) == 0:
+			w.burst[p] = 0
+		case p > selected:
+			w.burst[p]++
+		}
+	}
+	m := w.queues[selected][0]
+	w.queues[selected][0] = queuedMessage{}
+	w.queues[selected] = w.queues[selected][1:]
+	return m
+}
+
+func (w *PriorityWriter) empty() bool {
+	for _, q := range w.queues {
+		if len(q) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *PriorityWriter) run() {
+	defer close(w.done)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for w.empty() && !w.closed {
+			w.cond.Wait()
+		}
+		if w.empty() {
+			return
+		}
+		m := w.next()
+		w.writing = true
+		w.cond.Broadcast()
+		w.mu.Unlock()
+		err := w.c.WriteMessage(m.messageType, m.data)
+		w.mu.Lock()
+		w.writing = false
+		if err != nil {
+			w.err = err
+			for p := range w.queues {
+				w.queues[p] = nil
+			}
+			w.cond.Broadcast()
+			return
+		}
+		w.cond.Broadcast()
+	}
+}
+
+// Flush waits for all queued messages to be written and flushes the
+// connection.
+func (w *PriorityWriter) Flush() error {
+	w.mu.Lock()
+	for w.err == nil && (!w.empty() || w.writing) {
+		w.cond.Wait()
+	}
+	err := w.err
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return w.c.Flush()
+}
+
+// Close writes the queued messages and stops the writer goroutine. Close does
+// not close the connection.
+func (w *PriorityWriter) Close() error {
+	w.mu.Lock()
+	started := w.started
+	w.started = true
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	if started {
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
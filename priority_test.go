@@ -0,0 +1,62 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPriorityWriterOrder(t *testing.T) {
+	w := NewPriorityWriter(nil)
+	w.MaxBurst = 2
+	for i := 0; i < 3; i++ {
+		w.queues[PriorityLow] = append(w.queues[PriorityLow], queuedMessage{TextMessage, []byte("l" + strconv.Itoa(i))})
+	}
+	for i := 0; i < 5; i++ {
+		w.queues[PriorityHigh] = append(w.queues[PriorityHigh], queuedMessage{TextMessage, []byte("h" + strconv.Itoa(i))})
+	}
+	var got []string
+	for !w.empty() {
+		got = append(got, string(w.next().data))
+	}
+	want := "h0 h1 l0 h2 h3 l1 h4 l2"
+	if s := strings.Join(got, " "); s != want {
+		t.Errorf("order = %s, want %s", s, want)
+	}
+}
+
+func TestPriorityWriter(t *testing.T) {
+	var buf bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &buf}, false, 1024, 1024)
+
+	w := NewPriorityWriter(wc)
+	for i := 0; i < 10; i++ {
+		if err := w.WriteMessage(PriorityNormal, TextMessage, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.WriteMessage(PriorityNormal, TextMessage, nil); err != errPriorityWriterClosed {
+		t.Fatalf("WriteMessage after Close returned %v, want %v", err, errPriorityWriterClosed)
+	}
+	for i := 0; i < 10; i++ {
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if string(p) != strconv.Itoa(i) {
+			t.Fatalf("ReadMessage returned %s, want %d", p, i)
+		}
+	}
+}
@@ -6,8 +6,10 @@ package websocket
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -22,31 +24,95 @@ func (fn netDialerFunc) Dial(network, addr string) (net.Conn, error) {
 
 func init() {
 	proxy_RegisterDialerType("http", func(proxyURL *url.URL, forwardDialer proxy_Dialer) (proxy_Dialer, error) {
-		return &httpProxyDialer{proxyURL: proxyURL, fowardDial: forwardDialer.Dial}, nil
+		return &httpProxyDialer{proxyURL: proxyURL, fowardDial: forwardDialer.Dial, maxHeaderBytes: defaultMaxResponseHeaderBytes}, nil
 	})
 }
 
+// maxProxyAuthAttempts is the maximum number of CONNECT requests sent with
+// credentials from the Dialer.ProxyAuth function.
+const maxProxyAuthAttempts = 4
+
 type httpProxyDialer struct {
 	proxyURL   *url.URL
 	fowardDial func(network, addr string) (net.Conn, error)
+	tlsConfig  *tls.Config // non-nil for https proxies
+	auth       func(proxyURL *url.URL, resp *http.Response) (string, error)
+
+	// maxHeaderBytes limits the size of the CONNECT response header. There
+	// is no limit if maxHeaderBytes is not positive.
+	maxHeaderBytes int64
 }
 
 func (hpd *httpProxyDialer) Dial(network string, addr string) (net.Conn, error) {
-	hostPort, _ := hostPortNoPort(hpd.proxyURL)
-	conn, err := hpd.fowardDial(network, hostPort)
-	if err != nil {
-		return nil, err
-	}
-
-	connectHeader := make(http.Header)
+	var proxyAuth string
 	if user := hpd.proxyURL.User; user != nil {
 		proxyUser := user.Username()
 		if proxyPassword, passwordSet := user.Password(); passwordSet {
 			credential := base64.StdEncoding.EncodeToString([]byte(proxyUser + ":" + proxyPassword))
-			connectHeader.Set("Proxy-Authorization", "Basic "+credential)
+			proxyAuth = "Basic " + credential
 		}
 	}
 
+	for attempt := 0; ; attempt++ {
+		conn, resp, err := hpd.connect(network, addr, proxyAuth)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == 200 {
+			return conn, nil
+		}
+		conn.Close()
+
+		if resp.StatusCode == http.StatusProxyAuthRequired && hpd.auth != nil && attempt < maxProxyAuthAttempts {
+			proxyAuth, err = hpd.auth(hpd.proxyURL, resp)
+			if err != nil {
+				return nil, err
+			}
+			if proxyAuth != "" {
+				// Retry on a new connection. Proxies commonly close the
+				// connection after a 407 response.
+				continue
+			}
+		}
+
+		f := strings.SplitN(resp.Status, " ", 2)
+		return nil, errors.New(f[1])
+	}
+}
+
+// connect connects to the proxy and sends a CONNECT request for addr. The
+// caller must close the connection if the response status is not 200.
+func (hpd *httpProxyDialer) connect(network, addr, proxyAuth string) (net.Conn, *http.Response, error) {
+	hostPort, hostNoPort := hostPortNoPort(hpd.proxyURL)
+	conn, err := hpd.fowardDial(network, hostPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if hpd.tlsConfig != nil {
+		cfg := cloneTLSConfig(hpd.tlsConfig)
+		if cfg.ServerName == "" {
+			cfg.ServerName = hostNoPort
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if !cfg.InsecureSkipVerify {
+			if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
+				conn.Close()
+				return nil, nil, err
+			}
+		}
+		conn = tlsConn
+	}
+
+	connectHeader := make(http.Header)
+	if proxyAuth != "" {
+		connectHeader.Set("Proxy-Authorization", proxyAuth)
+	}
+
 	connectReq := &http.Request{
 		Method: "CONNECT",
 		URL:    &url.URL{Opaque: addr},
@@ -56,22 +122,25 @@ func (hpd *httpProxyDialer) Dial(network string, addr string) (net.Conn, error)
 
 	if err := connectReq.Write(conn); err != nil {
 		conn.Close()
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Read response. It's OK to use and discard buffered reader here becaue
 	// the remote server does not speak until spoken to.
-	br := bufio.NewReader(conn)
+	var r io.Reader = conn
+	var hlr *headerLimitReader
+	if hpd.maxHeaderBytes > 0 {
+		hlr = &headerLimitReader{r: conn, n: hpd.maxHeaderBytes}
+		r = hlr
+	}
+	br := bufio.NewReader(r)
 	resp, err := http.ReadResponse(br, connectReq)
 	if err != nil {
 		conn.Close()
-		return nil, err
-	}
-
-	if resp.StatusCode != 200 {
-		conn.Close()
-		f := strings.SplitN(resp.Status, " ", 2)
-		return nil, errors.New(f[1])
+		if hlr != nil && hlr.exceeded {
+			err = ErrResponseHeaderTooLarge
+		}
+		return nil, nil, err
 	}
-	return conn, nil
+	return conn, resp, nil
 }
@@ -0,0 +1,112 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HandshakeLimiter limits the rate of opening handshakes. See the Upgrader
+// Limiter field.
+type HandshakeLimiter interface {
+	// Allow reports whether the handshake request may proceed. The ip
+	// argument is the client address returned by Upgrader.ClientIP, or nil
+	// if the address is not known. If the handshake is not allowed, then Allow also returns the duration after
+	// which the client may retry.
+	Allow(r *http.Request, ip net.IP) (ok bool, retryAfter time.Duration)
+}
+
+// IPRateLimiter is a HandshakeLimiter with a token bucket for each client IP
+// address. If the client IP address is not known, then the bucket is
+// selected by the remote address of the request.
+type IPRateLimiter struct {
+	// Rate specifies the number of handshakes per second allowed from a
+	// client IP address. Handshakes are not limited if Rate is not positive.
+	Rate float64
+
+	// Burst specifies the number of handshakes allowed from a client IP
+	// address at once. If zero, then a burst of one handshake is used.
+	Burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *IPRateLimiter) burst() float64 {
+	if l.Burst <= 0 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+func (l *IPRateLimiter) timeNow() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// Allow implements the HandshakeLimiter interface.
+func (l *IPRateLimiter) Allow(r *http.Request, ip net.IP) (bool, time.Duration) {
+	if l.Rate <= 0 {
+		return true, 0
+	}
+	burst := l.burst()
+	now := l.timeNow()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A bucket is full after the time to refill the burst. Full buckets are
+	// removed to bound the memory used for clients that are not active.
+	fill := time.Duration(burst / l.Rate * float64(time.Second))
+	if now.Sub(l.lastSweep) > fill {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > fill {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := clientKey(r, ip)
+	b := l.buckets[key]
+	if b == nil {
+		if l.buckets == nil {
+			l.buckets = make(map[string]*tokenBucket)
+		}
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// retryAfter formats d as the value of a Retry-After header. The value is
+// rounded up to a whole number of seconds.
+func retryAfter(d time.Duration) string {
+	s := int64((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return strconv.FormatInt(s, 10)
+}
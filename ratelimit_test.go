@@ -0,0 +1,87 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := &IPRateLimiter{Rate: 2, Burst: 3, now: func() time.Time { return now }}
+	a, b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow(nil, a); !ok {
+			t.Fatalf("handshake %d in burst not allowed", i)
+		}
+	}
+	ok, d := l.Allow(nil, a)
+	if ok || d != 500*time.Millisecond {
+		t.Errorf("Allow after burst = %v, %v, want false, 500ms", ok, d)
+	}
+	if ok, _ := l.Allow(nil, b); !ok {
+		t.Error("handshake from other address not allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow(nil, a); !ok {
+		t.Error("handshake after refill not allowed")
+	}
+
+	now = now.Add(time.Minute)
+	l.Allow(nil, b)
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("buckets after sweep = %d, want 1", n)
+	}
+}
+
+func TestIPRateLimiterUnknownAddress(t *testing.T) {
+	l := &IPRateLimiter{Rate: 1}
+	r1 := httptest.NewRequest("GET", "/", nil)
+	r1.RemoteAddr = "client-1"
+	r2 := httptest.NewRequest("GET", "/", nil)
+	r2.RemoteAddr = "client-2"
+
+	if ok, _ := l.Allow(r1, nil); !ok {
+		t.Fatal("first handshake not allowed")
+	}
+	if ok, _ := l.Allow(r1, nil); ok {
+		t.Fatal("second handshake from same client allowed")
+	}
+	if ok, _ := l.Allow(r2, nil); !ok {
+		t.Error("handshake from other client with unknown address not allowed")
+	}
+}
+
+func TestUpgradeLimiter(t *testing.T) {
+	u := Upgrader{Limiter: &IPRateLimiter{Rate: 0.1}}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Connection", "upgrade")
+	r.Header.Set("Upgrade", "websocket")
+
+	w := httptest.NewRecorder()
+	if _, err := u.Upgrade(w, r, nil); err == nil {
+		t.Fatal("Upgrade of invalid request returned nil error")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("first status=%d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	if _, err := u.Upgrade(w, r, nil); err == nil {
+		t.Fatal("Upgrade over limit returned nil error")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status=%d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After=%q, want 10", got)
+	}
+}
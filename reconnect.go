@@ -0,0 +1,197 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errReconnectingConnClosed = errors.New("websocket: reconnecting connection closed")
+
+// ReconnectingConn is a client connection that dials the server again when
+// the connection fails. The connection is dialed on the first call to
+// ReadMessage or WriteMessage. While the connection is being dialed again,
+// ReadMessage and WriteMessage wait for the new connection.
+//
+// A failed dial is retried with backoff until the dial succeeds or Close is
+// called. Close cancels a dial in progress. Use the OnConnect hook to restore application state, such as
+// subscriptions, on each new connection.
+//
+// ReconnectingConn supports one concurrent reader and one concurrent writer.
+type ReconnectingConn struct {
+	// Dialer is used to dial the server. If nil, DefaultDialer is used.
+	Dialer *Dialer
+
+	// URL and Header are the arguments to Dialer.Dial.
+	URL    string
+	Header http.Header
+
+	// Backoff returns the delay before the given dial retry. The retry
+	// argument is 1 for the first retry after a failed dial. If nil, then
+	// exponential backoff with jitter is used.
+	Backoff func(retry int) time.Duration
+
+	// OnConnect is called with each new connection before the connection is
+	// used by ReadMessage and WriteMessage. If OnConnect returns an error,
+	// then the connection is closed and the server is dialed again.
+	OnConnect func(c *Conn) error
+
+	// OnDisconnect is called with the error that failed the connection.
+	OnDisconnect func(err error)
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	conn       *Conn
+	connecting bool
+	closed     bool
+	ctx        context.Context // canceled by Close to stop dials in progress
+	cancel     context.CancelFunc
+}
+
+func (rc *ReconnectingConn) init() {
+	if rc.cond == nil {
+		rc.cond = sync.NewCond(&rc.mu)
+		rc.ctx, rc.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// getConn returns the current connection, dialing the server if needed.
+func (rc *ReconnectingConn) getConn() (*Conn, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.init()
+	for {
+		switch {
+		case rc.closed:
+			return nil, errReconnectingConnClosed
+		case rc.conn != nil:
+			return rc.conn, nil
+		case rc.connecting:
+			rc.cond.Wait()
+		default:
+			rc.connecting = true
+			rc.mu.Unlock()
+			c := rc.connect()
+			rc.mu.Lock()
+			rc.connecting = false
+			rc.cond.Broadcast()
+			if c != nil && rc.closed {
+				c.Close()
+				c = nil
+			}
+			rc.conn = c
+		}
+	}
+}
+
+// connect dials the server until the dial succeeds or the connection is
+// closed.
+func (rc *ReconnectingConn) connect() *Conn {
+	dialer := rc.Dialer
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+	backoff := rc.Backoff
+	if backoff == nil {
+		backoff = defaultRetryBackoff
+	}
+	for retry := 1; ; retry++ {
+		c, _, err := dialer.DialContext(rc.ctx, rc.URL, rc.Header)
+		if err == nil && rc.OnConnect != nil {
+			if err = rc.OnConnect(c); err != nil {
+				c.Close()
+			}
+		}
+		if err == nil {
+			return c
+		}
+		select {
+		case <-time.After(backoff(retry)):
+		case <-rc.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// fail closes the connection c after an error. The server is dialed again on
+// the next call to ReadMessage or WriteMessage.
+func (rc *ReconnectingConn) fail(c *Conn, err error) {
+	rc.mu.Lock()
+	current := rc.conn == c
+	if current {
+		rc.conn = nil
+	}
+	closed := rc.closed
+	rc.mu.Unlock()
+	if !current {
+		return
+	}
+	c.Close()
+	if !closed && rc.OnDisconnect != nil {
+		rc.OnDisconnect(err)
+	}
+}
+
+// ReadMessage reads the next data message. If the connection fails, then
+// ReadMessage waits for a new connection and reads from the new connection.
+func (rc *ReconnectingConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		c, err := rc.getConn()
+		if err != nil {
+			return noFrame, nil, err
+		}
+		messageType, p, err = c.ReadMessage()
+		if err == nil {
+			return messageType, p, nil
+		}
+		rc.fail(c, err)
+	}
+}
+
+// WriteMessage writes a message. If the connection fails, then WriteMessage
+// waits for a new connection and writes the message to the new connection.
+// A message written to a failed connection may or may not have been received
+// by the server.
+func (rc *ReconnectingConn) WriteMessage(messageType int, data []byte) error {
+	for {
+		c, err := rc.getConn()
+		if err != nil {
+			return err
+		}
+		err = c.WriteMessage(messageType, data)
+		if err == nil || err == errBadWriteOpCode || err == errInvalidControlFrame {
+			return err
+		}
+		rc.fail(c, err)
+	}
+}
+
+// Close sends a close message to the server, if connected, and closes the
+// connection. Pending and future calls to ReadMessage and WriteMessage return
+// an error.
+func (rc *ReconnectingConn) Close() error {
+	rc.mu.Lock()
+	rc.init()
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil
+	}
+	rc.closed = true
+	rc.cancel()
+	c := rc.conn
+	rc.conn = nil
+	rc.cond.Broadcast()
+	rc.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return c.Close()
+}
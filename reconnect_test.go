@@ -0,0 +1,141 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectingConn(t *testing.T) {
+	var mu sync.Mutex
+	var serverConns []*Conn
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		serverConns = append(serverConns, ws)
+		mu.Unlock()
+		defer ws.Close()
+		for {
+			mt, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	var connects, disconnects int
+	rc := &ReconnectingConn{
+		URL:     makeWsProto(s.URL),
+		Backoff: func(int) time.Duration { return time.Millisecond },
+		OnConnect: func(c *Conn) error {
+			connects++
+			return c.WriteMessage(TextMessage, []byte("subscribe"))
+		},
+		OnDisconnect: func(err error) { disconnects++ },
+	}
+	defer rc.Close()
+
+	expect := func(want string) {
+		_, p, err := rc.ReadMessage()
+		if err != nil || string(p) != want {
+			t.Fatalf("ReadMessage returned %q, %v, want %q", p, err, want)
+		}
+	}
+
+	if err := rc.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	expect("subscribe")
+	expect("hello")
+
+	// Fail the connection from the server.
+	mu.Lock()
+	serverConns[0].Close()
+	mu.Unlock()
+
+	expect("subscribe")
+	if connects != 2 || disconnects != 1 {
+		t.Errorf("connects=%d, disconnects=%d, want 2, 1", connects, disconnects)
+	}
+	if err := rc.WriteMessage(TextMessage, []byte("again")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	expect("again")
+
+	rc.Close()
+	if _, _, err := rc.ReadMessage(); err != errReconnectingConnClosed {
+		t.Errorf("ReadMessage after Close returned %v, want %v", err, errReconnectingConnClosed)
+	}
+}
+
+func TestReconnectingConnCloseWhileDialing(t *testing.T) {
+	l := httptest.NewServer(http.NotFoundHandler())
+	url := makeWsProto(l.URL)
+	l.Close()
+
+	rc := &ReconnectingConn{URL: url, Backoff: func(int) time.Duration { return time.Hour }}
+	done := make(chan error)
+	go func() {
+		_, _, err := rc.ReadMessage()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rc.Close()
+	select {
+	case err := <-done:
+		if err != errReconnectingConnClosed {
+			t.Errorf("ReadMessage returned %v, want %v", err, errReconnectingConnClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage did not return after Close")
+	}
+}
+
+func TestReconnectingConnCloseCancelsDial(t *testing.T) {
+	// The server accepts connections and does not respond to the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	rc := &ReconnectingConn{Dialer: &Dialer{}, URL: "ws://" + l.Addr().String() + "/"}
+	done := make(chan error)
+	go func() {
+		_, _, err := rc.ReadMessage()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rc.Close()
+	select {
+	case err := <-done:
+		if err != errReconnectingConnClosed {
+			t.Errorf("ReadMessage returned %v, want %v", err, errReconnectingConnClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage did not return after Close")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registry tracks the open connections upgraded by an Upgrader. Set the
+// Upgrader Registry field to use a registry. Each connection is assigned an
+// identifier that is unique in the registry and is returned by the
+// connection's ID method.
+//
+// The zero value is an empty registry ready to use. A registry must not be
+// copied after first use.
+type Registry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*Conn
+}
+
+func (r *Registry) add(c *Conn) {
+	r.mu.Lock()
+	r.nextID++
+	c.id = r.nextID
+	if r.conns == nil {
+		r.conns = make(map[uint64]*Conn)
+	}
+	r.conns[c.id] = c
+	r.mu.Unlock()
+	c.addCloseHook(func() { r.remove(c) })
+}
+
+func (r *Registry) remove(c *Conn) {
+	r.mu.Lock()
+	delete(r.conns, c.id)
+	r.mu.Unlock()
+}
+
+// Len returns the number of open connections in the registry.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Get returns the open connection with the identifier or nil if there is no
+// such connection.
+func (r *Registry) Get(id uint64) *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[id]
+}
+
+// snapshot returns the open connections in the order of their identifiers.
+func (r *Registry) snapshot() []*Conn {
+	r.mu.Lock()
+	conns := make([]*Conn, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// Range calls f for each open connection in the order that the connections
+// were added to the registry. If f returns false, Range stops the iteration.
+// Range iterates over a snapshot of the registry, so f may close
+// connections.
+func (r *Registry) Range(f func(c *Conn) bool) {
+	for _, c := range r.snapshot() {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// Broadcast writes a message to every open connection in the registry. The
+// message is prepared once for all connections. Broadcast is a writer on
+// each connection, so the application must not call Broadcast concurrently
+// with other writes to the connections. Broadcast returns the number of
+// connections where the write failed.
+func (r *Registry) Broadcast(messageType int, data []byte) (failed int, err error) {
+	pm, err := NewPreparedMessage(messageType, data)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range r.snapshot() {
+		if c.WritePreparedMessage(pm) != nil {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// CloseAll sends a close message with the code and text to every open
+// connection in the registry. The connections are not closed, so the read
+// loops on the connections can complete the close handshake. CloseAll can
+// be called concurrently with other writes to the connections.
+func (r *Registry) CloseAll(code int, text string, deadline time.Time) {
+	msg := FormatCloseMessage(code, text)
+	for _, c := range r.snapshot() {
+		c.WriteControl(CloseMessage, msg, deadline)
+	}
+}
+
+// PingResult is the result of pinging a connection with PingAll.
+type PingResult struct {
+	Conn *Conn
+
+	// RTT is the round trip time of the ping if Err is nil.
+	RTT time.Duration
+
+	// Err is the error from the connection's Ping method. Err is the context
+	// error if the pong was not received before the context was done.
+	Err error
+}
+
+// PingAll pings the open connections in the registry concurrently with the
+// connection's Ping method and returns a result for each connection in the
+// order of the connection identifiers. PingAll returns when all pongs are
+// received or ctx is done. If filter is not nil, then only the connections
+// where filter returns true are pinged. If evict is true, then the
+// connections that did not answer are closed and removed from the registry.
+//
+// Pongs are received by the goroutines reading the connections. The
+// application must read the connections as described for the Ping method.
+func (r *Registry) PingAll(ctx context.Context, filter func(c *Conn) bool, evict bool) []PingResult {
+	var results []PingResult
+	for _, c := range r.snapshot() {
+		if filter == nil || filter(c) {
+			results = append(results, PingResult{Conn: c})
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(res *PingResult) {
+			defer wg.Done()
+			res.RTT, res.Err = res.Conn.Ping(ctx)
+			if res.Err != nil && evict {
+				res.Conn.Close()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	var registry Registry
+	u := Upgrader{Registry: &registry}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	var clients []*Conn
+	for i := 0; i < 3; i++ {
+		c, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+	waitRegistryLen(t, &registry, 3)
+
+	var ids []uint64
+	registry.Range(func(c *Conn) bool {
+		ids = append(ids, c.ID())
+		return true
+	})
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("ids=%v, want [1 2 3]", ids)
+	}
+	if c := registry.Get(2); c == nil || c.ID() != 2 {
+		t.Errorf("Get(2) returned %v", c)
+	}
+
+	if failed, err := registry.Broadcast(TextMessage, []byte("hello")); err != nil || failed != 0 {
+		t.Fatalf("Broadcast returned %d, %v", failed, err)
+	}
+	for _, c := range clients {
+		_, p, err := c.ReadMessage()
+		if err != nil || string(p) != "hello" {
+			t.Errorf("ReadMessage returned %q, %v, want hello", p, err)
+		}
+	}
+
+	clients[0].Close()
+	waitRegistryLen(t, &registry, 2)
+	if registry.Get(1) != nil {
+		t.Error("closed connection returned by Get")
+	}
+
+	registry.CloseAll(CloseServiceRestart, "restart", time.Now().Add(time.Second))
+	for _, c := range clients[1:] {
+		if _, _, err := c.ReadMessage(); !IsCloseError(err, CloseServiceRestart) {
+			t.Errorf("ReadMessage returned %v, want service restart close error", err)
+		}
+	}
+	waitRegistryLen(t, &registry, 0)
+}
+
+func waitRegistryLen(t *testing.T, r *Registry, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("registry length %d, want %d", r.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRegistryPingAll(t *testing.T) {
+	var registry Registry
+	u := Upgrader{Registry: &registry}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	// The first client answers pings. The second client does not read.
+	for i := 0; i < 2; i++ {
+		c, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer c.Close()
+		if i == 0 {
+			go func() {
+				for {
+					if _, _, err := c.NextReader(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		waitRegistryLen(t, &registry, i+1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := registry.PingAll(ctx, func(c *Conn) bool { return c.ID() == 1 }, false)
+	if len(results) != 1 || results[0].Conn.ID() != 1 || results[0].Err != nil {
+		t.Fatalf("PingAll with filter returned %+v", results)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results = registry.PingAll(ctx, nil, true)
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != context.DeadlineExceeded {
+		t.Fatalf("PingAll returned %+v", results)
+	}
+	if n := registry.Len(); n != 1 {
+		t.Errorf("registry length after eviction = %d, want 1", n)
+	}
+}
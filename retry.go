@@ -0,0 +1,109 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = 100 * time.Millisecond
+	defaultRetryMaxDelay    = 10 * time.Second
+)
+
+// RetryPolicy specifies how a Dialer retries failed dials.
+type RetryPolicy struct {
+	// MaxAttempts specifies the maximum number of dial attempts, including
+	// the first attempt. If zero, then a default of 3 is used.
+	MaxAttempts int
+
+	// Backoff returns the delay before the given retry. The retry argument
+	// is 1 for the first retry. If nil, then exponential backoff with jitter
+	// starting at 100 milliseconds and limited to 10 seconds is used.
+	Backoff func(retry int) time.Duration
+
+	// Retryable returns true if a dial that failed with the given response
+	// and error should be retried. The response is nil if the dial failed
+	// before a handshake response was received. If nil, then
+	// IsRetryableDialError is used.
+	Retryable func(resp *http.Response, err error) bool
+}
+
+func (p *RetryPolicy) dial(ctx context.Context, d *Dialer, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultRetryMaxAttempts
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryableDialError
+	}
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		conn, resp, err := d.dialFollow(ctx, urlStr, requestHeader)
+		if err == nil || attempt >= maxAttempts || !retryable(resp, err) {
+			return conn, resp, err
+		}
+		t := time.NewTimer(backoff(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return conn, resp, err
+		}
+	}
+}
+
+// defaultRetryBackoff returns an exponential delay with jitter. The delay is
+// chosen randomly from the upper half of the exponential delay to spread
+// retries from many clients.
+func defaultRetryBackoff(retry int) time.Duration {
+	d := defaultRetryBaseDelay
+	for i := 1; i < retry && d < defaultRetryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > defaultRetryMaxDelay {
+		d = defaultRetryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// IsRetryableDialError returns true if the dial result is likely to be
+// transient: a DNS error, a refused connection, a timeout or a handshake
+// response with status 502 Bad Gateway, 503 Service Unavailable or 504
+// Gateway Timeout.
+func IsRetryableDialError(resp *http.Response, err error) bool {
+	if IsBadHandshake(err) && resp != nil {
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	switch err := err.(type) {
+	case *net.DNSError:
+		return true
+	case *net.OpError:
+		if _, ok := err.Err.(*net.DNSError); ok {
+			return true
+		}
+		if err.Timeout() {
+			return true
+		}
+		return isConnRefusedOrReset(err.Err)
+	case net.Error:
+		return err.Timeout()
+	}
+	return false
+}
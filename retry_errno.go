@@ -0,0 +1,19 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9
+
+package websocket
+
+import (
+	"os"
+	"syscall"
+)
+
+func isConnRefusedOrReset(err error) bool {
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return err == syscall.ECONNREFUSED || err == syscall.ECONNRESET
+}
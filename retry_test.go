@@ -0,0 +1,100 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDialRetry(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	failures := 2
+	origHandler := s.Server.Config.Handler
+	s.Server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if failures > 0 {
+				failures--
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			origHandler.ServeHTTP(w, r)
+		})
+
+	var retries []int
+	dialer := cstDialer
+	dialer.RetryPolicy = &RetryPolicy{
+		Backoff: func(retry int) time.Duration {
+			retries = append(retries, retry)
+			return time.Millisecond
+		},
+	}
+	ws, _, err := dialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+	if len(retries) != 2 || retries[0] != 1 || retries[1] != 2 {
+		t.Errorf("retries=%v, want [1 2]", retries)
+	}
+
+	failures = 3
+	retries = nil
+	if _, resp, err := dialer.Dial(s.URL, nil); !IsBadHandshake(err) || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Dial returned %v, want %v", err, ErrBadHandshake)
+	}
+	if len(retries) != 2 {
+		t.Errorf("retries=%v, want 2 retries", retries)
+	}
+}
+
+func TestIsRetryableDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, refused := net.Dial("tcp", addr)
+
+	for _, tt := range []struct {
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{nil, refused, true},
+		{nil, &net.DNSError{Err: "no such host", Name: "example.invalid"}, true},
+		{nil, &net.OpError{Op: "dial", Err: &net.DNSError{}}, true},
+		{&http.Response{StatusCode: http.StatusServiceUnavailable}, ErrBadHandshake, true},
+		{&http.Response{StatusCode: http.StatusBadGateway}, ErrBadHandshake, true},
+		{&http.Response{StatusCode: http.StatusForbidden}, ErrBadHandshake, false},
+		{nil, errMalformedURL, false},
+		{nil, errors.New("other"), false},
+	} {
+		if got := IsRetryableDialError(tt.resp, tt.err); got != tt.want {
+			t.Errorf("IsRetryableDialError(%v, %v) = %v, want %v", tt.resp, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultRetryBackoff(t *testing.T) {
+	for retry, max := range map[int]time.Duration{
+		1:  defaultRetryBaseDelay,
+		2:  2 * defaultRetryBaseDelay,
+		20: defaultRetryMaxDelay,
+	} {
+		for i := 0; i < 10; i++ {
+			if d := defaultRetryBackoff(retry); d < max/2 || d > max {
+				t.Errorf("defaultRetryBackoff(%d) = %v, want between %v and %v", retry, d, max/2, max)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// WriteFile writes the contents of the file from the current offset to the
+// end of the file as a single data message.
+//
+// If the connection is a server connection and the message is not compressed,
+// then WriteFile writes the frame header and copies the file directly to the
+// network connection. The copy uses sendfile or splice where the platform and
+// network connection support it. Otherwise, the file is written through a
+// message writer.
+//
+// The file must not be modified while WriteFile is in progress.
+func (c *Conn) WriteFile(messageType int, f *os.File) error {
+	if !isData(messageType) {
+		return errBadWriteOpCode
+	}
+	if !c.isServer || (c.newCompressionWriter != nil && c.enableWriteCompression) {
+		w, err := c.NextWriter(messageType)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// io.SeekCurrent is not defined in Go < 1.7.
+	offset, err := f.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	length := fi.Size() - offset
+	if length < 0 {
+		length = 0
+	}
+
+	if err := c.prepWrite(messageType); err != nil {
+		return err
+	}
+
+	var header [maxFrameHeaderSize]byte
+	header[0] = byte(messageType) | finalBit
+	n := 2
+	switch {
+	case length >= 65536:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+		n += 8
+	case length > 125:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+		n += 2
+	default:
+		header[1] = byte(length)
+	}
+
+	if err := c.beginWrite(); err != nil {
+		return err
+	}
+	defer c.endWrite()
+
+	<-c.mu
+	defer func() { c.mu <- true }()
+
+	c.writeErrMu.Lock()
+	err = c.writeErr
+	c.writeErrMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.conn.SetWriteDeadline(c.getWriteDeadline())
+	if _, err := c.conn.Write(header[:n]); err != nil {
+		return c.writeFatal(err)
+	}
+	// The header is written, so the connection is corrupt if the copy does
+	// not write exactly length bytes.
+	if _, err := io.CopyN(c.conn, f, length); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return c.writeFatal(err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for _, n := range []int{0, 125, 126, 65535, 65536, 100000} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		f.Truncate(0)
+		f.WriteAt(append([]byte("skip"), data...), 0)
+
+		for _, isServer := range []bool{false, true} {
+			var buf bytes.Buffer
+			wc := newConn(fakeNetConn{Writer: &buf}, isServer, 1024, 1024)
+			rc := newConn(fakeNetConn{Reader: &buf}, !isServer, 1024, 1024)
+
+			f.Seek(4, 0)
+			if err := wc.WriteFile(BinaryMessage, f); err != nil {
+				t.Fatalf("n=%d, isServer=%v: WriteFile: %v", n, isServer, err)
+			}
+			mt, p, err := rc.ReadMessage()
+			if err != nil || mt != BinaryMessage || !bytes.Equal(p, data) {
+				t.Errorf("n=%d, isServer=%v: ReadMessage returned %d, %d bytes, %v", n, isServer, mt, len(p), err)
+			}
+		}
+	}
+}
@@ -5,22 +5,70 @@
 package websocket
 
 import (
-	"bufio"
 	"errors"
 	"net"
 	"net/http"
 	"net/url"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // HandshakeError describes an error with the handshake from the peer.
+//
+// Dial returns a HandshakeError when the server rejects the handshake. The
+// error matches ErrBadHandshake with errors.Is in Go 1.13 and later. Upgrade
+// returns a HandshakeError when the request is not a valid handshake.
 type HandshakeError struct {
 	message string
+
+	// StatusCode is the HTTP status of the handshake response. For errors
+	// returned from Upgrade, StatusCode is the status sent to the client.
+	StatusCode int
+
+	// Header is the header of the handshake response received by Dial. The
+	// header is nil for errors returned from Upgrade.
+	Header http.Header
+
+	// Reason classifies a handshake response rejected by Dial. The reason
+	// is HandshakeReasonNone for errors returned from Upgrade.
+	Reason HandshakeReason
+
+	err error
 }
 
 func (e HandshakeError) Error() string { return e.message }
 
+// Unwrap returns the underlying error. Upgrade returns a HandshakeError
+// wrapping a *HijackError when the connection cannot be taken over from the
+// HTTP server.
+func (e HandshakeError) Unwrap() error { return e.err }
+
+// Is returns true if target is ErrBadHandshake and the error is a handshake
+// response rejected by Dial.
+func (e HandshakeError) Is(target error) bool {
+	return target == ErrBadHandshake && e.Reason != HandshakeReasonNone
+}
+
+// HandshakeReason classifies a handshake response rejected by Dial.
+type HandshakeReason int
+
+const (
+	// HandshakeReasonNone is the reason for errors returned from Upgrade.
+	HandshakeReasonNone HandshakeReason = iota
+
+	// HandshakeBadStatus indicates that the response status is not 101
+	// Switching Protocols.
+	HandshakeBadStatus
+
+	// HandshakeBadProtocol indicates that the response Upgrade or Connection
+	// header does not specify the WebSocket protocol.
+	HandshakeBadProtocol
+
+	// HandshakeBadAccept indicates that the Sec-WebSocket-Accept header is
+	// missing or does not match the challenge key sent in the request.
+	HandshakeBadAccept
+)
+
 // Upgrader specifies parameters for upgrading an HTTP connection to a
 // WebSocket connection.
 type Upgrader struct {
@@ -28,11 +76,36 @@ type Upgrader struct {
 	HandshakeTimeout time.Duration
 
 	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes. If a buffer
-	// size is zero, then buffers allocated by the HTTP server are used. The
-	// I/O buffer sizes do not limit the size of the messages that can be sent
-	// or received.
+	// size is zero or the buffer allocated by the HTTP server is at least the
+	// specified size, then the buffer allocated by the HTTP server is used.
+	// The I/O buffer sizes do not limit the size of the messages that can be
+	// sent or received.
 	ReadBufferSize, WriteBufferSize int
 
+	// ReadBufferPool specifies an optional pool of []byte message buffers
+	// used by the connection's ReadMessageInto method to grow a buffer that
+	// is too small for a message.
+	ReadBufferPool BufferPool
+
+	// WriteBufferPool is a pool of buffers for write operations. If the value
+	// is not set, then write buffers are allocated to the connection for the
+	// lifetime of the connection.
+	//
+	// A pool is most useful when the application has a modest volume of writes
+	// across a large number of connections.
+	//
+	// Applications should use a single pool for each unique value of
+	// WriteBufferSize.
+	WriteBufferPool BufferPool
+
+	// Buffers specifies an optional function to select the I/O buffer sizes
+	// and buffer pools for a request. If Buffers is set, then the values
+	// returned by the function are used instead of the ReadBufferSize,
+	// WriteBufferSize, ReadBufferPool and WriteBufferPool fields and have the
+	// same meaning. Use Buffers when endpoints served by the same Upgrader
+	// have different message profiles.
+	Buffers func(r *http.Request) (readBufferSize, writeBufferSize int, readPool, writePool BufferPool)
+
 	// Subprotocols specifies the server's supported protocols in order of
 	// preference. If this field is not nil, then the Upgrade method negotiates a
 	// subprotocol by selecting the first match in this list with a protocol
@@ -41,8 +114,31 @@ type Upgrader struct {
 	// handshake response).
 	Subprotocols []string
 
+	// Codecs specifies codecs for subprotocols. The codec subprotocols are
+	// negotiated after the subprotocols in the Subprotocols field. If a codec
+	// subprotocol is selected, then the codec is set on the connection.
+	Codecs []SubprotocolCodec
+
+	// SelectSubprotocol specifies an optional function for negotiating the
+	// subprotocol from the protocols offered by the client. If
+	// SelectSubprotocol is not nil, then the function is used instead of the
+	// Subprotocols field and the Sec-WebSocket-Protocol response header.
+	//
+	// The function returns the selected protocol, or the empty string for no
+	// protocol, and true to continue with the handshake. If the function
+	// returns false or returns a protocol not offered by the client, then
+	// the handshake is rejected with 400 Bad Request. A protocol selected
+	// from the Codecs field sets the codec on the connection.
+	SelectSubprotocol func(r *http.Request, offered []string) (string, bool)
+
 	// Error specifies the function for generating HTTP error responses. If Error
 	// is nil, then http.Error is used to generate the HTTP response.
+	//
+	// The function is called with the status that Upgrade would send and a
+	// HandshakeError describing the failure. The function may write any
+	// status, header and body, such as a JSON problem document. The response
+	// writer passed to the function cannot be hijacked, so the connection is
+	// never upgraded after a failed handshake.
 	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
 
 	// CheckOrigin returns true if the request Origin header is acceptable. If
@@ -51,20 +147,232 @@ type Upgrader struct {
 	// request Host header.
 	//
 	// A CheckOrigin function should carefully validate the request origin to
-	// prevent cross-site request forgery.
+	// prevent cross-site request forgery. The SameOriginOnly, OriginAllowlist
+	// and AllowSubdomainsOf functions return vetted CheckOrigin functions.
 	CheckOrigin func(r *http.Request) bool
 
 	// EnableCompression specify if the server should attempt to negotiate per
 	// message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
+
+	// CompressionParams specifies the permessage-deflate parameters used by
+	// the server when EnableCompression is set. The context takeover
+	// parameters are requested in the response. The window bits limit the
+	// window requested by the client's offer. If ClientMaxWindowBits is set,
+	// then offers that do not allow the server to limit the client window
+	// are declined. If nil, the server requests server_no_context_takeover
+	// and client_no_context_takeover. Use the connection's
+	// CompressionParams method to get the negotiated parameters.
+	CompressionParams *CompressionParams
+
+	// Extensions specifies handlers for extensions other than
+	// permessage-deflate. Use the connection's Extensions method to get the
+	// accepted extensions.
+	Extensions []ExtensionHandler
+
+	// PingInterval and PongTimeout configure a keepalive on the upgraded
+	// connection. See the fields with the same names on Dialer.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// TrustedProxies specifies the networks of reverse proxies trusted to
+	// report the client address in the Forwarded, X-Forwarded-For and
+	// X-Real-IP request headers. The headers are ignored when the peer
+	// address is not in one of these networks. See the ClientIP method.
+	TrustedProxies []*net.IPNet
+
+	// Limiter specifies an optional limit on the rate of handshakes. The
+	// limiter is checked before the request is validated. If the limiter
+	// does not allow the handshake, then Upgrade responds with 429 Too Many
+	// Requests and a Retry-After header.
+	Limiter HandshakeLimiter
+
+	// ConnLimiter specifies an optional limit on the number of open
+	// connections for each client. If the limit is reached, then Upgrade
+	// responds with 429 Too Many Requests.
+	ConnLimiter *ConnLimiter
+
+	// MaxConnections specifies the maximum number of connections upgraded by
+	// the Upgrader that are open at once. If the limit is reached, then
+	// Upgrade responds with 503 Service Unavailable. Use the Error field to
+	// customize the response. If zero, then the number of connections is not
+	// limited or counted. The count of connections is kept in the Upgrader,
+	// so an Upgrader with a limit must not be copied after first use.
+	MaxConnections int
+
+	// OnMaxConnections specifies an optional function called when a
+	// handshake is rejected because MaxConnections is reached.
+	OnMaxConnections func(r *http.Request)
+
+	// Registry specifies an optional registry for the connections upgraded
+	// by the Upgrader. Connections are removed from the registry when closed.
+	Registry *Registry
+
+	// ModifyResponse specifies an optional function to modify the header of
+	// the handshake response. The function is called with a copy of the
+	// responseHeader argument to Upgrade after the request is validated and
+	// before the response is written. The Sec-WebSocket-Protocol header set
+	// by the function is ignored. Setting the Sec-WebSocket-Extensions header
+	// fails the handshake.
+	ModifyResponse func(r *http.Request, h http.Header)
+
+	// DrainStatus specifies the HTTP status for upgrade requests received
+	// while the Upgrader is draining. If zero, then 503 Service Unavailable
+	// is used. See the SetDraining method.
+	DrainStatus int
+
+	// DrainRetryAfter specifies the value of the Retry-After header sent
+	// with responses to upgrade requests received while draining. If zero,
+	// then the header is not sent.
+	DrainRetryAfter time.Duration
+
+	// DrainGracePeriod specifies the time after draining starts when a close
+	// message with the service restart code is sent to the connections in
+	// the Registry. The code tells the client to reconnect, which lets a
+	// load balancer move the client to another server. If zero, or if
+	// Registry is nil, then existing connections are not closed.
+	DrainGracePeriod time.Duration
+
+	// Metrics specifies optional callbacks for handshake and connection
+	// events.
+	Metrics Metrics
+
+	openConns int32 // accessed atomically
+	draining  int32 // accessed atomically
+	drainGen  int32 // incremented by SetDraining, accessed atomically
+}
+
+// SetDraining sets whether the Upgrader is draining. While draining, the
+// Upgrader rejects upgrade requests with DrainStatus and closes the existing
+// connections in the Registry after DrainGracePeriod.
+func (u *Upgrader) SetDraining(draining bool) {
+	// The generation invalidates the grace period timer of an earlier call.
+	gen := atomic.AddInt32(&u.drainGen, 1)
+	if !draining {
+		atomic.StoreInt32(&u.draining, 0)
+		return
+	}
+	atomic.StoreInt32(&u.draining, 1)
+	if u.DrainGracePeriod > 0 && u.Registry != nil {
+		time.AfterFunc(u.DrainGracePeriod, func() {
+			if atomic.LoadInt32(&u.drainGen) == gen {
+				u.Registry.CloseAll(CloseServiceRestart, "", time.Now().Add(shutdownWriteWait))
+			}
+		})
+	}
+}
+
+// Draining returns whether the Upgrader is draining.
+func (u *Upgrader) Draining() bool {
+	return atomic.LoadInt32(&u.draining) != 0
+}
+
+// modifyResponse returns a copy of responseHeader modified by the
+// ModifyResponse function.
+func (u *Upgrader) modifyResponse(r *http.Request, responseHeader http.Header) http.Header {
+	h := make(http.Header, len(responseHeader))
+	for k, vs := range responseHeader {
+		h[k] = append([]string(nil), vs...)
+	}
+	u.ModifyResponse(r, h)
+	return h
+}
+
+func (u *Upgrader) compressionParams() *CompressionParams {
+	if u.CompressionParams == nil {
+		return &defaultCompressionParams
+	}
+	return u.CompressionParams
+}
+
+// OpenConns returns the number of connections upgraded by the Upgrader that
+// are not closed. Connections are counted only when MaxConnections is set.
+func (u *Upgrader) OpenConns() int {
+	return int(atomic.LoadInt32(&u.openConns))
 }
 
+// acquireConn reserves a connection slot. If the maximum number of
+// connections is reached, then acquireConn calls the OnMaxConnections
+// function and returns false. The Upgrader is not modified when
+// MaxConnections is zero.
+func (u *Upgrader) acquireConn(r *http.Request) (release func(), ok bool) {
+	if u.MaxConnections <= 0 {
+		return releaseNothing, true
+	}
+	n := atomic.AddInt32(&u.openConns, 1)
+	if int(n) > u.MaxConnections {
+		atomic.AddInt32(&u.openConns, -1)
+		if u.OnMaxConnections != nil {
+			u.OnMaxConnections(r)
+		}
+		return nil, false
+	}
+	return func() { atomic.AddInt32(&u.openConns, -1) }, true
+}
+
+// buffers returns the I/O buffer sizes and buffer pools for the request.
+func (u *Upgrader) buffers(r *http.Request) (readBufferSize, writeBufferSize int, readPool, writePool BufferPool) {
+	if u.Buffers != nil {
+		return u.Buffers(r)
+	}
+	return u.ReadBufferSize, u.WriteBufferSize, u.ReadBufferPool, u.WriteBufferPool
+}
+
+// trackConn calls release to free the connection slots reserved for the
+// connection, removes the connection from the registry and reports the
+// connection to Metrics when the connection is closed.
+func (u *Upgrader) trackConn(c *Conn, release func()) {
+	c.addCloseHook(release)
+	if u.Registry != nil {
+		u.Registry.add(c)
+	}
+	if m := u.Metrics; m != nil {
+		c.metrics = m
+		m.ConnOpened(c)
+		c.addCloseHook(func() { m.ConnClosed(c) })
+	}
+}
+
+const errMaxConnections = "websocket: maximum number of connections reached"
+
+const errKeyConnLimit = "websocket: maximum number of connections for client reached"
+
+const errResponseExtensions = "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported"
+
+const errSubprotocolRejected = "websocket: subprotocol rejected by Upgrader.SelectSubprotocol"
+
+// HijackError describes a failure to take over the network connection from
+// the HTTP server.
+type HijackError struct {
+	// ResponseWriter is the type of the response writer passed to Upgrade.
+	ResponseWriter string
+
+	// Err is the error returned from the Hijack method. Err is nil when
+	// neither the response writer nor a writer that it wraps implements
+	// http.Hijacker.
+	Err error
+}
+
+func (e *HijackError) Error() string {
+	if e.Err == nil {
+		return "websocket: response writer " + e.ResponseWriter + " does not implement http.Hijacker"
+	}
+	return "websocket: hijack " + e.ResponseWriter + ": " + e.Err.Error()
+}
+
+// Unwrap returns e.Err.
+func (e *HijackError) Unwrap() error { return e.Err }
+
 func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status int, reason string) (*Conn, error) {
-	err := HandshakeError{reason}
+	return u.returnHandshakeError(w, r, HandshakeError{message: reason, StatusCode: status})
+}
+
+func (u *Upgrader) returnHandshakeError(w http.ResponseWriter, r *http.Request, err HandshakeError) (*Conn, error) {
+	status := err.StatusCode
+	statsHandshakeError()
 	if u.Error != nil {
-		u.Error(w, r, status, err)
+		u.Error(errorResponseWriter{w}, r, status, err)
 	} else {
 		w.Header().Set("Sec-Websocket-Version", "13")
 		http.Error(w, http.StatusText(status), status)
@@ -72,6 +380,16 @@ func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status in
 	return nil, err
 }
 
+// errorResponseWriter hides the http.Hijacker implementation and other
+// optional interfaces of the response writer passed to the Error function.
+type errorResponseWriter struct {
+	w http.ResponseWriter
+}
+
+func (w errorResponseWriter) Header() http.Header         { return w.w.Header() }
+func (w errorResponseWriter) Write(p []byte) (int, error) { return w.w.Write(p) }
+func (w errorResponseWriter) WriteHeader(status int)      { w.w.WriteHeader(status) }
+
 // checkSameOrigin returns true if the origin is not set or is equal to the request host.
 func checkSameOrigin(r *http.Request) bool {
 	origin := r.Header["Origin"]
@@ -85,10 +403,32 @@ func checkSameOrigin(r *http.Request) bool {
 	return equalASCIIFold(u.Host, r.Host)
 }
 
-func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header) string {
-	if u.Subprotocols != nil {
+// selectSubprotocol returns the negotiated subprotocol and false if the
+// handshake is rejected by the SelectSubprotocol function.
+func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header) (string, bool) {
+	if u.SelectSubprotocol != nil {
+		offered := Subprotocols(r)
+		protocol, ok := u.SelectSubprotocol(r, offered)
+		if !ok {
+			return "", false
+		}
+		if protocol == "" {
+			return "", true
+		}
+		for _, p := range offered {
+			if p == protocol {
+				return protocol, true
+			}
+		}
+		return "", false
+	}
+	return u.selectSubprotocolList(r, responseHeader), true
+}
+
+func (u *Upgrader) selectSubprotocolList(r *http.Request, responseHeader http.Header) string {
+	if u.Subprotocols != nil || u.Codecs != nil {
 		clientProtocols := Subprotocols(r)
-		for _, serverProtocol := range u.Subprotocols {
+		for _, serverProtocol := range appendCodecSubprotocols(u.Subprotocols, u.Codecs) {
 			for _, clientProtocol := range clientProtocols {
 				if clientProtocol == serverProtocol {
 					return clientProtocol
@@ -109,35 +449,59 @@ func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header
 //
 // If the upgrade fails, then Upgrade replies to the client with an HTTP error
 // response.
+//
+// Upgrade accepts WebSocket requests sent over HTTP/2 with the extended
+// CONNECT method (RFC 8441). The handshake is completed with a 200 response
+// and the connection is carried on the request and response bodies of the
+// stream. The connection is not detached from the handler, so the handler
+// must not return until the application is done with the connection. The
+// HTTP/2 server must be configured to advertise extended CONNECT support.
 func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if u.Metrics == nil {
+		return u.upgrade(w, r, responseHeader)
+	}
+	start := time.Now()
+	u.Metrics.HandshakeAttempted(r)
+	c, err := u.upgrade(w, r, responseHeader)
+	u.handshakeDone(r, start, err)
+	return c, err
+}
+
+func (u *Upgrader) upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
 	const badHandshake = "websocket: the client is not using the websocket protocol: "
 
-	if !tokenListContainsValue(r.Header, "Connection", "upgrade") {
-		return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'upgrade' token not found in 'Connection' header")
+	if u.Draining() {
+		status := u.DrainStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		if u.DrainRetryAfter > 0 {
+			w.Header().Set("Retry-After", retryAfter(u.DrainRetryAfter))
+		}
+		return u.returnError(w, r, status, "websocket: upgrader is draining")
 	}
 
-	if !tokenListContainsValue(r.Header, "Upgrade", "websocket") {
-		return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'websocket' token not found in 'Upgrade' header")
+	if u.Limiter != nil {
+		if ok, d := u.Limiter.Allow(r, u.ClientIP(r)); !ok {
+			w.Header().Set("Retry-After", retryAfter(d))
+			return u.returnError(w, r, http.StatusTooManyRequests, "websocket: handshake rate limit exceeded")
+		}
 	}
 
-	if r.Method != "GET" {
-		return u.returnError(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not GET")
+	if r.ProtoMajor == 2 && r.Method == "CONNECT" {
+		return u.upgradeExtendedConnect(w, r, responseHeader)
 	}
 
-	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
-		return u.returnError(w, r, http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
+	if !tokenListContainsValue(r.Header, "Connection", "upgrade") {
+		return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'upgrade' token not found in 'Connection' header")
 	}
 
-	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
-		return u.returnError(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
+	if !tokenListContainsValue(r.Header, "Upgrade", "websocket") {
+		return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'websocket' token not found in 'Upgrade' header")
 	}
 
-	checkOrigin := u.CheckOrigin
-	if checkOrigin == nil {
-		checkOrigin = checkSameOrigin
-	}
-	if !checkOrigin(r) {
-		return u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	if r.Method != "GET" {
+		return u.returnError(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not GET")
 	}
 
 	challengeKey := r.Header.Get("Sec-Websocket-Key")
@@ -145,47 +509,27 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		return u.returnError(w, r, http.StatusBadRequest, "websocket: not a websocket handshake: `Sec-WebSocket-Key' header is missing or blank")
 	}
 
-	subprotocol := u.selectSubprotocol(r, responseHeader)
-
-	// Negotiate PMCE
-	var compress bool
-	if u.EnableCompression {
-		for _, ext := range parseExtensions(r.Header) {
-			if ext[""] != "permessage-deflate" {
-				continue
-			}
-			compress = true
-			break
-		}
+	hs, err := u.negotiate(w, r, responseHeader)
+	if hs == nil {
+		return nil, err
 	}
+	responseHeader = hs.responseHeader
 
-	var (
-		netConn net.Conn
-		err     error
-	)
-
-	h, ok := w.(http.Hijacker)
-	if !ok {
-		return u.returnError(w, r, http.StatusInternalServerError, "websocket: response does not implement http.Hijacker")
-	}
-	var brw *bufio.ReadWriter
-	netConn, brw, err = h.Hijack()
+	netConn, brw, err := hijack(w)
 	if err != nil {
-		return u.returnError(w, r, http.StatusInternalServerError, err.Error())
+		hs.release()
+		return u.returnHandshakeError(w, r, HandshakeError{message: err.Error(), StatusCode: http.StatusInternalServerError, err: err})
 	}
 
 	if brw.Reader.Buffered() > 0 {
+		hs.release()
 		netConn.Close()
+		statsHandshakeError()
 		return nil, errors.New("websocket: client sent data before handshake is complete")
 	}
 
-	c := newConnBRW(netConn, true, u.ReadBufferSize, u.WriteBufferSize, brw)
-	c.subprotocol = subprotocol
-
-	if compress {
-		c.newCompressionWriter = compressNoContextTakeover
-		c.newDecompressionReader = decompressNoContextTakeover
-	}
+	c := newConnBRW(netConn, true, hs.readBufferSize, hs.writeBufferSize, hs.writePool, brw)
+	u.setupConn(c, r, hs)
 
 	p := c.writeBuf[:0]
 	p = append(p, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: "...)
@@ -196,8 +540,10 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		p = append(p, c.subprotocol...)
 		p = append(p, "\r\n"...)
 	}
-	if compress {
-		p = append(p, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n"...)
+	if len(hs.extensions.accepted) > 0 {
+		p = append(p, "Sec-WebSocket-Extensions: "...)
+		p = append(p, hs.extensions.response()...)
+		p = append(p, "\r\n"...)
 	}
 	for k, vs := range responseHeader {
 		if k == "Sec-Websocket-Protocol" {
@@ -226,16 +572,114 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		netConn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
 	}
 	if _, err = netConn.Write(p); err != nil {
-		netConn.Close()
+		c.Close()
+		statsHandshakeError()
 		return nil, err
 	}
 	if u.HandshakeTimeout > 0 {
 		netConn.SetWriteDeadline(time.Time{})
 	}
+	u.startConn(c)
 
 	return c, nil
 }
 
+// handshake is the result of the checks and negotiation shared by the
+// HTTP/1.1 and HTTP/2 handshakes.
+type handshake struct {
+	subprotocol    string
+	extensions     *serverExtensions
+	responseHeader http.Header // responseHeader modified by ModifyResponse
+	release        func()      // releases the connection limit reservations
+
+	// I/O buffer sizes and buffer pools selected for the request.
+	readBufferSize, writeBufferSize int
+	readPool, writePool             BufferPool
+}
+
+// negotiate validates the request and negotiates the subprotocol and
+// extensions. If the handshake fails, then negotiate replies to the client
+// with an HTTP error response and returns a nil handshake.
+func (u *Upgrader) negotiate(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*handshake, error) {
+	fail := func(status int, reason string) (*handshake, error) {
+		_, err := u.returnError(w, r, status, reason)
+		return nil, err
+	}
+
+	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
+		return fail(http.StatusBadRequest, "websocket: unsupported version: 13 not found in 'Sec-Websocket-Version' header")
+	}
+
+	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
+		return fail(http.StatusInternalServerError, errResponseExtensions)
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
+	}
+
+	subprotocol, ok := u.selectSubprotocol(r, responseHeader)
+	if !ok {
+		return fail(http.StatusBadRequest, errSubprotocolRejected)
+	}
+
+	extensions, err := u.negotiateExtensions(r)
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+
+	if u.ModifyResponse != nil {
+		responseHeader = u.modifyResponse(r, responseHeader)
+		if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
+			return fail(http.StatusInternalServerError, errResponseExtensions)
+		}
+	}
+
+	releaseKey, ok := u.acquireKeyConn(w, r)
+	if !ok {
+		return fail(http.StatusTooManyRequests, errKeyConnLimit)
+	}
+	releaseConn, ok := u.acquireConn(r)
+	if !ok {
+		releaseKey()
+		return fail(http.StatusServiceUnavailable, errMaxConnections)
+	}
+
+	hs := &handshake{
+		subprotocol:    subprotocol,
+		extensions:     extensions,
+		responseHeader: responseHeader,
+		release: func() {
+			releaseConn()
+			releaseKey()
+		},
+	}
+	hs.readBufferSize, hs.writeBufferSize, hs.readPool, hs.writePool = u.buffers(r)
+	return hs, nil
+}
+
+// setupConn configures a connection created for the handshake.
+func (u *Upgrader) setupConn(c *Conn, r *http.Request, hs *handshake) {
+	u.trackConn(c, hs.release)
+	c.subprotocol = hs.subprotocol
+	c.codec = codecForSubprotocol(u.Codecs, hs.subprotocol)
+	c.clientIP = u.ClientIP(r)
+	c.tlsState = r.TLS
+	c.readPool = hs.readPool
+	hs.extensions.configure(c)
+}
+
+// startConn starts the connection after the handshake response is written.
+func (u *Upgrader) startConn(c *Conn) {
+	if u.PingInterval > 0 {
+		c.startKeepalive(u.PingInterval, u.PongTimeout)
+	}
+}
+
 // Upgrade upgrades the HTTP server connection to the WebSocket protocol.
 //
 // Deprecated: Use websocket.Upgrader instead.
@@ -279,22 +723,17 @@ func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header,
 }
 
 // Subprotocols returns the subprotocols requested by the client in the
-// Sec-Websocket-Protocol header.
+// Sec-Websocket-Protocol headers. See ParseSubprotocols.
 func Subprotocols(r *http.Request) []string {
-	h := strings.TrimSpace(r.Header.Get("Sec-Websocket-Protocol"))
-	if h == "" {
-		return nil
-	}
-	protocols := strings.Split(h, ",")
-	for i := range protocols {
-		protocols[i] = strings.TrimSpace(protocols[i])
-	}
-	return protocols
+	return ParseSubprotocols(r.Header)
 }
 
 // IsWebSocketUpgrade returns true if the client requested upgrade to the
-// WebSocket protocol.
+// WebSocket protocol, including with the HTTP/2 extended CONNECT method.
 func IsWebSocketUpgrade(r *http.Request) bool {
+	if r.ProtoMajor == 2 && r.Method == "CONNECT" {
+		return equalASCIIFold(r.Header.Get(":protocol"), "websocket")
+	}
 	return tokenListContainsValue(r.Header, "Connection", "upgrade") &&
 		tokenListContainsValue(r.Header, "Upgrade", "websocket")
 }
@@ -0,0 +1,196 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errConnHijacked = errors.New("websocket: connection already hijacked")
+
+// UpgradeConn reads the opening handshake request from a network connection
+// accepted by the application and upgrades the connection to the WebSocket
+// protocol. Use UpgradeConn to serve WebSocket connections without
+// http.Server.
+//
+// If br is not nil, the request is read from br. The reader must read from
+// netConn and must not have buffered data past the end of the request. If br
+// is nil, the request is read directly from netConn and the request header
+// is limited to http.DefaultMaxHeaderBytes.
+//
+// UpgradeConn validates the request with the same rules as Upgrade and
+// returns the request along with the connection. The request Body is empty
+// and the RemoteAddr field is set from netConn. If the request is not a valid
+// handshake, then UpgradeConn writes an HTTP error response to netConn,
+// closes netConn and returns an error.
+func (u *Upgrader) UpgradeConn(netConn net.Conn, br *bufio.Reader, responseHeader http.Header) (*Conn, *http.Request, error) {
+	var hlr *headerLimitReader
+	if br == nil {
+		hlr = &headerLimitReader{r: netConn, n: http.DefaultMaxHeaderBytes}
+		br = bufio.NewReader(hlr)
+	}
+
+	if u.HandshakeTimeout > 0 {
+		netConn.SetReadDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	start := time.Now()
+	r, err := http.ReadRequest(br)
+	if err != nil {
+		status := http.StatusBadRequest
+		if hlr != nil && hlr.exceeded {
+			status = http.StatusRequestHeaderFieldsTooLarge
+		}
+		w := newConnResponseWriter(netConn, br)
+		w.WriteHeader(status)
+		w.finish()
+		netConn.Close()
+		statsHandshakeError()
+		err = HandshakeError{message: "websocket: malformed handshake request: " + err.Error(), StatusCode: status}
+		if u.Metrics != nil {
+			u.Metrics.HandshakeAttempted(nil)
+			u.handshakeDone(nil, start, err)
+		}
+		return nil, nil, err
+	}
+	if hlr != nil {
+		hlr.n = -1
+	}
+	r.RemoteAddr = netConn.RemoteAddr().String()
+
+	w := newConnResponseWriter(netConn, br)
+	if u.Metrics != nil {
+		u.Metrics.HandshakeAttempted(r)
+	}
+	c, err := u.upgrade(w, r, responseHeader)
+	if u.Metrics != nil {
+		u.handshakeDone(r, start, err)
+	}
+	if err != nil {
+		if !w.hijacked {
+			w.finish()
+			netConn.Close()
+		}
+		return nil, r, err
+	}
+	return c, r, nil
+}
+
+// connResponseWriter is the http.ResponseWriter passed to Upgrade by
+// UpgradeConn. The writer buffers error responses until finish is called.
+type connResponseWriter struct {
+	netConn  net.Conn
+	br       *bufio.Reader
+	header   http.Header
+	status   int
+	body     []byte
+	hijacked bool
+}
+
+func newConnResponseWriter(netConn net.Conn, br *bufio.Reader) *connResponseWriter {
+	return &connResponseWriter{netConn: netConn, br: br, header: make(http.Header)}
+}
+
+func (w *connResponseWriter) Header() http.Header { return w.header }
+
+func (w *connResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *connResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, errConnHijacked
+	}
+	w.WriteHeader(http.StatusOK)
+	w.body = append(w.body, p...)
+	return len(p), nil
+}
+
+func (w *connResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, errConnHijacked
+	}
+	w.hijacked = true
+	return w.netConn, bufio.NewReadWriter(w.br, nil), nil
+}
+
+// finish writes the buffered response to the network connection.
+func (w *connResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	w.header.Set("Connection", "close")
+	w.header.Set("Content-Length", strconv.Itoa(len(w.body)))
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/1.1 " + strconv.Itoa(w.status) + " " + http.StatusText(w.status) + "\r\n")
+	w.header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(w.body)
+	w.netConn.Write(buf.Bytes())
+}
+
+// ServerConnConfig specifies the parameters of a connection created with
+// NewServerConn.
+type ServerConnConfig struct {
+	// Subprotocol is the subprotocol negotiated in the handshake.
+	Subprotocol string
+
+	// Compression specifies whether per message compression (RFC 7692) was
+	// negotiated in the handshake.
+	Compression bool
+
+	// CompressionParams specifies the permessage-deflate parameters in the
+	// handshake response sent by the application. If nil, then the
+	// parameters are server_no_context_takeover and
+	// client_no_context_takeover.
+	CompressionParams *CompressionParams
+
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes. If a
+	// buffer size is zero, then a default size is used.
+	ReadBufferSize, WriteBufferSize int
+}
+
+// NewServerConn returns a server connection for a network connection where
+// the application completed the opening handshake. Use NewServerConn to
+// adapt the package to HTTP servers other than net/http, such as fasthttp.
+// The config describes the subprotocol and compression parameters in the
+// handshake response sent by the application.
+//
+// If br is not nil, then buffered data in br is read before data from
+// netConn. The reader must read from netConn.
+func NewServerConn(netConn net.Conn, br *bufio.Reader, config ServerConnConfig) *Conn {
+	c := newConn(netConn, true, config.ReadBufferSize, config.WriteBufferSize)
+	if br != nil && br.Buffered() > 0 {
+		// Read through br to preserve the buffered data.
+		size := config.ReadBufferSize
+		if size == 0 {
+			size = defaultReadBufferSize
+		}
+		if size < maxControlFramePayloadSize {
+			size = maxControlFramePayloadSize
+		}
+		c.br = bufio.NewReaderSize(br, size)
+	}
+	c.subprotocol = config.Subprotocol
+	if config.Compression {
+		p := config.CompressionParams
+		if p == nil {
+			p = &defaultCompressionParams
+		}
+		serverDeflateState(p).configure(c)
+	}
+	return c
+}
+
+// AcceptKey returns the value of the Sec-WebSocket-Accept response header
+// for the Sec-WebSocket-Key request header.
+func AcceptKey(challengeKey string) string {
+	return computeAcceptKey(challengeKey)
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestUpgradeConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	paths := make(chan string, 1)
+	go func() {
+		for {
+			netConn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				u := Upgrader{Subprotocols: []string{"p1"}}
+				ws, r, err := u.UpgradeConn(netConn, nil, nil)
+				if err != nil {
+					return
+				}
+				paths <- r.URL.Path
+				defer ws.Close()
+				for {
+					mt, p, err := ws.ReadMessage()
+					if err != nil {
+						return
+					}
+					ws.WriteMessage(mt, p)
+				}
+			}()
+		}
+	}()
+
+	ws, resp, err := DefaultDialer.Dial("ws://"+l.Addr().String()+"/echo", http.Header{"Sec-Websocket-Protocol": {"p1"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if got := <-paths; got != "/echo" {
+		t.Errorf("path=%q, want /echo", got)
+	}
+	if got := resp.Header.Get("Sec-Websocket-Protocol"); got != "p1" {
+		t.Errorf("subprotocol=%q, want p1", got)
+	}
+	if err := ws.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	_, p, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if string(p) != "hello" {
+		t.Errorf("message=%q, want hello", p)
+	}
+
+	for _, tt := range []struct {
+		request string
+		status  int
+	}{
+		{"GET /plain HTTP/1.1\r\nHost: example.com\r\n\r\n", http.StatusBadRequest},
+		{"POST /post HTTP/1.1\r\nHost: example.com\r\nConnection: upgrade\r\nUpgrade: websocket\r\n\r\n", http.StatusMethodNotAllowed},
+		{"garbage\r\n\r\n", http.StatusBadRequest},
+	} {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(c, tt.request)
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatalf("ReadResponse(%q): %v", tt.request, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("status for %q = %d, want %d", tt.request, resp.StatusCode, tt.status)
+		}
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			t.Errorf("reading body for %q: %v", tt.request, err)
+		}
+		c.Close()
+	}
+}
+
+func TestNewServerConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// The first frame is buffered in the reader passed to NewServerConn, as
+	// done by HTTP servers that read ahead of the handshake request.
+	var frames bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &frames}, false, 1024, 1024)
+	wc.WriteMessage(TextMessage, []byte("first"))
+	br := bufio.NewReader(io.MultiReader(bytes.NewReader(frames.Bytes()), server))
+	br.Peek(frames.Len())
+
+	c := NewServerConn(server, br, ServerConnConfig{Subprotocol: "p1"})
+	defer c.Close()
+	if c.Subprotocol() != "p1" {
+		t.Errorf("Subprotocol()=%q, want p1", c.Subprotocol())
+	}
+
+	go func() {
+		cc := newConn(client, false, 1024, 1024)
+		cc.WriteMessage(TextMessage, []byte("second"))
+	}()
+	for _, want := range []string{"first", "second"} {
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if string(p) != want {
+			t.Errorf("message=%q, want %q", p, want)
+		}
+	}
+
+	cp := CompressionParams{ClientNoContextTakeover: true, ServerMaxWindowBits: 10}
+	c = NewServerConn(server, nil, ServerConnConfig{Compression: true, CompressionParams: &cp})
+	want := CompressionParams{ClientNoContextTakeover: true, ServerMaxWindowBits: 10, ClientMaxWindowBits: 15}
+	if got, ok := c.CompressionParams(); !ok || got != want {
+		t.Errorf("CompressionParams()=%+v, %v, want %+v, true", got, ok, want)
+	}
+	c = NewServerConn(server, nil, ServerConnConfig{Compression: true})
+	want = CompressionParams{ServerNoContextTakeover: true, ClientNoContextTakeover: true, ServerMaxWindowBits: 15, ClientMaxWindowBits: 15}
+	if got, ok := c.CompressionParams(); !ok || got != want {
+		t.Errorf("default CompressionParams()=%+v, %v, want %+v, true", got, ok, want)
+	}
+
+	if got, want := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("AcceptKey()=%q, want %q", got, want)
+	}
+}
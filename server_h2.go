@@ -0,0 +1,123 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.20
+
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upgradeExtendedConnect completes the handshake for a WebSocket request
+// sent over HTTP/2 with the extended CONNECT method (RFC 8441). The
+// connection is backed by the request body and the response writer.
+func (u *Upgrader) upgradeExtendedConnect(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if !equalASCIIFold(r.Header.Get(":protocol"), "websocket") {
+		return u.returnError(w, r, http.StatusBadRequest, "websocket: the client is not using the websocket protocol: ':protocol' pseudo-header is not websocket")
+	}
+
+	hs, err := u.negotiate(w, r, responseHeader)
+	if hs == nil {
+		return nil, err
+	}
+
+	h := w.Header()
+	for k, vs := range hs.responseHeader {
+		if k == "Sec-Websocket-Protocol" {
+			continue
+		}
+		h[k] = vs
+	}
+	if hs.subprotocol != "" {
+		h.Set("Sec-Websocket-Protocol", hs.subprotocol)
+	}
+	if len(hs.extensions.accepted) > 0 {
+		h.Set("Sec-Websocket-Extensions", hs.extensions.response())
+	}
+
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		hs.release()
+		statsHandshakeError()
+		return nil, err
+	}
+
+	c := newConnBRW(newStreamConn(w, r, rc), true, hs.readBufferSize, hs.writeBufferSize, hs.writePool, nil)
+	u.setupConn(c, r, hs)
+	u.startConn(c)
+	return c, nil
+}
+
+// streamConn adapts an HTTP/2 stream to the net.Conn interface. Reads are
+// from the request body. Writes are to the response writer and are flushed
+// immediately.
+type streamConn struct {
+	w  http.ResponseWriter
+	r  *http.Request
+	rc *http.ResponseController
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newStreamConn(w http.ResponseWriter, r *http.Request, rc *http.ResponseController) *streamConn {
+	return &streamConn{w: w, r: r, rc: rc}
+}
+
+var errStreamClosed = errors.New("websocket: stream closed")
+
+func (c *streamConn) Read(p []byte) (int, error) { return c.r.Body.Read(p) }
+
+// Write writes p to the stream. The response writer must not be used after
+// the handler returns, so writes fail after the connection is closed.
+func (c *streamConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, errStreamClosed
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.r.Body.Close()
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	if addr, ok := c.r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return rwcAddr{}
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", c.r.RemoteAddr); err == nil {
+		return addr
+	}
+	return rwcAddr{}
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return c.rc.SetReadDeadline(t) }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return c.rc.SetWriteDeadline(t) }
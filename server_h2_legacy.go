@@ -0,0 +1,13 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !go1.20
+
+package websocket
+
+import "net/http"
+
+func (u *Upgrader) upgradeExtendedConnect(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	return u.returnError(w, r, http.StatusNotImplemented, "websocket: extended CONNECT requires Go 1.20 or later")
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.20
+
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// streamResponseWriter is a response writer for an HTTP/2 stream that sends
+// the response body to a pipe.
+type streamResponseWriter struct {
+	header http.Header
+	status int
+	w      io.Writer
+}
+
+func (w *streamResponseWriter) Header() http.Header         { return w.header }
+func (w *streamResponseWriter) WriteHeader(status int)      { w.status = status }
+func (w *streamResponseWriter) Write(p []byte) (int, error) { return w.w.Write(p) }
+func (w *streamResponseWriter) Flush()                      {}
+
+func TestUpgradeExtendedConnect(t *testing.T) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	r, _ := http.NewRequest("CONNECT", "https://example.com/chat", serverReader)
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	r.Header.Set(":protocol", "websocket")
+	r.Header.Set("Sec-Websocket-Version", "13")
+	r.Header.Set("Sec-Websocket-Protocol", "p0, p1")
+	if !IsWebSocketUpgrade(r) {
+		t.Error("IsWebSocketUpgrade returned false for extended CONNECT")
+	}
+
+	w := &streamResponseWriter{header: make(http.Header), w: serverWriter}
+	u := Upgrader{Subprotocols: []string{"p1"}}
+	server, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	defer server.Close()
+	if w.status != http.StatusOK {
+		t.Errorf("status=%d, want %d", w.status, http.StatusOK)
+	}
+	if got := w.header.Get("Sec-Websocket-Protocol"); got != "p1" {
+		t.Errorf("subprotocol=%q, want p1", got)
+	}
+	if w.header.Get("Sec-Websocket-Accept") != "" {
+		t.Error("Sec-WebSocket-Accept sent in extended CONNECT response")
+	}
+
+	client := newConn(fakeNetConn{Reader: clientReader, Writer: clientWriter}, false, 1024, 1024)
+	go func() {
+		mt, p, err := server.ReadMessage()
+		if err != nil {
+			return
+		}
+		server.WriteMessage(mt, p)
+	}()
+	if err := client.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	_, p, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if string(p) != "hello" {
+		t.Errorf("message=%q, want hello", p)
+	}
+}
+
+func TestUpgradeExtendedConnectBadProtocol(t *testing.T) {
+	r, _ := http.NewRequest("CONNECT", "https://example.com/chat", nil)
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	r.Header.Set(":protocol", "connect-udp")
+	r.Header.Set("Sec-Websocket-Version", "13")
+	w := &streamResponseWriter{header: make(http.Header), w: ioutil.Discard}
+	if _, err := cstUpgrader.Upgrade(w, r, nil); err == nil {
+		t.Fatal("Upgrade returned nil error")
+	}
+	if w.status != http.StatusBadRequest {
+		t.Errorf("status=%d, want %d", w.status, http.StatusBadRequest)
+	}
+}
@@ -5,9 +5,14 @@
 package websocket
 
 import (
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 var subprotocolTests = []struct {
@@ -20,6 +25,7 @@ var subprotocolTests = []struct {
 	{"foo, bar", []string{"foo", "bar"}},
 	{" foo, bar", []string{"foo", "bar"}},
 	{" foo, bar ", []string{"foo", "bar"}},
+	{"foo,, bar,", []string{"foo", "bar"}},
 }
 
 func TestSubprotocols(t *testing.T) {
@@ -32,6 +38,17 @@ func TestSubprotocols(t *testing.T) {
 	}
 }
 
+func TestParseSubprotocolsMultipleHeaders(t *testing.T) {
+	h := http.Header{"Sec-Websocket-Protocol": {"foo, bar", " baz"}}
+	protocols := ParseSubprotocols(h)
+	if want := []string{"foo", "bar", "baz"}; !reflect.DeepEqual(protocols, want) {
+		t.Errorf("ParseSubprotocols returned %q, want %q", protocols, want)
+	}
+	if s := FormatSubprotocols(protocols); s != "foo, bar, baz" {
+		t.Errorf("FormatSubprotocols returned %q", s)
+	}
+}
+
 var isWebSocketUpgradeTests = []struct {
 	ok bool
 	h  http.Header
@@ -67,3 +84,359 @@ func TestCheckSameOrigin(t *testing.T) {
 		}
 	}
 }
+
+var selectSubprotocolTests = []struct {
+	offered  []string
+	protocol string
+	ok       bool
+	want     string
+	status   int
+}{
+	{[]string{"p0", "p1"}, "p1", true, "p1", http.StatusSwitchingProtocols},
+	{[]string{"p0", "p1"}, "", true, "", http.StatusSwitchingProtocols},
+	{[]string{"p0"}, "", false, "", http.StatusBadRequest},
+	{[]string{"p0"}, "p1", true, "", http.StatusBadRequest},
+}
+
+func TestSelectSubprotocol(t *testing.T) {
+	for _, tt := range selectSubprotocolTests {
+		var gotOffered []string
+		u := Upgrader{
+			Subprotocols: []string{"p0"},
+			SelectSubprotocol: func(r *http.Request, offered []string) (string, bool) {
+				gotOffered = offered
+				return tt.protocol, tt.ok
+			},
+		}
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := u.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			ws.Close()
+		}))
+
+		d := cstDialer
+		d.Subprotocols = tt.offered
+		ws, resp, err := d.Dial(makeWsProto(s.URL), nil)
+		if ws != nil {
+			if got := ws.Subprotocol(); got != tt.want {
+				t.Errorf("%v/%q: Subprotocol()=%q, want %q", tt.offered, tt.protocol, got, tt.want)
+			}
+			ws.Close()
+		} else if err == nil {
+			t.Errorf("%v/%q: Dial returned nil conn and nil error", tt.offered, tt.protocol)
+		}
+		if resp == nil || resp.StatusCode != tt.status {
+			t.Errorf("%v/%q: response %v, want status %d", tt.offered, tt.protocol, resp, tt.status)
+		}
+		if !reflect.DeepEqual(gotOffered, tt.offered) {
+			t.Errorf("%v/%q: offered=%v", tt.offered, tt.protocol, gotOffered)
+		}
+		s.Close()
+	}
+}
+
+func TestUpgraderMaxConnections(t *testing.T) {
+	rejected := make(chan bool, 1)
+	u := Upgrader{
+		MaxConnections:   1,
+		OnMaxConnections: func(r *http.Request) { rejected <- true },
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer ws.Close()
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if n := u.OpenConns(); n != 1 {
+		t.Errorf("OpenConns()=%d, want 1", n)
+	}
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Dial over limit returned %v, %v, want status %d", resp, err, http.StatusServiceUnavailable)
+	}
+	select {
+	case <-rejected:
+	default:
+		t.Error("OnMaxConnections not called")
+	}
+
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for u.OpenConns() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not released after close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ws, _, err = cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial after close: %v", err)
+	}
+	ws.Close()
+}
+
+func TestUpgraderModifyResponse(t *testing.T) {
+	responseHeader := http.Header{"X-Fixed": {"fixed"}}
+	u := Upgrader{
+		ModifyResponse: func(r *http.Request, h http.Header) {
+			h.Add("X-Fixed", "added")
+			h.Set("X-Session", r.URL.Query().Get("session"))
+			if r.URL.Query().Get("ext") != "" {
+				h.Set("Sec-Websocket-Extensions", "x-custom")
+			}
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, responseHeader)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer s.Close()
+
+	ws, resp, err := cstDialer.Dial(makeWsProto(s.URL)+"?session=abc", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	if got := resp.Header.Get("X-Session"); got != "abc" {
+		t.Errorf("X-Session=%q, want abc", got)
+	}
+	if got := resp.Header["X-Fixed"]; !reflect.DeepEqual(got, []string{"fixed", "added"}) {
+		t.Errorf("X-Fixed=%q, want [fixed added]", got)
+	}
+	if got := responseHeader["X-Fixed"]; !reflect.DeepEqual(got, []string{"fixed"}) {
+		t.Errorf("responseHeader modified: X-Fixed=%q", got)
+	}
+
+	_, resp, err = cstDialer.Dial(makeWsProto(s.URL)+"?ext=1", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Dial with extension header returned %v, %v, want status %d", resp, err, http.StatusInternalServerError)
+	}
+}
+
+func TestUpgraderErrorResponder(t *testing.T) {
+	var hijackable bool
+	u := Upgrader{
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			_, hijackable = w.(http.Hijacker)
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte(`{"status":` + strconv.Itoa(status) + `}`))
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.Upgrade(w, r, nil)
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTeapot || string(body) != `{"status":400}` ||
+		resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Errorf("response %d %q %q, want custom problem document", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if hijackable {
+		t.Error("response writer passed to Error implements http.Hijacker")
+	}
+}
+
+func TestUpgraderDraining(t *testing.T) {
+	u := Upgrader{
+		Registry:         &Registry{},
+		DrainRetryAfter:  30 * time.Second,
+		DrainGracePeriod: 10 * time.Millisecond,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	u.SetDraining(true)
+	if !u.Draining() {
+		t.Error("Draining() returned false after SetDraining(true)")
+	}
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Dial while draining returned %v, %v, want status %d", resp, err, http.StatusServiceUnavailable)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After=%q, want 30", got)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); !IsCloseError(err, CloseServiceRestart) {
+		t.Errorf("ReadMessage returned %v, want service restart close error", err)
+	}
+
+	u.SetDraining(false)
+	ws, _, err = cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial after draining: %v", err)
+	}
+	ws.Close()
+}
+
+// recordingMetrics records Metrics events as strings.
+type recordingMetrics struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *recordingMetrics) record(s string) {
+	m.mu.Lock()
+	m.events = append(m.events, s)
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) get() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.events...)
+}
+
+func (m *recordingMetrics) HandshakeAttempted(r *http.Request) { m.record("attempted") }
+
+func (m *recordingMetrics) HandshakeSucceeded(r *http.Request, d time.Duration) {
+	m.record("succeeded")
+}
+
+func (m *recordingMetrics) HandshakeFailed(r *http.Request, d time.Duration, err error) {
+	status := 0
+	if e, ok := err.(HandshakeError); ok {
+		status = e.StatusCode
+	}
+	m.record("failed " + strconv.Itoa(status))
+}
+
+func (m *recordingMetrics) ConnOpened(c *Conn) { m.record("opened") }
+func (m *recordingMetrics) ConnClosed(c *Conn) { m.record("closed") }
+
+func TestUpgraderMetrics(t *testing.T) {
+	var m recordingMetrics
+	u := Upgrader{Metrics: &m}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.ReadMessage()
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+
+	want := []string{"attempted", "failed 400", "attempted", "opened", "succeeded", "closed"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := m.get()
+		if reflect.DeepEqual(events, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events=%v, want %v", events, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpgraderBuffers(t *testing.T) {
+	sizes := make(chan int, 1)
+	var pool simpleBufferPool
+	u := Upgrader{
+		Buffers: func(r *http.Request) (int, int, BufferPool, BufferPool) {
+			switch r.URL.Path {
+			case "/large":
+				return 16384, 16384, nil, nil
+			case "/pooled":
+				return 1024, 1024, &pool, &pool
+			}
+			return 1024, 1024, nil, nil
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if ws.writePool != nil {
+			if ws.writeBuf != nil || ws.readPool != &pool {
+				t.Error("pooled connection holds a write buffer or is missing the read pool")
+			}
+			ws.WriteMessage(TextMessage, []byte("hello"))
+			wpd, _ := pool.Get().(writePoolData)
+			sizes <- len(wpd.buf)
+			return
+		}
+		sizes <- len(ws.writeBuf)
+	}))
+	defer s.Close()
+
+	for _, tt := range []struct {
+		path string
+		min  int
+		max  int
+	}{
+		{"/large", 16384, 16384 + maxFrameHeaderSize},
+		{"/small", 1024, 4096 + maxFrameHeaderSize},
+		{"/pooled", 1024 + maxFrameHeaderSize, 1024 + maxFrameHeaderSize},
+	} {
+		ws, _, err := cstDialer.Dial(makeWsProto(s.URL)+tt.path, nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		ws.Close()
+		if n := <-sizes; n < tt.min || n > tt.max {
+			t.Errorf("%s: write buffer size %d, want between %d and %d", tt.path, n, tt.min, tt.max)
+		}
+	}
+}
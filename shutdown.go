@@ -0,0 +1,128 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// shutdownPollInterval is how often Shutdown checks for closed
+	// connections.
+	shutdownPollInterval = 10 * time.Millisecond
+
+	// shutdownWriteWait is the time allowed to write the close message when
+	// the Shutdown context does not have a deadline.
+	shutdownWriteWait = time.Second
+)
+
+// ErrServerClosed is returned by the Server Upgrade method when Shutdown is
+// called during the upgrade.
+var ErrServerClosed = errors.New("websocket: server closed")
+
+// Server upgrades connections and tracks the connections so that they can
+// be shut down gracefully. The http.Server Shutdown method does not close
+// hijacked connections.
+//
+// The connections are tracked in the Upgrader's Registry if set. Otherwise,
+// the Server tracks the connections in a registry of its own.
+type Server struct {
+	// Upgrader specifies the upgrader for requests. If nil, then an Upgrader
+	// with the zero value for all fields is used.
+	Upgrader *Upgrader
+
+	mu           sync.Mutex
+	conns        Registry // used when the Upgrader does not have a Registry
+	shuttingDown bool
+}
+
+const errServerShutdown = "websocket: server is shutting down"
+
+// registry returns the registry that tracks the Server's connections.
+func (s *Server) registry() *Registry {
+	if s.Upgrader != nil && s.Upgrader.Registry != nil {
+		return s.Upgrader.Registry
+	}
+	return &s.conns
+}
+
+// Upgrade upgrades the HTTP server connection to the WebSocket protocol
+// using the Server's Upgrader. The connection is tracked by the Server until
+// the connection is closed. After Shutdown is called, Upgrade responds with
+// 503 Service Unavailable.
+func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	u := s.Upgrader
+	if u == nil {
+		u = &Upgrader{}
+	}
+	s.mu.Lock()
+	shuttingDown := s.shuttingDown
+	s.mu.Unlock()
+	if shuttingDown {
+		return u.returnError(w, r, http.StatusServiceUnavailable, errServerShutdown)
+	}
+
+	c, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		c.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(shutdownWriteWait))
+		c.Close()
+		return nil, ErrServerClosed
+	}
+	if u.Registry == nil {
+		s.conns.add(c)
+	}
+	return c, nil
+}
+
+// Shutdown gracefully shuts down the connections upgraded by the Server.
+// Shutdown stops new upgrades, sends a close message with the going away
+// code to every open connection and waits for the connections to close.
+// Applications close a connection after the read loop on the connection
+// receives the close handshake from the peer.
+//
+// If the context expires before all connections are closed, then Shutdown
+// closes the remaining connections and returns the context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	reg := s.registry()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(shutdownWriteWait)
+	}
+	reg.CloseAll(CloseGoingAway, "", deadline)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if reg.Len() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			for _, c := range reg.snapshot() {
+				c.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) openConns() int {
+	return s.registry().Len()
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	var ws Server
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := ws.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	// A client that completes the close handshake.
+	polite, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer polite.Close()
+	politeErr := make(chan error, 1)
+	go func() {
+		_, _, err := polite.ReadMessage()
+		politeErr <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-politeErr; !IsCloseError(err, CloseGoingAway) {
+		t.Errorf("client read returned %v, want going away close error", err)
+	}
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Dial after Shutdown returned %v, %v, want status %d", resp, err, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	var ws Server
+	upgraded := make(chan *Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The application does not read from the connection, so the close
+		// handshake is never completed.
+		c, err := ws.Upgrade(w, r, nil)
+		if err == nil {
+			upgraded <- c
+		}
+	}))
+	defer s.Close()
+
+	client, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	<-upgraded
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := ws.openConns(); n != 0 {
+		t.Errorf("open connections after Shutdown = %d, want 0", n)
+	}
+}
+
+func TestServerShutdownRegistry(t *testing.T) {
+	reg := &Registry{}
+	ws := Server{Upgrader: &Upgrader{Registry: reg}}
+	upgraded := make(chan *Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := ws.Upgrade(w, r, nil)
+		if err == nil {
+			upgraded <- c
+		}
+	}))
+	defer s.Close()
+
+	client, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	c := <-upgraded
+	if reg.Get(c.ID()) != c {
+		t.Fatal("connection not in the Upgrader registry")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := reg.Len(); n != 0 {
+		t.Errorf("registry connections after Shutdown = %d, want 0", n)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SpooledMessage is a message read with ReadSpooledMessage. The message is
+// held in memory or in a temporary file.
+type SpooledMessage struct {
+	r    io.ReadSeeker
+	size int64
+	file *os.File
+}
+
+// Read reads from the message.
+func (m *SpooledMessage) Read(p []byte) (int, error) {
+	return m.r.Read(p)
+}
+
+// Seek sets the offset for the next Read.
+func (m *SpooledMessage) Seek(offset int64, whence int) (int64, error) {
+	return m.r.Seek(offset, whence)
+}
+
+// Size returns the size of the message in bytes.
+func (m *SpooledMessage) Size() int64 {
+	return m.size
+}
+
+// Spilled returns true if the message is held in a temporary file.
+func (m *SpooledMessage) Spilled() bool {
+	return m.file != nil
+}
+
+// Close releases the resources for the message. If the message is held in a
+// temporary file, then the file is removed.
+func (m *SpooledMessage) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	if rerr := os.Remove(m.file.Name()); err == nil {
+		err = rerr
+	}
+	m.file = nil
+	return err
+}
+
+// ReadSpooledMessage reads the next data message from the connection.
+// Messages up to threshold bytes in size are held in memory. Larger messages
+// are streamed to a temporary file in directory dir. If dir is the empty
+// string, then the default directory for temporary files is used.
+//
+// Use ReadSpooledMessage to receive occasional large messages without holding
+// the entire message in memory. The application must close the returned
+// message to remove the temporary file.
+func (c *Conn) ReadSpooledMessage(threshold int64, dir string) (messageType int, m *SpooledMessage, err error) {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, threshold+1)
+	if err == io.EOF {
+		return messageType, &SpooledMessage{r: bytes.NewReader(buf.Bytes()), size: n}, nil
+	}
+	if err != nil {
+		return messageType, nil, err
+	}
+
+	f, err := ioutil.TempFile(dir, "websocket-")
+	if err != nil {
+		return messageType, nil, err
+	}
+	m = &SpooledMessage{r: f, file: f}
+	m.size, err = io.Copy(f, io.MultiReader(&buf, r))
+	if err == nil {
+		// io.SeekStart is not defined in Go < 1.7.
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		m.Close()
+		return messageType, nil, err
+	}
+	return messageType, m, nil
+}
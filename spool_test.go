@@ -0,0 +1,59 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadSpooledMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	rc := newConn(fakeNetConn{Reader: &buf}, false, 1024, 1024)
+
+	const threshold = 100
+	for _, n := range []int{0, threshold, threshold + 1, 10000} {
+		data := bytes.Repeat([]byte{'x'}, n)
+		wc.WriteMessage(BinaryMessage, data)
+
+		mt, m, err := rc.ReadSpooledMessage(threshold, dir)
+		if err != nil {
+			t.Fatalf("ReadSpooledMessage(%d): %v", n, err)
+		}
+		if mt != BinaryMessage {
+			t.Errorf("%d: messageType=%d, want %d", n, mt, BinaryMessage)
+		}
+		if m.Size() != int64(n) {
+			t.Errorf("%d: Size()=%d", n, m.Size())
+		}
+		if spilled := n > threshold; m.Spilled() != spilled {
+			t.Errorf("%d: Spilled()=%v, want %v", n, m.Spilled(), spilled)
+		}
+		for i := 0; i < 2; i++ {
+			p, err := ioutil.ReadAll(m)
+			if err != nil || !bytes.Equal(p, data) {
+				t.Errorf("%d: ReadAll returned %d bytes, %v", n, len(p), err)
+			}
+			m.Seek(0, 0)
+		}
+		if err := m.Close(); err != nil {
+			t.Errorf("%d: Close: %v", n, err)
+		}
+	}
+
+	names, _ := ioutil.ReadDir(dir)
+	if len(names) != 0 {
+		t.Errorf("%d temporary files not removed", len(names))
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of package level connection statistics. The counters
+// include the connections of all Dialers and Upgraders in the process. Use
+// the Upgrader Metrics field to count the handshakes and connections of one
+// Upgrader.
+type Stats struct {
+	// OpenConns is the number of connections that are not closed.
+	OpenConns int64 `json:"openConns"`
+
+	// TotalConns is the number of connections created.
+	TotalConns int64 `json:"totalConns"`
+
+	// HandshakeErrors is the number of failed client and server handshakes.
+	HandshakeErrors int64 `json:"handshakeErrors"`
+
+	// ReadErrors is the number of connections where a read failed with an
+	// error other than a close message from the peer. A connection that ends
+	// without a close message is counted as a read error.
+	ReadErrors int64 `json:"readErrors"`
+
+	// WriteErrors is the number of connections where a write failed.
+	WriteErrors int64 `json:"writeErrors"`
+}
+
+var stats Stats
+
+// ReadStats returns the current package level connection statistics.
+func ReadStats() Stats {
+	return Stats{
+		OpenConns:       atomic.LoadInt64(&stats.OpenConns),
+		TotalConns:      atomic.LoadInt64(&stats.TotalConns),
+		HandshakeErrors: atomic.LoadInt64(&stats.HandshakeErrors),
+		ReadErrors:      atomic.LoadInt64(&stats.ReadErrors),
+		WriteErrors:     atomic.LoadInt64(&stats.WriteErrors),
+	}
+}
+
+// StatsHandler returns a handler that responds with the JSON encoding of the
+// current package level connection statistics. The handler is intended for
+// use on an administrative endpoint.
+func StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(ReadStats())
+	})
+}
+
+func statsConnOpened() {
+	atomic.AddInt64(&stats.OpenConns, 1)
+	atomic.AddInt64(&stats.TotalConns, 1)
+}
+
+func statsConnClosed() {
+	atomic.AddInt64(&stats.OpenConns, -1)
+}
+
+func statsHandshakeError() {
+	atomic.AddInt64(&stats.HandshakeErrors, 1)
+}
+
+func statsReadError(err error) {
+	// Close errors are created from close messages received from the peer,
+	// except for the abnormal closure error reported when the network
+	// connection ends without a close message.
+	if e, ok := err.(*CloseError); ok && e.Code != CloseAbnormalClosure {
+		return
+	}
+	atomic.AddInt64(&stats.ReadErrors, 1)
+}
+
+func statsWriteError(err error) {
+	if err != ErrCloseSent {
+		atomic.AddInt64(&stats.WriteErrors, 1)
+	}
+}
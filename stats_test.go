@@ -0,0 +1,100 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	before := ReadStats()
+
+	c := newConn(fakeNetConn{Reader: failingReader{}}, false, 1024, 1024)
+	c.ReadMessage()
+	c.ReadMessage()
+
+	mid := ReadStats()
+	if n := mid.OpenConns - before.OpenConns; n != 1 {
+		t.Errorf("open connections increased by %d, want 1", n)
+	}
+	if n := mid.TotalConns - before.TotalConns; n != 1 {
+		t.Errorf("total connections increased by %d, want 1", n)
+	}
+	if n := mid.ReadErrors - before.ReadErrors; n != 1 {
+		t.Errorf("read errors increased by %d, want 1 for abnormal closure", n)
+	}
+
+	// A close message from the peer is not a read error.
+	var buf bytes.Buffer
+	wc := newConn(fakeNetConn{Writer: &buf}, true, 1024, 1024)
+	wc.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+	rc := newConn(fakeNetConn{Reader: &buf, Writer: ioutil.Discard}, false, 1024, 1024)
+	if _, _, err := rc.ReadMessage(); !IsCloseError(err, CloseNormalClosure) {
+		t.Errorf("ReadMessage returned %v, want normal closure", err)
+	}
+	if n := ReadStats().ReadErrors - mid.ReadErrors; n != 0 {
+		t.Errorf("read errors increased by %d, want 0 for close message", n)
+	}
+	wc.Close()
+	rc.Close()
+
+	c.Close()
+	c.Close()
+
+	after := ReadStats()
+	if after.OpenConns != before.OpenConns {
+		t.Errorf("open connections = %d after close, want %d", after.OpenConns, before.OpenConns)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	StatsHandler().ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var s Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Unmarshal returned %v", err)
+	}
+	if s.TotalConns < after.TotalConns {
+		t.Errorf("handler TotalConns = %d, want at least %d", s.TotalConns, after.TotalConns)
+	}
+}
+
+func TestStatsDataBeforeHandshake(t *testing.T) {
+	errs := make(chan error, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if ws != nil {
+			ws.Close()
+		}
+		errs <- err
+	}))
+	defer s.Close()
+
+	before := ReadStats()
+	nc, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer nc.Close()
+	// Send a frame in the same write as the handshake request.
+	nc.Write([]byte("GET / HTTP/1.1\r\nHost: " + s.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n\x81\x00"))
+	if err := <-errs; err == nil {
+		t.Fatal("Upgrade returned nil error for data sent before the handshake")
+	}
+	if n := ReadStats().HandshakeErrors - before.HandshakeErrors; n != 1 {
+		t.Errorf("handshake errors increased by %d, want 1", n)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTicketTTL   = 30 * time.Second
+	ticketNonceLen     = 16
+	ticketPayloadLen   = 8 + ticketNonceLen
+	ticketQueryParam   = "ticket"
+	maxTicketRespBytes = 4096
+)
+
+var (
+	errNoTicket      = errors.New("websocket: missing ticket")
+	errBadTicket     = errors.New("websocket: invalid ticket")
+	errTicketExpired = errors.New("websocket: ticket expired")
+	errTicketUsed    = errors.New("websocket: ticket already used")
+	errNoTicketKey   = errors.New("websocket: ticket key not set")
+)
+
+// TicketAuthority issues and validates one-time tickets for authenticating
+// WebSocket handshakes.
+//
+// Browsers cannot set headers on WebSocket handshake requests. A common
+// pattern is for the client to fetch a short-lived ticket with an
+// authenticated HTTP request and pass the ticket in the query of the
+// WebSocket URL. A ticket is signed with Key, is bound to the IP address and
+// origin of the request that fetched the ticket, and can only be used once.
+//
+// The application is responsible for authenticating the request to issue a
+// ticket.
+type TicketAuthority struct {
+	// Key is the secret used to sign tickets. The key must not be empty.
+	Key []byte
+
+	// TTL specifies how long a ticket is valid. If zero, then a default of 30
+	// seconds is used.
+	TTL time.Duration
+
+	// TrustedProxies specifies the networks of reverse proxies trusted to
+	// report the client address. Tickets are bound to the client address
+	// returned by Upgrader.ClientIP with these networks.
+	TrustedProxies []*net.IPNet
+
+	mu      sync.Mutex
+	used    map[string]bool // nonces of used tickets that are not expired
+	expires ticketQueue     // used tickets ordered by expiry
+	now     func() time.Time
+}
+
+// usedTicket is an entry in a ticketQueue.
+type usedTicket struct {
+	nonce  string
+	expiry time.Time
+}
+
+// ticketQueue is a min-heap of used tickets ordered by expiry.
+type ticketQueue []usedTicket
+
+func (q ticketQueue) Len() int            { return len(q) }
+func (q ticketQueue) Less(i, j int) bool  { return q[i].expiry.Before(q[j].expiry) }
+func (q ticketQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *ticketQueue) Push(x interface{}) { *q = append(*q, x.(usedTicket)) }
+
+func (q *ticketQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+func (a *TicketAuthority) ttl() time.Duration {
+	if a.TTL <= 0 {
+		return defaultTicketTTL
+	}
+	return a.TTL
+}
+
+func (a *TicketAuthority) timeNow() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// requestIP returns the IP address of the client that sent the request.
+func (a *TicketAuthority) requestIP(r *http.Request) string {
+	u := Upgrader{TrustedProxies: a.TrustedProxies}
+	if ip := u.ClientIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+func (a *TicketAuthority) sign(payload []byte, r *http.Request) ([]byte, error) {
+	if len(a.Key) == 0 {
+		return nil, errNoTicketKey
+	}
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write(payload)
+	io.WriteString(mac, "\x00"+a.requestIP(r)+"\x00"+r.Header.Get("Origin"))
+	return mac.Sum(nil), nil
+}
+
+// Issue returns a ticket bound to the IP address and origin of the request.
+func (a *TicketAuthority) Issue(r *http.Request) (string, error) {
+	payload := make([]byte, ticketPayloadLen)
+	binary.BigEndian.PutUint64(payload, uint64(a.timeNow().Add(a.ttl()).Unix()))
+	if _, err := io.ReadFull(rand.Reader, payload[8:]); err != nil {
+		return "", err
+	}
+	sig, err := a.sign(payload, r)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ServeHTTP issues a ticket for the request and writes the ticket to the
+// response as plain text. Wrap the handler with the application's
+// authentication.
+func (a *TicketAuthority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ticket, err := a.Issue(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, ticket)
+}
+
+// Validate validates the ticket in the "ticket" query parameter of the
+// request. The ticket is consumed by a successful validation.
+func (a *TicketAuthority) Validate(r *http.Request) error {
+	ticket := r.URL.Query().Get(ticketQueryParam)
+	if ticket == "" {
+		return errNoTicket
+	}
+	i := strings.IndexByte(ticket, '.')
+	if i < 0 {
+		return errBadTicket
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(ticket[:i])
+	if err != nil || len(payload) != ticketPayloadLen {
+		return errBadTicket
+	}
+	want, err := a.sign(payload, r)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(ticket[i+1:])
+	if err != nil || !hmac.Equal(sig, want) {
+		return errBadTicket
+	}
+
+	now := a.timeNow()
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !now.Before(expiry) {
+		return errTicketExpired
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.used == nil {
+		a.used = make(map[string]bool)
+	}
+	for len(a.expires) > 0 && !now.Before(a.expires[0].expiry) {
+		delete(a.used, heap.Pop(&a.expires).(usedTicket).nonce)
+	}
+	nonce := string(payload[8:])
+	if a.used[nonce] {
+		return errTicketUsed
+	}
+	a.used[nonce] = true
+	heap.Push(&a.expires, usedTicket{nonce: nonce, expiry: expiry})
+	return nil
+}
+
+// Upgrade validates the request ticket and upgrades the connection using
+// upgrader. If the ticket is not valid, then Upgrade replies to the client
+// with status 403 Forbidden.
+func (a *TicketAuthority) Upgrade(upgrader *Upgrader, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if err := a.Validate(r); err != nil {
+		return upgrader.returnError(w, r, http.StatusForbidden, err.Error())
+	}
+	return upgrader.Upgrade(w, r, responseHeader)
+}
+
+// DialWithTicket fetches a ticket from ticketURL with an HTTP GET request and
+// dials urlStr with the ticket added to the URL query. The request header is
+// sent with both requests. The Dialer's Jar, Proxy and TLSClientConfig fields
+// are used for the ticket request.
+//
+// Use TicketAuthority on the server to issue and validate tickets.
+func (d *Dialer) DialWithTicket(ticketURL, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &nilDialer
+	}
+
+	req, err := http.NewRequest("GET", ticketURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range requestHeader {
+		req.Header[k] = vs
+	}
+	transport := &http.Transport{
+		Proxy:           d.Proxy,
+		TLSClientConfig: d.TLSClientConfig,
+	}
+	// The transport is not reused after the ticket request.
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Jar:       d.Jar,
+		Timeout:   d.HandshakeTimeout,
+		Transport: transport,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body := resp.Body
+		d.drainErrorBody(resp)
+		body.Close()
+		return nil, resp, HandshakeError{
+			message:    ErrBadHandshake.Error(),
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Reason:     HandshakeBadStatus,
+		}
+	}
+	p, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTicketRespBytes))
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := u.Query()
+	q.Set(ticketQueryParam, strings.TrimSpace(string(p)))
+	u.RawQuery = q.Encode()
+	return d.Dial(u.String(), requestHeader)
+}
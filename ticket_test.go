@@ -0,0 +1,144 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTicketValidate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := TicketAuthority{Key: []byte("secret"), now: func() time.Time { return now }}
+
+	newRequest := func(remoteAddr, origin, ticket string) *http.Request {
+		r, _ := http.NewRequest("GET", "http://example.com/ws?ticket="+url.QueryEscape(ticket), nil)
+		r.RemoteAddr = remoteAddr
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	issue := func() string {
+		ticket, err := a.Issue(newRequest("1.2.3.4:1000", "http://example.com", ""))
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		return ticket
+	}
+
+	ticket := issue()
+	if err := a.Validate(newRequest("1.2.3.4:2000", "http://example.com", ticket)); err != nil {
+		t.Errorf("Validate returned %v", err)
+	}
+	if err := a.Validate(newRequest("1.2.3.4:2000", "http://example.com", ticket)); err != errTicketUsed {
+		t.Errorf("Validate of used ticket returned %v, want %v", err, errTicketUsed)
+	}
+
+	ticket = issue()
+	if err := a.Validate(newRequest("5.6.7.8:2000", "http://example.com", ticket)); err != errBadTicket {
+		t.Errorf("Validate from other IP returned %v, want %v", err, errBadTicket)
+	}
+	if err := a.Validate(newRequest("1.2.3.4:2000", "http://evil.com", ticket)); err != errBadTicket {
+		t.Errorf("Validate from other origin returned %v, want %v", err, errBadTicket)
+	}
+	if err := a.Validate(newRequest("1.2.3.4:2000", "http://example.com", "")); err != errNoTicket {
+		t.Errorf("Validate without ticket returned %v, want %v", err, errNoTicket)
+	}
+
+	now = now.Add(time.Minute)
+	if err := a.Validate(newRequest("1.2.3.4:2000", "http://example.com", ticket)); err != errTicketExpired {
+		t.Errorf("Validate of expired ticket returned %v, want %v", err, errTicketExpired)
+	}
+}
+
+func TestTicketNoKey(t *testing.T) {
+	var a TicketAuthority
+	r, _ := http.NewRequest("GET", "http://example.com/ws", nil)
+	if _, err := a.Issue(r); err != errNoTicketKey {
+		t.Errorf("Issue returned %v, want %v", err, errNoTicketKey)
+	}
+
+	keyed := TicketAuthority{Key: []byte("secret")}
+	ticket, err := keyed.Issue(r)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	r.URL.RawQuery = "ticket=" + url.QueryEscape(ticket)
+	if err := a.Validate(r); err != errNoTicketKey {
+		t.Errorf("Validate returned %v, want %v", err, errNoTicketKey)
+	}
+}
+
+func TestTicketTrustedProxies(t *testing.T) {
+	_, proxyNet, _ := net.ParseCIDR("10.0.0.0/8")
+	a := TicketAuthority{Key: []byte("secret"), TrustedProxies: []*net.IPNet{proxyNet}}
+
+	newRequest := func(clientIP, ticket string) *http.Request {
+		r, _ := http.NewRequest("GET", "http://example.com/ws?ticket="+url.QueryEscape(ticket), nil)
+		r.RemoteAddr = "10.0.0.1:1000"
+		r.Header.Set("X-Forwarded-For", clientIP)
+		return r
+	}
+
+	ticket, err := a.Issue(newRequest("1.2.3.4", ""))
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if err := a.Validate(newRequest("5.6.7.8", ticket)); err != errBadTicket {
+		t.Errorf("Validate from other client returned %v, want %v", err, errBadTicket)
+	}
+	if err := a.Validate(newRequest("1.2.3.4", ticket)); err != nil {
+		t.Errorf("Validate returned %v", err)
+	}
+}
+
+func TestDialWithTicket(t *testing.T) {
+	a := TicketAuthority{Key: []byte("secret")}
+	mux := http.NewServeMux()
+	mux.Handle("/ticket", &a)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := a.Upgrade(&Upgrader{}, w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		mt, p, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		ws.WriteMessage(mt, p)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var d Dialer
+	ws, _, err := d.DialWithTicket(s.URL+"/ticket", makeWsProto(s.URL)+"/ws", nil)
+	if err != nil {
+		t.Fatalf("DialWithTicket: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	_, resp, err := d.Dial(makeWsProto(s.URL)+"/ws", nil)
+	if !IsBadHandshake(err) || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Dial without ticket returned %v, %v, want %v, 403", resp, err, ErrBadHandshake)
+	}
+
+	_, resp, err = d.DialWithTicket(s.URL+"/missing", makeWsProto(s.URL)+"/ws", nil)
+	if !IsBadHandshake(err) || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("DialWithTicket with bad ticket URL returned %v, %v, want %v, 404", resp, err, ErrBadHandshake)
+	}
+	if p, _ := ioutil.ReadAll(resp.Body); !strings.Contains(string(p), "not found") {
+		t.Errorf("ticket error body = %q, want not found message", p)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// DialTrace is a set of hooks to run at stages of the client opening
+// handshake. Any particular hook may be nil. Hooks are called from the
+// goroutine calling Dial.
+//
+// Use DialTrace to measure where handshake latency is spent. Set a trace
+// for all dials with the Dialer.Trace field or for a single dial with
+// WithDialTrace.
+type DialTrace struct {
+	// DNSStart is called when a DNS lookup begins. DNS hooks are called
+	// only when the Dialer's NetDial field is nil.
+	DNSStart func(host string)
+
+	// DNSDone is called when a DNS lookup ends.
+	DNSDone func(addrs []net.IPAddr, err error)
+
+	// ConnectStart is called when the network connection to the server or
+	// proxy is started. When connecting through a proxy, the connection
+	// phase includes the proxy handshake.
+	ConnectStart func(network, addr string)
+
+	// ConnectDone is called when the network connection is established or
+	// the connection attempt fails.
+	ConnectDone func(network, addr string, err error)
+
+	// TLSHandshakeStart is called when the TLS handshake for a wss URL is
+	// started.
+	TLSHandshakeStart func()
+
+	// TLSHandshakeDone is called after the TLS handshake with either the
+	// connection state or a non-nil error.
+	TLSHandshakeDone func(state tls.ConnectionState, err error)
+
+	// WroteRequest is called with the result of writing the opening
+	// handshake request.
+	WroteRequest func(err error)
+
+	// GotResponse is called when the header of the handshake response is
+	// read. The status code is 101 Switching Protocols when the server
+	// accepts the handshake.
+	GotResponse func(resp *http.Response)
+}
+
+type dialTraceKey struct{}
+
+// WithDialTrace returns a new context based on the provided parent ctx. A
+// dial made with the returned context uses the provided trace hooks instead
+// of the Dialer's Trace field.
+func WithDialTrace(ctx context.Context, trace *DialTrace) context.Context {
+	return context.WithValue(ctx, dialTraceKey{}, trace)
+}
+
+// ContextDialTrace returns the DialTrace associated with the provided
+// context. If none, it returns nil.
+func ContextDialTrace(ctx context.Context) *DialTrace {
+	trace, _ := ctx.Value(dialTraceKey{}).(*DialTrace)
+	return trace
+}
+
+// netContext returns a context that reports DNS lookups by net.Dialer to the
+// trace.
+func (t *DialTrace) netContext(ctx context.Context) context.Context {
+	if t == nil || (t.DNSStart == nil && t.DNSDone == nil) {
+		return ctx
+	}
+	ct := &httptrace.ClientTrace{}
+	if t.DNSStart != nil {
+		ct.DNSStart = func(info httptrace.DNSStartInfo) { t.DNSStart(info.Host) }
+	}
+	if t.DNSDone != nil {
+		ct.DNSDone = func(info httptrace.DNSDoneInfo) { t.DNSDone(info.Addrs, info.Err) }
+	}
+	return httptrace.WithClientTrace(ctx, ct)
+}
+
+func (t *DialTrace) connectStart(network, addr string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(network, addr)
+	}
+}
+
+func (t *DialTrace) connectDone(network, addr string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(network, addr, err)
+	}
+}
+
+func (t *DialTrace) tlsHandshakeStart() {
+	if t != nil && t.TLSHandshakeStart != nil {
+		t.TLSHandshakeStart()
+	}
+}
+
+func (t *DialTrace) tlsHandshakeDone(netConn net.Conn, err error) {
+	if t != nil && t.TLSHandshakeDone != nil {
+		var state tls.ConnectionState
+		if tlsConn, ok := netConn.(*tls.Conn); ok && err == nil {
+			state = tlsConn.ConnectionState()
+		}
+		t.TLSHandshakeDone(state, err)
+	}
+}
+
+func (t *DialTrace) wroteRequest(err error) {
+	if t != nil && t.WroteRequest != nil {
+		t.WroteRequest(err)
+	}
+}
+
+func (t *DialTrace) gotResponse(resp *http.Response) {
+	if t != nil && t.GotResponse != nil {
+		t.GotResponse(resp)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDialTrace(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	var events []string
+	trace := &DialTrace{
+		DNSStart: func(host string) {
+			events = append(events, "DNSStart "+host)
+		},
+		DNSDone: func(addrs []net.IPAddr, err error) {
+			if err != nil {
+				t.Errorf("DNSDone: %v", err)
+			}
+			events = append(events, "DNSDone")
+		},
+		ConnectStart: func(network, addr string) {
+			events = append(events, "ConnectStart "+network)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				t.Errorf("ConnectDone: %v", err)
+			}
+			events = append(events, "ConnectDone "+network)
+		},
+		TLSHandshakeStart: func() {
+			events = append(events, "TLSHandshakeStart")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil || !state.HandshakeComplete {
+				t.Errorf("TLSHandshakeDone: %v, %v", state.HandshakeComplete, err)
+			}
+			events = append(events, "TLSHandshakeDone")
+		},
+		WroteRequest: func(err error) {
+			if err != nil {
+				t.Errorf("WroteRequest: %v", err)
+			}
+			events = append(events, "WroteRequest")
+		},
+		GotResponse: func(resp *http.Response) {
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Errorf("GotResponse: status %d", resp.StatusCode)
+			}
+			events = append(events, "GotResponse")
+		},
+	}
+
+	_, port, err := net.SplitHostPort(s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := "wss://localhost:" + port + cstRequestURI
+
+	want := []string{
+		"ConnectStart tcp",
+		"DNSStart localhost",
+		"DNSDone",
+		"ConnectDone tcp",
+		"TLSHandshakeStart",
+		"TLSHandshakeDone",
+		"WroteRequest",
+		"GotResponse",
+	}
+
+	d := cstDialer
+	d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	d.Trace = trace
+	ws, _, err := d.Dial(u, http.Header{"Origin": {strings.Replace(s.Server.URL, "127.0.0.1", "localhost", 1)}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Dial with Dialer.Trace events\n\t%q\nwant\n\t%q", events, want)
+	}
+
+	events = nil
+	d.Trace = &DialTrace{ConnectStart: func(network, addr string) {
+		t.Errorf("Dialer.Trace used with context trace")
+	}}
+	ctx := WithDialTrace(context.Background(), trace)
+	if ContextDialTrace(ctx) != trace {
+		t.Errorf("ContextDialTrace did not return trace")
+	}
+	ws, _, err = d.DialContext(ctx, u, http.Header{"Origin": {strings.Replace(s.Server.URL, "127.0.0.1", "localhost", 1)}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Dial with context trace events\n\t%q\nwant\n\t%q", events, want)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+)
+
+const defaultTransferChunkSize = 32 * 1024
+
+// ErrTransferChecksum is returned from ReadTransfer when the checksum sent by
+// the peer does not match the checksum of the received data.
+var ErrTransferChecksum = errors.New("websocket: transfer checksum mismatch")
+
+var errTransferProtocol = errors.New("websocket: unexpected message in transfer")
+
+// TransferHeader describes a stream sent with WriteTransfer.
+type TransferHeader struct {
+	// Name is an application defined name for the stream, typically a file
+	// name.
+	Name string `json:"name"`
+
+	// Size is the total size of the stream in bytes or -1 if the size is not
+	// known.
+	Size int64 `json:"size"`
+
+	// Offset is the position in the stream of the first byte sent. A non-zero
+	// offset is used to resume an interrupted transfer.
+	Offset int64 `json:"offset"`
+}
+
+// TransferOptions specifies options for WriteTransfer and ReadTransfer.
+type TransferOptions struct {
+	// ChunkSize specifies the maximum size of a data message. If zero, then a
+	// default size of 32 KiB is used.
+	ChunkSize int
+
+	// Progress is called after each chunk is written or read with the
+	// position in the stream and the total size from the header.
+	Progress func(pos, size int64)
+
+	// Hash returns the hash used to compute the transfer checksum. If nil,
+	// then SHA-256 is used. Both peers must use the same hash.
+	//
+	// The checksum covers the data sent in one transfer, from the header
+	// offset to the end of the stream. When a transfer is resumed at a
+	// non-zero offset, only the resumed segment is verified.
+	Hash func() hash.Hash
+}
+
+type transferTrailer struct {
+	Length   int64  `json:"length"`
+	Checksum string `json:"checksum"`
+}
+
+func (o *TransferOptions) chunkSize() int {
+	if o == nil || o.ChunkSize <= 0 {
+		return defaultTransferChunkSize
+	}
+	return o.ChunkSize
+}
+
+func (o *TransferOptions) newHash() hash.Hash {
+	if o == nil || o.Hash == nil {
+		return sha256.New()
+	}
+	return o.Hash()
+}
+
+func (o *TransferOptions) progress(pos, size int64) {
+	if o != nil && o.Progress != nil {
+		o.Progress(pos, size)
+	}
+}
+
+// WriteTransfer sends the contents of r to the peer as a sequence of messages:
+// a text message with the JSON encoding of h, a binary message for each chunk
+// of data and a text message with the length and checksum of the data. The
+// length and checksum cover the data sent, starting at h.Offset.
+//
+// If h.Offset is not zero and r implements io.Seeker, then r is positioned at
+// the offset before reading. Otherwise, r is assumed to be positioned at the
+// offset.
+//
+// The peer reads the transfer with ReadTransfer.
+func (c *Conn) WriteTransfer(h TransferHeader, r io.Reader, opts *TransferOptions) error {
+	if h.Offset < 0 {
+		return errors.New("websocket: negative transfer offset")
+	}
+	if s, ok := r.(io.Seeker); ok && h.Offset > 0 {
+		// io.SeekStart is not defined in Go < 1.7.
+		if _, err := s.Seek(h.Offset, 0); err != nil {
+			return err
+		}
+	}
+	if err := c.WriteJSON(&h); err != nil {
+		return err
+	}
+
+	digest := opts.newHash()
+	buf := make([]byte, opts.chunkSize())
+	pos := h.Offset
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			digest.Write(buf[:n])
+			if err := c.WriteMessage(BinaryMessage, buf[:n]); err != nil {
+				return err
+			}
+			pos += int64(n)
+			opts.progress(pos, h.Size)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.WriteJSON(&transferTrailer{
+		Length:   pos - h.Offset,
+		Checksum: hex.EncodeToString(digest.Sum(nil)),
+	})
+}
+
+// ReadTransfer reads a transfer sent by the peer with WriteTransfer. The open
+// function is called with the received header and returns the writer for the
+// data. Use the header offset to resume a previous transfer.
+//
+// ReadTransfer returns ErrTransferChecksum if the received data does not
+// match the checksum sent by the peer. The checksum covers the received data
+// only, not data written by an earlier transfer that this one resumes.
+func (c *Conn) ReadTransfer(open func(h *TransferHeader) (io.Writer, error), opts *TransferOptions) (*TransferHeader, error) {
+	var h TransferHeader
+	if err := c.readTransferJSON(&h); err != nil {
+		return nil, err
+	}
+	w, err := open(&h)
+	if err != nil {
+		return &h, err
+	}
+
+	digest := opts.newHash()
+	pos := h.Offset
+	for {
+		messageType, r, err := c.NextReader()
+		if err != nil {
+			return &h, err
+		}
+		if messageType == TextMessage {
+			var t transferTrailer
+			if err := json.NewDecoder(r).Decode(&t); err != nil {
+				return &h, err
+			}
+			if t.Length != pos-h.Offset || t.Checksum != hex.EncodeToString(digest.Sum(nil)) {
+				return &h, ErrTransferChecksum
+			}
+			return &h, nil
+		}
+		n, err := io.Copy(io.MultiWriter(w, digest), r)
+		pos += n
+		if err != nil {
+			return &h, err
+		}
+		opts.progress(pos, h.Size)
+	}
+}
+
+func (c *Conn) readTransferJSON(v interface{}) error {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	if messageType != TextMessage {
+		return errTransferProtocol
+	}
+	return json.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, offset := range []int64{0, 1, 40000} {
+		var buf bytes.Buffer
+		c := fakeNetConn{&buf, &buf}
+		wc := newConn(c, true, 1024, 1024)
+		rc := newConn(c, false, 1024, 1024)
+
+		opts := &TransferOptions{ChunkSize: 4096}
+		h := TransferHeader{Name: "data", Size: int64(len(data)), Offset: offset}
+		if err := wc.WriteTransfer(h, bytes.NewReader(data), opts); err != nil {
+			t.Fatalf("WriteTransfer(offset=%d) returned %v", offset, err)
+		}
+
+		var got bytes.Buffer
+		var last int64
+		opts.Progress = func(pos, size int64) { last = pos }
+		rh, err := rc.ReadTransfer(func(h *TransferHeader) (io.Writer, error) {
+			return &got, nil
+		}, opts)
+		if err != nil {
+			t.Fatalf("ReadTransfer(offset=%d) returned %v", offset, err)
+		}
+		if *rh != h {
+			t.Errorf("header=%+v, want %+v", *rh, h)
+		}
+		if !bytes.Equal(got.Bytes(), data[offset:]) {
+			t.Errorf("data mismatch for offset %d", offset)
+		}
+		if last != int64(len(data)) {
+			t.Errorf("last progress=%d, want %d", last, len(data))
+		}
+	}
+}
+
+func TestTransferChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	c := fakeNetConn{&buf, &buf}
+	wc := newConn(c, true, 1024, 1024)
+	rc := newConn(c, false, 1024, 1024)
+
+	wc.WriteJSON(&TransferHeader{Name: "data", Size: 3})
+	wc.WriteMessage(BinaryMessage, []byte("abc"))
+	wc.WriteJSON(&transferTrailer{Length: 3, Checksum: "bad"})
+
+	_, err := rc.ReadTransfer(func(h *TransferHeader) (io.Writer, error) {
+		return &bytes.Buffer{}, nil
+	}, nil)
+	if err != ErrTransferChecksum {
+		t.Fatalf("ReadTransfer returned %v, want %v", err, ErrTransferChecksum)
+	}
+}
@@ -160,6 +160,11 @@ headers:
 			var t string
 			t, s = nextToken(skipSpace(s))
 			if t == "" {
+				if strings.HasPrefix(s, ",") {
+					// Skip empty list element.
+					s = s[1:]
+					continue
+				}
 				continue headers
 			}
 			s = skipSpace(s)
@@ -178,7 +183,7 @@ headers:
 	return false
 }
 
-// parseExtensiosn parses WebSocket extensions from a header.
+// parseExtensions parses WebSocket extensions from a header.
 func parseExtensions(header http.Header) []map[string]string {
 	// From RFC 6455:
 	//
@@ -199,6 +204,11 @@ headers:
 			var t string
 			t, s = nextToken(skipSpace(s))
 			if t == "" {
+				if strings.HasPrefix(s, ",") {
+					// Skip empty list element.
+					s = s[1:]
+					continue
+				}
 				continue headers
 			}
 			ext := map[string]string{"": t}
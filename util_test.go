@@ -40,6 +40,7 @@ var tokenListContainsValueTests = []struct {
 	{"websocket x", false},
 	{"other,websocket,more", true},
 	{"other, websocket, more", true},
+	{", , websocket", true},
 }
 
 func TestTokenListContainsValue(t *testing.T) {
@@ -79,6 +80,9 @@ var parseExtensionTests = []struct {
 	{`permessage-deflate; client_max_window_bits; server_max_window_bits=10 , permessage-deflate; client_max_window_bits`, []map[string]string{
 		{"": "permessage-deflate", "client_max_window_bits": "", "server_max_window_bits": "10"},
 		{"": "permessage-deflate", "client_max_window_bits": ""}}},
+	{`foo, , bar`, []map[string]string{
+		{"": "foo"},
+		{"": "bar"}}},
 	{"permessage-deflate; server_no_context_takeover; client_max_window_bits=15", []map[string]string{
 		{"": "permessage-deflate", "server_no_context_takeover": "", "client_max_window_bits": "15"},
 	}},
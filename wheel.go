@@ -0,0 +1,113 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// keepaliveWheel schedules the pings and pong checks of all keepalives.
+var keepaliveWheel = newTimerWheel(10*time.Millisecond, 512)
+
+// timerWheel calls functions after a delay. The timers are kept in a hashed
+// timing wheel that is advanced by a single goroutine, so that a large
+// number of connections does not create a runtime timer and goroutine for
+// each connection. The goroutine runs only while timers are scheduled.
+//
+// A timer fires between its delay and its delay plus one tick. The functions
+// are called on the wheel's goroutine and must not block.
+type timerWheel struct {
+	tick time.Duration
+
+	mu      sync.Mutex
+	slots   [][]*wheelTimer
+	pos     int  // index of the slot for the current tick
+	n       int  // number of scheduled timers
+	running bool // whether the goroutine is running
+}
+
+type wheelTimer struct {
+	f      func()
+	rounds int  // number of turns of the wheel before the timer fires
+	done   bool // set when the timer fires or is stopped, guarded by mu
+}
+
+func newTimerWheel(tick time.Duration, size int) *timerWheel {
+	return &timerWheel{tick: tick, slots: make([][]*wheelTimer, size)}
+}
+
+// afterFunc calls f after the duration d.
+func (w *timerWheel) afterFunc(d time.Duration, f func()) *wheelTimer {
+	// Add a tick because the current tick is partially elapsed.
+	ticks := int((d+w.tick-1)/w.tick) + 1
+	t := &wheelTimer{f: f, rounds: (ticks - 1) / len(w.slots)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := (w.pos + ticks) % len(w.slots)
+	w.slots[i] = append(w.slots[i], t)
+	w.n++
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	return t
+}
+
+// stop prevents the timer from firing. The timer is removed from its slot
+// when the wheel reaches the slot.
+func (w *timerWheel) stop(t *wheelTimer) {
+	w.mu.Lock()
+	if !t.done {
+		t.done = true
+		w.n--
+	}
+	w.mu.Unlock()
+}
+
+func (w *timerWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	var due []*wheelTimer
+	for range ticker.C {
+		w.mu.Lock()
+		if w.n == 0 {
+			// All remaining timers are stopped.
+			for i := range w.slots {
+				w.slots[i] = nil
+			}
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		w.pos = (w.pos + 1) % len(w.slots)
+		slot := w.slots[w.pos]
+		kept := slot[:0]
+		for _, t := range slot {
+			switch {
+			case t.done:
+			case t.rounds > 0:
+				t.rounds--
+				kept = append(kept, t)
+			default:
+				t.done = true
+				w.n--
+				due = append(due, t)
+			}
+		}
+		for i := len(kept); i < len(slot); i++ {
+			slot[i] = nil
+		}
+		w.slots[w.pos] = kept
+		w.mu.Unlock()
+
+		for i, t := range due {
+			t.f()
+			due[i] = nil
+		}
+		due = due[:0]
+	}
+}
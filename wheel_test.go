@@ -0,0 +1,53 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerWheel(t *testing.T) {
+	w := newTimerWheel(time.Millisecond, 8)
+	fired := make(chan time.Duration, 3)
+	start := time.Now()
+	for _, d := range []time.Duration{20 * time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond} {
+		d := d
+		w.afterFunc(d, func() {
+			if since := time.Since(start); since < d {
+				t.Errorf("timer for %v fired after %v", d, since)
+			}
+			fired <- d
+		})
+	}
+	stopped := w.afterFunc(3*time.Millisecond, func() { t.Error("stopped timer fired") })
+	w.stop(stopped)
+
+	for _, want := range []time.Duration{2 * time.Millisecond, 5 * time.Millisecond, 20 * time.Millisecond} {
+		select {
+		case d := <-fired:
+			if d != want {
+				t.Errorf("fired %v, want %v", d, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timer for %v did not fire", want)
+		}
+	}
+
+	// The goroutine exits when no timers are scheduled.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mu.Lock()
+		running := w.running
+		w.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("wheel goroutine running without timers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
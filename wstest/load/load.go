@@ -0,0 +1,270 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package load runs simulated WebSocket clients against a server.
+//
+// Use the package in Go tests and benchmarks to check server behavior with
+// many concurrent clients:
+//
+//	result := load.Run(load.Config{
+//	    URL:     url,
+//	    Clients: 100,
+//	    RampUp:  time.Second,
+//	    Script: []load.Step{
+//	        {Send: []byte("hello")},
+//	        {Receive: true, Check: load.ExpectMessage(websocket.TextMessage, []byte("hello"))},
+//	    },
+//	})
+//	if err := result.Err(); err != nil {
+//	    t.Fatal(err)
+//	}
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Step is a step in a client script. A step sends a message, receives a
+// message or both, in that order. The step pauses after the messages are sent
+// and received.
+type Step struct {
+	// MessageType is the type of the message to send. If zero, then
+	// websocket.TextMessage is used.
+	MessageType int
+
+	// Send is the message to send. If nil, then no message is sent.
+	Send []byte
+
+	// Receive specifies that the step reads a message.
+	Receive bool
+
+	// Check is called with the received message. If Check returns a non-nil
+	// error, then the client stops with the error.
+	Check func(messageType int, p []byte) error
+
+	// Pause specifies how long to wait after the step.
+	Pause time.Duration
+}
+
+// ExpectMessage returns a check function that compares the received message
+// with the given message type and data.
+func ExpectMessage(messageType int, data []byte) func(int, []byte) error {
+	return func(mt int, p []byte) error {
+		if mt != messageType || !bytes.Equal(p, data) {
+			return fmt.Errorf("load: received message %d %q, want %d %q", mt, p, messageType, data)
+		}
+		return nil
+	}
+}
+
+// Config specifies a load simulation.
+type Config struct {
+	// URL is the ws or wss URL of the server.
+	URL string
+
+	// Header is the request header sent in the opening handshake.
+	Header http.Header
+
+	// Dialer is used to connect to the server. If nil,
+	// websocket.DefaultDialer is used.
+	Dialer *websocket.Dialer
+
+	// Clients is the number of simulated clients.
+	Clients int
+
+	// RampUp specifies the duration over which client starts are evenly
+	// spread. If zero, then all clients are started at once.
+	RampUp time.Duration
+
+	// Iterations specifies the number of times each client runs the script.
+	// If zero, then the script is run once.
+	Iterations int
+
+	// Script is the sequence of steps run by each client.
+	Script []Step
+
+	// Timeout specifies the read and write deadline for each step. If
+	// zero, then a default of ten seconds is used.
+	Timeout time.Duration
+
+	// ClientDone is called after each client completes. Use ClientDone to
+	// check per client assertions while the simulation runs. ClientDone can
+	// be called concurrently from multiple goroutines.
+	ClientDone func(r *ClientResult)
+}
+
+// ClientResult is the result for a simulated client.
+type ClientResult struct {
+	// ID is the index of the client, from zero to Config.Clients-1.
+	ID int
+
+	// ConnectTime is the time taken for the opening handshake.
+	ConnectTime time.Duration
+
+	// Sent and Received are the number of messages sent and received.
+	Sent, Received int
+
+	// Latencies are the times from the last message sent to each message
+	// received.
+	Latencies []time.Duration
+
+	// Err is the error that stopped the client, if any.
+	Err error
+}
+
+// Result is the result of a simulation.
+type Result struct {
+	// Clients holds the result for each client, indexed by client ID.
+	Clients []ClientResult
+
+	// Duration is the total time for the simulation.
+	Duration time.Duration
+}
+
+// Err returns the first client error, if any.
+func (r *Result) Err() error {
+	for i := range r.Clients {
+		if err := r.Clients[i].Err; err != nil {
+			return fmt.Errorf("load: client %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Failed returns the number of clients that stopped with an error.
+func (r *Result) Failed() int {
+	n := 0
+	for i := range r.Clients {
+		if r.Clients[i].Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Latency returns the latency at percentile p, in the range 0 to 100, over
+// all clients.
+func (r *Result) Latency(p float64) time.Duration {
+	var all []time.Duration
+	for i := range r.Clients {
+		all = append(all, r.Clients[i].Latencies...)
+	}
+	if len(all) == 0 {
+		return 0
+	}
+	sort.Sort(durations(all))
+	i := int(p / 100 * float64(len(all)-1))
+	if i < 0 {
+		i = 0
+	} else if i >= len(all) {
+		i = len(all) - 1
+	}
+	return all[i]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// Run runs the simulation and waits for all clients to complete.
+func Run(cfg Config) *Result {
+	result := &Result{Clients: make([]ClientResult, cfg.Clients)}
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients; i++ {
+		if cfg.RampUp > 0 && i > 0 {
+			time.Sleep(cfg.RampUp / time.Duration(cfg.Clients))
+		}
+		result.Clients[i].ID = i
+		wg.Add(1)
+		go func(r *ClientResult) {
+			defer wg.Done()
+			runClient(&cfg, r)
+			if cfg.ClientDone != nil {
+				cfg.ClientDone(r)
+			}
+		}(&result.Clients[i])
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start)
+	return result
+}
+
+func runClient(cfg *Config, r *ClientResult) {
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	iterations := cfg.Iterations
+	if iterations <= 0 {
+		iterations = 1
+	}
+
+	start := time.Now()
+	c, _, err := dialer.Dial(cfg.URL, cfg.Header)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	defer c.Close()
+	r.ConnectTime = time.Since(start)
+
+	var lastSent time.Time
+	for i := 0; i < iterations; i++ {
+		for _, step := range cfg.Script {
+			if step.Send != nil {
+				messageType := step.MessageType
+				if messageType == 0 {
+					messageType = websocket.TextMessage
+				}
+				c.SetWriteDeadline(time.Now().Add(timeout))
+				if err := c.WriteMessage(messageType, step.Send); err != nil {
+					r.Err = err
+					return
+				}
+				lastSent = time.Now()
+				r.Sent++
+			}
+			if step.Receive {
+				c.SetReadDeadline(time.Now().Add(timeout))
+				mt, p, err := c.ReadMessage()
+				if err != nil {
+					r.Err = err
+					return
+				}
+				r.Received++
+				if !lastSent.IsZero() {
+					r.Latencies = append(r.Latencies, time.Since(lastSent))
+				}
+				if step.Check != nil {
+					if err := step.Check(mt, p); err != nil {
+						r.Err = err
+						return
+					}
+				}
+			}
+			if step.Pause > 0 {
+				time.Sleep(step.Pause)
+			}
+		}
+	}
+
+	c.SetWriteDeadline(time.Now().Add(timeout))
+	c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
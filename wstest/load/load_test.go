@@ -0,0 +1,86 @@
+// Copyright 2018 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func newEchoServer() *httptest.Server {
+	var upgrader websocket.Upgrader
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(p) == "bad" {
+				p = []byte("unexpected")
+			}
+			if err := c.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestRun(t *testing.T) {
+	s := newEchoServer()
+	defer s.Close()
+
+	var done int32
+	result := Run(Config{
+		URL:        "ws" + strings.TrimPrefix(s.URL, "http"),
+		Clients:    10,
+		Iterations: 3,
+		Script: []Step{
+			{Send: []byte("hello")},
+			{Receive: true, Check: ExpectMessage(websocket.TextMessage, []byte("hello"))},
+			{MessageType: websocket.BinaryMessage, Send: []byte{1, 2, 3}, Receive: true, Check: ExpectMessage(websocket.BinaryMessage, []byte{1, 2, 3})},
+		},
+		ClientDone: func(r *ClientResult) { atomic.AddInt32(&done, 1) },
+	})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if done != 10 {
+		t.Errorf("ClientDone called %d times, want 10", done)
+	}
+	for _, r := range result.Clients {
+		if r.Sent != 6 || r.Received != 6 || len(r.Latencies) != 6 {
+			t.Errorf("client %d: sent=%d, received=%d, latencies=%d, want 6", r.ID, r.Sent, r.Received, len(r.Latencies))
+		}
+	}
+	if result.Latency(50) <= 0 {
+		t.Errorf("Latency(50) = %v, want > 0", result.Latency(50))
+	}
+}
+
+func TestRunCheckFailure(t *testing.T) {
+	s := newEchoServer()
+	defer s.Close()
+
+	result := Run(Config{
+		URL:     "ws" + strings.TrimPrefix(s.URL, "http"),
+		Clients: 3,
+		Script: []Step{
+			{Send: []byte("bad"), Receive: true, Check: ExpectMessage(websocket.TextMessage, []byte("bad"))},
+		},
+	})
+	if result.Failed() != 3 || result.Err() == nil {
+		t.Errorf("Failed() = %d, Err() = %v, want 3 failures", result.Failed(), result.Err())
+	}
+}